@@ -38,6 +38,10 @@ type AccountEngine interface {
 	GetBlocked(ctx context.Context, i Instrument) int64
 	PostOrder(ctx context.Context, instrument Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (Order, error)
 	CancelOrder(ctx context.Context, orderId string) (time.Time, error)
+	// выставляет стоп-заявку, возвращает её идентификатор. Нулевой expireDate - заявка действует до отмены
+	PostStopOrder(ctx context.Context, instrument Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error)
+	GetStopOrders(ctx context.Context) ([]*StopOrder, error)
+	CancelStopOrder(ctx context.Context, stopOrderId string) (time.Time, error)
 	GetClient() Client // возвращает интерфейс Client, в рамках которого создан данный счёт
 }
 
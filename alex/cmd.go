@@ -11,7 +11,12 @@ var сommands = []*cli.Command{
 		Name:   "load",
 		Usage:  "Загрузка исторических свечей  (Скачать данные в csv)",
 		Action: load,
-		Flags:  append(connectionFlags, dataFlag, fromFlag, toFlag, figisFlag, candlesPeriodFlag),
+		Flags:  append(connectionFlags, dataFlag, storageFlag, fromFlag, toFlag, figisFlag, tickersFlag, candlesPeriodFlag),
+	}, {
+		Name:   "record",
+		Usage:  "Записывать поток рыночных данных (свечи, стаканы, сделки, последние цены) в каталог data/record",
+		Action: record,
+		Flags:  recordFlags,
 	}, {
 		Name:  "online",
 		Usage: "Отслеживать данные по торгам в режиме реального времени",
@@ -19,7 +24,7 @@ var сommands = []*cli.Command{
 			Name:   "candle",
 			Usage:  "Отслеживать данные по свечам",
 			Action: onlineCandle,
-			Flags:  connectionFlags,
+			Flags:  append(connectionFlags, figisFlag, tickersFlag, candlesPeriodFlag),
 		}},
 	}, {
 		Name:   "instruments",
@@ -39,20 +44,44 @@ var сommands = []*cli.Command{
 				Name:   "rsi",
 				Usage:  "Запустить RSI робота на выбранном счёте (если будет указан боевой счёт, то робот начнёт торговать на нём)",
 				Action: botRun,
-				Flags:  append(connectionFlags, accountFlag, figisFlag, candlesPeriodFlag, timeframe, maxPosition, rsi4buy, rsi4sell),
+				Flags:  append(connectionFlags, accountFlag, figisFlag, tickersFlag, candlesPeriodFlag, timeframe, maxPosition, rsi4buy, rsi4sell, staleThresholdFlag),
 			},
 			{
 				Name:   "BestInOrderbook",
 				Usage:  "Запустить BestInOrderbook робота на выбранном счёте (если будет указан боевой счёт, то робот начнёт торговать на нём)",
 				Action: botRunBestInOrderbookBot,
-				Flags:  append(connectionFlags, accountFlag, figisFlag, maxPosition),
+				Flags:  append(connectionFlags, accountFlag, figisFlag, tickersFlag, maxPosition, staleThresholdFlag),
 			},
 			{
 				Name:   "history",
 				Usage:  "Протестировать робота RSI на истории. История должна быть заранее скачана командой load.",
 				Action: botHistory,
-				Flags:  []cli.Flag{dataFlag, fromFlag, toFlag, figisFlag, timeframe, maxPosition, rsi4buy, rsi4sell},
+				Flags:  append([]cli.Flag{dataFlag, storageFlag, fromFlag, toFlag, historyFigisFlag, candlesPeriodFlag, timeframe, maxPosition, rsi4buy, rsi4sell}, lookupFlags...),
 			}},
+	}, {
+		Name:  "data",
+		Usage: "Группа команд для работы со скаченной историей свечей",
+		Subcommands: []*cli.Command{{
+			Name:   "check",
+			Usage:  "Проверить качество скаченной истории: пропуски, дубли, порядок, OHLC, свечи без объёма, скачки цены",
+			Action: dataCheck,
+			Flags:  append(dataCheckFlags, lookupFlags...),
+		}, {
+			Name:   "resample",
+			Usage:  "Построить свечи старшего периода (10m, 30m, 4h, неделя...) из скаченных свечей младшего периода",
+			Action: dataResample,
+			Flags:  append(dataResampleFlags, lookupFlags...),
+		}, {
+			Name:   "import",
+			Usage:  "Импортировать свечи из csv файлов других поставщиков (Финам, MOEX ISS, произвольный формат)",
+			Action: dataImport,
+			Flags:  append(dataImportFlags, lookupFlags...),
+		}, {
+			Name:   "convert",
+			Usage:  "Переложить свечи из одного формата хранилища в другой",
+			Action: dataConvert,
+			Flags:  append(dataConvertFlags, lookupFlags...),
+		}},
 	}, {
 		Name:  "sandbox",
 		Usage: "Группа команд по работа со счетами песочницы",
@@ -72,5 +101,66 @@ var сommands = []*cli.Command{
 			Action: sandboxPayIn,
 			Flags:  append(connectionFlags, accountFlag, rubFlag),
 		}},
+	}, {
+		Name:   "portfolio",
+		Usage:  "Портфель по счёту: позиции с ценами и доходностью, итоги по типам инструментов",
+		Action: portfolio,
+		Flags:  append(connectionFlags, accountFlag),
+	}, {
+		Name:   "positions",
+		Usage:  "Позиции по счёту: деньги и инструменты с учётом заблокированных",
+		Action: positions,
+		Flags:  append(connectionFlags, accountFlag),
+	}, {
+		Name:   "operations",
+		Usage:  "Выгрузить операции по счёту или брокерский отчёт за период",
+		Action: operations,
+		Flags:  operationsFlags,
+	}, {
+		Name:  "orders",
+		Usage: "Группа команд по ручному управлению заявками",
+		Subcommands: []*cli.Command{{
+			Name:   "list",
+			Usage:  "Список активных заявок по счёту",
+			Action: ordersList,
+			Flags:  append(connectionFlags, accountFlag),
+		}, {
+			Name:      "cancel",
+			Usage:     "Отменить заявки по идентификаторам, по инструментам (--figi, --ticker) или все (--all)",
+			ArgsUsage: "[<id>...]",
+			Action:    ordersCancel,
+			Flags:     ordersCancelFlags,
+		}, {
+			Name:   "post",
+			Usage:  "Выставить лимитную (--price) или рыночную (--market) заявку",
+			Action: ordersPost,
+			Flags:  ordersPostFlags,
+		}, {
+			Name:      "state",
+			Usage:     "Статус заявки",
+			ArgsUsage: "<id>",
+			Action:    ordersState,
+			Flags:     append(connectionFlags, accountFlag),
+		}},
+	}, {
+		Name:  "stop-orders",
+		Usage: "Группа команд по работе со стоп-заявками",
+		Subcommands: []*cli.Command{{
+			Name:   "list",
+			Usage:  "Список активных стоп-заявок по счёту",
+			Action: stopOrdersList,
+			Flags:  append(connectionFlags, accountFlag),
+		}, {
+			Name:      "cancel",
+			Usage:     "Отменить стоп-заявки",
+			ArgsUsage: "<id> [<id>...]",
+			Action:    stopOrdersCancel,
+			Flags:     append(connectionFlags, accountFlag),
+		}},
+	}, {
+		Name:   "fake-server",
+		Usage:  "Запустить локальный fake сервер api для тестов: инструменты, счёт, заявки и цены в памяти",
+		Action: fakeServer,
+		Flags:  fakeServerFlags,
 	},
 }
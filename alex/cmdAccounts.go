@@ -12,7 +12,7 @@ import (
 )
 
 func accounts(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 
 	if err := t.Open(c.Context); err != nil {
 		l.Fatal("не смог открыть соединение", zap.Error(err))
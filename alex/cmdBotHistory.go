@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -13,21 +14,36 @@ import (
 )
 
 func botHistory(c *cli.Context) error {
+	store, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
 	h := history.NewClient(
 		c.String("data"),
-		*c.Timestamp("from"),
-		*c.Timestamp("to"),
+		timestamp(c, "from"),
+		timestamp(c, "to"),
 	)
+	h.SetCandleStore(store)
+	h.SetCandlesPeriod(c.Duration("candles-period"))
+	// роботу при старте нужно timeframe+1 свечей до from
+	h.SetLookback(history.DefaultLookback + c.Duration("candles-period")*time.Duration(c.Int("timeframe")+1))
 	var allBots alex.Bots
 
-	for _, figi := range c.StringSlice("figi") {
+	figis, err := lookupFigis(c)
+	if err != nil {
+		return err
+	}
+	if len(figis) == 0 {
+		return errors.New("не указан инструмент: --figi или --ticker")
+	}
+	for _, figi := range figis {
 		err := h.LoadData(figi)
 		if err != nil {
 			l.Panic("не смог загрузить данные", zap.Error(err))
 			return err
 		}
 
-		name := fmt.Sprintf("rsi-%s-%s-%d", figi, time.Minute, c.Int("timeframe"))
+		name := fmt.Sprintf("rsi-%s-%s-%d", figi, c.Duration("candles-period"), c.Int("timeframe"))
 		account := h.CreateAccount(name)
 
 		b := bots.NewRSIBot(c.Context)
@@ -36,7 +52,7 @@ func botHistory(c *cli.Context) error {
 			account,
 			h.GetInstrument(figi),
 			map[string]any{
-				"candles-period": time.Minute,
+				"candles-period": c.Duration("candles-period"),
 				"timeframe":      c.Int("timeframe"),
 				"rsi4buy":        c.Int("rsi4buy"),
 				"rsi4sell":       c.Int("rsi4sell"),
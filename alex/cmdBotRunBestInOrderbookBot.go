@@ -11,11 +11,10 @@ import (
 
 	"github.com/go-trading/alex"
 	"github.com/go-trading/alex/bots"
-	"github.com/go-trading/alex/tinkoff"
 )
 
 func botRunBestInOrderbookBot(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 	if err := t.Open(c.Context); err != nil {
 		l.Fatal("не смог открыть соединение", zap.Error(err))
 	}
@@ -25,7 +24,12 @@ func botRunBestInOrderbookBot(c *cli.Context) error {
 
 	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
 
-	for _, figi := range c.StringSlice("figi") {
+	figis, err := instrumentFigis(c, t)
+	if err != nil {
+		return err
+	}
+	for _, figi := range figis {
+		t.GetInstrument(figi).SetStaleThreshold(c.Duration("stale-threshold"))
 		b := bots.NewBestInOrderbookBot(c.Context)
 		err := b.Config(alex.NewConfig(
 			fmt.Sprintf("rsi-%s-%s-%d", figi, c.Duration("candles-period"), c.Int("timeframe")),
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sdcoffey/techan"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/exp/slices"
+
+	"github.com/go-trading/alex"
+)
+
+var (
+	dataFigiFlag = &cli.StringSliceFlag{
+		Name:  "figi",
+		Usage: "Обработать только указанные инструменты (или инструменты, указанные через ticker). По умолчанию обрабатываются все скаченные",
+	}
+	sessionStartFlag = &cli.DurationFlag{
+		Name:  "session-start",
+		Value: 10 * time.Hour,
+		Usage: "Начало торговой сессии от полуночи",
+	}
+	sessionEndFlag = &cli.DurationFlag{
+		Name:  "session-end",
+		Value: 18*time.Hour + 40*time.Minute,
+		Usage: "Окончание торговой сессии от полуночи",
+	}
+)
+
+var dataCheckFlags = []cli.Flag{
+	dataFlag,
+	storageFlag,
+	dataFigiFlag,
+	&cli.DurationFlag{
+		Name:  "candles-period",
+		Usage: "Проверить только свечи указанного размера. По умолчанию проверяются все",
+	},
+	sessionStartFlag,
+	sessionEndFlag,
+	&cli.IntFlag{
+		Name:  "max-zero-volume",
+		Value: 5,
+		Usage: "Сколько свечей подряд без объёма не считать проблемой",
+	},
+	&cli.Float64Flag{
+		Name:  "max-jump",
+		Value: 0.1,
+		Usage: "Изменение цены между соседними свечами (в долях), начиная с которого оно считается подозрительным",
+	},
+	&cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Исправить то, что можно исправить (порядок, дубли, OHLC), и перезаписать данные",
+	},
+	&cli.BoolFlag{
+		Name:  "verbose",
+		Usage: "Выводить каждую найденную проблему, а не только итог по инструменту",
+	},
+}
+
+var dataResampleFlags = []cli.Flag{
+	dataFlag,
+	storageFlag,
+	dataFigiFlag,
+	&cli.DurationFlag{
+		Name:  "from-period",
+		Value: time.Minute,
+		Usage: "Размер исходных свечей",
+	},
+	&cli.DurationFlag{
+		Name:     "to-period",
+		Required: true,
+		Usage:    "Размер свечей, которые нужно построить. Должен быть кратен from-period и делить сутки без остатка, например 10m, 30m, 4h, 24h, 168h (неделя)",
+	},
+	sessionStartFlag,
+}
+
+var dataConvertFlags = []cli.Flag{
+	dataFlag,
+	storageFlag,
+	dataFigiFlag,
+	&cli.StringFlag{
+		Name:     "to-storage",
+		Required: true,
+		Usage:    "Формат хранилища, в который нужно переложить свечи: csv или bin",
+	},
+	&cli.PathFlag{
+		Name:  "to-data",
+		Usage: "Каталог для сконвертированных свечей. По умолчанию тот же, что и data",
+	},
+}
+
+var dataImportFlags = []cli.Flag{
+	dataFlag,
+	storageFlag,
+	&cli.StringSliceFlag{
+		Name:     "file",
+		Usage:    "Файл, из которого нужно импортировать свечи. Можно указать несколько раз",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "figi",
+		Usage: "Идентификатор инструмента, под которым сохранить свечи. Вместо него можно указать ticker",
+	},
+	candlesPeriodFlag,
+	&cli.StringFlag{
+		Name:  "format",
+		Value: "finam",
+		Usage: "Формат файла: finam, moex или custom. Аргументы ниже переопределяют настройки формата",
+	},
+	&cli.StringFlag{
+		Name:  "columns",
+		Usage: "Сопоставление полей свечи столбцам файла по имени или номеру с 0, например date:<DATE>,time:<TIME>,open:2,high:3,low:4,close:5,volume:6",
+	},
+	&cli.StringFlag{
+		Name:  "delimiter",
+		Usage: "Разделитель столбцов",
+	},
+	&cli.StringFlag{
+		Name:  "date-format",
+		Usage: "Формат даты (или даты и времени) в нотации go, например 20060102 или 2006-01-02 15:04:05",
+	},
+	&cli.StringFlag{
+		Name:  "time-format",
+		Usage: "Формат времени в нотации go, если время в отдельном столбце, например 150405",
+	},
+	&cli.StringFlag{
+		Name:  "file-timezone",
+		Usage: "Часовой пояс, в котором записано время в файле, например Europe/Moscow или UTC",
+	},
+	&cli.IntFlag{
+		Name:  "lot",
+		Usage: "Лотность инструмента. Нужна для формата moex, в котором объём в штуках, а не в лотах",
+	},
+	sessionStartFlag,
+	sessionEndFlag,
+	&cli.BoolFlag{
+		Name:  "repair",
+		Usage: "Исправить то, что можно исправить (порядок, дубли, OHLC). Без этого файл с такими проблемами не импортируется",
+	},
+}
+
+// серии свечей из хранилища, отфильтрованные по аргументам figi, ticker и периоду (0 - любой период)
+func selectSeries(c *cli.Context, store alex.CandleStore, period time.Duration) ([]alex.CandleSeriesInfo, error) {
+	list, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	figis, err := lookupFigis(c)
+	if err != nil {
+		return nil, err
+	}
+	filter := c.IsSet("figi") || c.IsSet("ticker")
+	var result []alex.CandleSeriesInfo
+	for _, info := range list {
+		if filter && !slices.Contains(figis, info.Figi) {
+			continue
+		}
+		if period != 0 && period != info.Period {
+			continue
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+func dataCheck(c *cli.Context) error {
+	store, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
+	cfg := alex.NewDataCheckConfig()
+	cfg.SessionStart = c.Duration("session-start")
+	cfg.SessionEnd = c.Duration("session-end")
+	cfg.MaxZeroVolumeRun = c.Int("max-zero-volume")
+	cfg.MaxPriceJump = c.Float64("max-jump")
+
+	list, err := selectSeries(c, store, c.Duration("candles-period"))
+	if err != nil {
+		return err
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Figi\tPeriod\tCandles\tmalformed\tgap\tduplicate\tout-of-order\tohlc\tzero-volume\tprice-jump\tRepaired\t")
+	for _, info := range list {
+		candles, issues, err := store.LoadRaw(info.Figi, info.Period)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, alex.CheckSeries(candles, info.Period, cfg)...)
+
+		counts := make(map[alex.DataIssueType]int)
+		repairable := false
+		for _, issue := range issues {
+			counts[issue.Type]++
+			repairable = repairable || issue.Repairable
+			if c.Bool("verbose") {
+				fmt.Printf("%s %s %s\n", info.Figi, info.Period, issue)
+			}
+		}
+
+		repaired := ""
+		if c.Bool("repair") && repairable {
+			if err := store.Replace(info.Figi, info.Period, alex.RepairSeries(candles)); err != nil {
+				return err
+			}
+			repaired = "yes"
+		}
+
+		fmt.Fprintf(tbl, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n",
+			info.Figi, info.Period, len(candles),
+			counts[alex.DataIssue_MALFORMED],
+			counts[alex.DataIssue_GAP],
+			counts[alex.DataIssue_DUPLICATE],
+			counts[alex.DataIssue_OUT_OF_ORDER],
+			counts[alex.DataIssue_OHLC],
+			counts[alex.DataIssue_ZERO_VOLUME],
+			counts[alex.DataIssue_PRICE_JUMP],
+			repaired,
+		)
+	}
+	tbl.Flush()
+	return nil
+}
+
+func dataResample(c *cli.Context) error {
+	fromPeriod, toPeriod := c.Duration("from-period"), c.Duration("to-period")
+	if err := alex.CheckResamplePeriods(fromPeriod, toPeriod); err != nil {
+		return err
+	}
+	location := alex.Location()
+	store, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
+	list, err := selectSeries(c, store, fromPeriod)
+	if err != nil {
+		return err
+	}
+	for _, info := range list {
+		series, err := store.Load(info.Figi, info.Period)
+		if err != nil {
+			return err
+		}
+		resampled := alex.ResampleSeries(series, toPeriod, location, c.Duration("session-start"))
+		if err := store.Replace(info.Figi, toPeriod, resampled); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s -> %s: %d -> %d свечей\n", info.Figi, fromPeriod, toPeriod, len(series.Candles), len(resampled.Candles))
+	}
+	return nil
+}
+
+// настройки разбора файла для импорта: формат из аргумента format, переопределённый остальными аргументами
+func importConfig(c *cli.Context) (cfg alex.CSVImportConfig, err error) {
+	if c.String("format") != "custom" {
+		preset, ok := alex.CSVImportPresets()[c.String("format")]
+		if !ok {
+			return cfg, fmt.Errorf("неизвестный формат %q", c.String("format"))
+		}
+		cfg = preset
+	}
+	if c.IsSet("columns") {
+		if cfg.Columns, err = alex.ParseImportColumns(c.String("columns")); err != nil {
+			return cfg, err
+		}
+	}
+	if c.IsSet("delimiter") {
+		delimiter := []rune(c.String("delimiter"))
+		if len(delimiter) != 1 {
+			return cfg, fmt.Errorf("разделитель должен быть одним символом")
+		}
+		cfg.Comma = delimiter[0]
+	}
+	if cfg.Comma == 0 {
+		cfg.Comma = ','
+	}
+	if c.IsSet("date-format") {
+		cfg.DateLayout = c.String("date-format")
+	}
+	if c.IsSet("time-format") {
+		cfg.TimeLayout = c.String("time-format")
+	}
+	if c.IsSet("file-timezone") {
+		if cfg.Location, err = time.LoadLocation(c.String("file-timezone")); err != nil {
+			return cfg, err
+		}
+	}
+	cfg.Lot = c.Int("lot")
+	if cfg.VolumeInShares && cfg.Lot <= 0 {
+		return cfg, fmt.Errorf("в формате %s объём в штуках, укажите лотность инструмента --lot", c.String("format"))
+	}
+	return cfg, nil
+}
+
+func dataImport(c *cli.Context) error {
+	cfg, err := importConfig(c)
+	if err != nil {
+		return err
+	}
+	store, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
+	figi, period := c.String("figi"), c.Duration("candles-period")
+	if c.IsSet("ticker") {
+		// у figi тип StringFlag, поэтому в lookupFigis попадают только тикеры
+		figis, err := lookupFigis(c)
+		if err != nil {
+			return err
+		}
+		if figi != "" || len(figis) != 1 {
+			return errors.New("свечи импортируются по одному инструменту: укажите --figi или один --ticker")
+		}
+		figi = figis[0]
+	}
+	if figi == "" {
+		return errors.New("не указан инструмент: --figi или --ticker")
+	}
+
+	var candles []*techan.Candle
+	var issues []alex.DataIssue
+	for _, fileName := range c.StringSlice("file") {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return err
+		}
+		fileCandles, fileIssues, err := alex.ImportCSV(bufio.NewReader(file), period, cfg)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+		for _, issue := range fileIssues {
+			fmt.Printf("%s %s\n", fileName, issue)
+		}
+		candles = append(candles, fileCandles...)
+		issues = append(issues, fileIssues...)
+	}
+
+	// проверяю по тем же правилам, что и скаченные данные
+	checkCfg := alex.NewDataCheckConfig()
+	checkCfg.SessionStart = c.Duration("session-start")
+	checkCfg.SessionEnd = c.Duration("session-end")
+	blocking := 0
+	for _, issue := range alex.CheckSeries(candles, period, checkCfg) {
+		fmt.Printf("%s %s %s\n", figi, period, issue)
+		if issue.Repairable {
+			blocking++
+		}
+	}
+	blocking += len(issues)
+	if blocking > 0 && !c.Bool("repair") {
+		return fmt.Errorf("найдено %d проблем в данных, для импорта с исправлением используйте --repair", blocking)
+	}
+
+	series := alex.RepairSeries(candles)
+	if err := store.Save(figi, period, series); err != nil {
+		return err
+	}
+	fmt.Printf("%s %s: импортировано %d свечей\n", figi, period, len(series.Candles))
+	return nil
+}
+
+func dataConvert(c *cli.Context) error {
+	from, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
+	toData := c.String("data")
+	if c.IsSet("to-data") {
+		toData = c.String("to-data")
+	}
+	to, err := alex.NewCandleStore(c.String("to-storage"), toData)
+	if err != nil {
+		return err
+	}
+	list, err := selectSeries(c, from, 0)
+	if err != nil {
+		return err
+	}
+	for _, info := range list {
+		series, err := from.Load(info.Figi, info.Period)
+		if err != nil {
+			return err
+		}
+		if err := to.Save(info.Figi, info.Period, series); err != nil {
+			return err
+		}
+		fmt.Printf("%s %s: %d свечей\n", info.Figi, info.Period, len(series.Candles))
+	}
+	return nil
+}
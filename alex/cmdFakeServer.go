@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex/tinkoff/fake"
+)
+
+var fakeServerFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "listen",
+		Value: "localhost:50051",
+		Usage: "host:port, на котором сервер принимает соединения",
+	},
+	&cli.StringFlag{
+		Name:  "account",
+		Value: "fake",
+		Usage: "Номер боевого счёта на сервере",
+	},
+	rubFlag,
+	&cli.PathFlag{
+		Name:  "prices",
+		Usage: "csv файл со сценарием цен, строки figi,цена. Без сценария цены меняются случайно",
+	},
+	&cli.DurationFlag{
+		Name:  "interval",
+		Value: time.Second,
+		Usage: "Пауза между изменениями цен",
+	},
+	&cli.Int64Flag{
+		Name:  "seed",
+		Value: 1,
+		Usage: "Начальное значение генератора случайных цен",
+	},
+}
+
+func fakeServer(c *cli.Context) error {
+	server := fake.New()
+	server.AddAccount(c.String("account"), "fake", big.NewDecimal(c.Float64("rub")))
+
+	var steps []fake.PriceStep
+	if c.IsSet("prices") {
+		var err error
+		if steps, err = readPriceSteps(c.Path("prices")); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", c.String("listen"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+		cancel()
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Stop()
+	}()
+	go func() {
+		var err error
+		if steps != nil {
+			err = server.PlayPrices(ctx, steps, c.Duration("interval"))
+		} else {
+			err = server.RandomWalk(ctx, c.Duration("interval"), c.Int64("seed"))
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			l.Error("сценарий цен остановлен", zap.Error(err))
+		}
+	}()
+
+	fmt.Printf("fake сервер слушает %s, счёт %s. Подключение: --api %s --plaintext --token любой\n",
+		listener.Addr(), c.String("account"), listener.Addr())
+	return server.Serve(listener)
+}
+
+// читает сценарий цен из csv файла со строками figi,цена
+func readPriceSteps(fileName string) ([]fake.PriceStep, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.Comment = '#'
+	var steps []fake.PriceStep
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения сценария цен %s: %w", fileName, err)
+		}
+		price := big.NewFromString(strings.TrimSpace(record[1]))
+		if price.NaN() {
+			return nil, fmt.Errorf("сценарий цен %s: неверная цена %q", fileName, record[1])
+		}
+		steps = append(steps, fake.PriceStep{Figi: strings.TrimSpace(record[0]), Price: price})
+	}
+	return steps, nil
+}
@@ -6,7 +6,6 @@ import (
 	"os"
 	"text/tabwriter"
 
-	"github.com/go-trading/alex/tinkoff"
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 	"github.com/urfave/cli/v2"
 )
@@ -23,7 +22,7 @@ var instrumentsFlags = []cli.Flag{
 }
 
 func instruments(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 
 	if err := t.Open(c.Context); err != nil {
 		log.Fatalf("не смог открыть соединение  %s", err)
@@ -58,12 +57,37 @@ func instruments(c *cli.Context) error {
 			fmt.Fprintf(tbl, "etf\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", e.Exchange, e.ClassCode, e.Figi, e.Isin, e.Ticker, e.Currency, e.Name)
 		}
 	}
+
+	bonds, err := t.Bonds(c.Context, list)
+	if err != nil {
+		log.Fatalf("не смог получить список облигаций  %s", err)
+	}
+	for _, b := range bonds {
+		if !c.IsSet("status") || c.Int("status") == int(b.TradingStatus) {
+			fmt.Fprintf(tbl, "bond\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", b.Exchange, b.ClassCode, b.Figi, b.Isin, b.Ticker, b.Currency, b.Name)
+		}
+	}
+
+	futures, err := t.Futures(c.Context, list)
+	if err != nil {
+		log.Fatalf("не смог получить список фьючерсов  %s", err)
+	}
+	for _, f := range futures {
+		if !c.IsSet("status") || c.Int("status") == int(f.TradingStatus) {
+			fmt.Fprintf(tbl, "future\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", f.Exchange, f.ClassCode, f.Figi, "", f.Ticker, f.Currency, f.Name)
+		}
+	}
+
+	currencies, err := t.Currencies(c.Context, list)
+	if err != nil {
+		log.Fatalf("не смог получить список валют  %s", err)
+	}
+	for _, cur := range currencies {
+		if !c.IsSet("status") || c.Int("status") == int(cur.TradingStatus) {
+			fmt.Fprintf(tbl, "currency\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", cur.Exchange, cur.ClassCode, cur.Figi, cur.Isin, cur.Ticker, cur.Currency, cur.Name)
+		}
+	}
 	tbl.Flush()
 
 	return nil
-
-	// TODO реализовать получения списка других инструментов
-	// Bonds(ctx context.Context, in *InstrumentsRequest, opts ...grpc.CallOption) (*BondsResponse, error)
-	// Currencies(ctx context.Context, in *InstrumentsRequest, opts ...grpc.CallOption) (*CurrenciesResponse, error)
-	// Futures(ctx context.Context, in *InstrumentsRequest, opts ...grpc.CallOption) (*FuturesResponse, error)
 }
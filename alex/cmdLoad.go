@@ -1,22 +1,31 @@
 package main
 
 import (
-	"github.com/go-trading/alex/tinkoff"
+	"github.com/go-trading/alex"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
 
 func load(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	store, err := alex.NewCandleStore(c.String("storage"), c.String("data"))
+	if err != nil {
+		return err
+	}
+	t := newClient(c)
+	t.SetCandleStore(store)
 
 	if err := t.Open(c.Context); err != nil {
 		l.Fatal("не смог открыть соединение", zap.Error(err))
 	}
 	defer t.Close()
 
-	for _, figi := range c.StringSlice("figi") {
+	figis, err := instrumentFigis(c, t)
+	if err != nil {
+		return err
+	}
+	for _, figi := range figis {
 		candles := t.GetInstrument(figi).GetCandles(c.Duration("candles-period"))
-		err := candles.Load(c.Context, *c.Timestamp("from"), *c.Timestamp("to"))
+		err := candles.Load(c.Context, timestamp(c, "from"), timestamp(c, "to"))
 		if err != nil {
 			l.Fatal("не смог скачать", zap.String("figi", figi), zap.Error(err))
 		}
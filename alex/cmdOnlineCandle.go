@@ -7,7 +7,6 @@ import (
 	"syscall"
 
 	"github.com/go-trading/alex"
-	"github.com/go-trading/alex/tinkoff"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
@@ -19,15 +18,19 @@ func ReadCandles(figi string, ch alex.CandleChan) {
 }
 
 func onlineCandle(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 	if err := t.Open(c.Context); err != nil {
 		panic(fmt.Sprintf("не смог открыть соединение  %s", err))
 	}
 	defer t.Close()
 
+	figis, err := instrumentFigis(c, t)
+	if err != nil {
+		return err
+	}
 	chs := make(map[string]alex.CandleChan)
-	for _, figi := range c.StringSlice("figi") {
-		ch, err := t.Instruments.Get(figi).GetCandles(c.Duration("interval")).Subscribe()
+	for _, figi := range figis {
+		ch, err := t.Instruments.Get(figi).GetCandles(c.Duration("candles-period")).Subscribe()
 		if err != nil {
 			l.DPanic("Не смог подписаться на свечи", zap.Error(err))
 		}
@@ -40,7 +43,7 @@ func onlineCandle(c *cli.Context) error {
 	<-sigc
 
 	for figi, ch := range chs {
-		err := t.Instruments.Get(figi).GetCandles(c.Duration("interval")).Unsubscribe(ch)
+		err := t.Instruments.Get(figi).GetCandles(c.Duration("candles-period")).Unsubscribe(ch)
 		if err != nil {
 			l.DPanic("Не смог отписаться", zap.Error(err))
 		}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
+)
+
+var operationsFlags = append(connectionFlags,
+	accountFlag,
+	fromFlag,
+	toFlag,
+	&cli.StringFlag{
+		Name:  "format",
+		Value: "csv",
+		Usage: "Формат вывода: csv или json",
+	},
+	&cli.PathFlag{
+		Name:  "output",
+		Usage: "Файл, в который сохранить выгрузку. По умолчанию выводится на экран",
+	},
+	&cli.BoolFlag{
+		Name:  "broker-report",
+		Usage: "Выгрузить брокерский отчёт вместо операций. Только для боевых счетов",
+	},
+)
+
+var operationsColumns = []string{"date", "id", "parent_id", "category", "type", "state", "figi", "instrument_type", "quantity", "quantity_rest", "price", "payment", "currency"}
+
+var brokerReportColumns = []string{"trade_date", "trade_id", "order_id", "figi", "ticker", "name", "exchange", "class_code", "direction", "quantity", "price", "order_amount", "total_order_amount", "broker_commission", "exchange_commission", "clearing_commission", "currency", "clear_value_date", "sec_value_date", "broker_status"}
+
+func operations(c *cli.Context) error {
+	format := c.String("format")
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("неизвестный формат %q", format)
+	}
+
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account, err := tinkoffAccount(c, t)
+	if err != nil {
+		return err
+	}
+	from, to := timestamp(c, "from"), timestamp(c, "to")
+
+	var columns []string
+	var rows [][]string
+	if c.Bool("broker-report") {
+		realAccount, ok := account.(*tinkoff.RealAccount)
+		if !ok {
+			return errors.New("брокерский отчёт есть только у боевых счетов")
+		}
+		report, err := realAccount.GetBrokerReport(c.Context, from, to)
+		if err != nil {
+			return err
+		}
+		columns, rows = brokerReportColumns, brokerReportRows(report)
+	} else {
+		ops, err := account.GetOperations(c.Context, from, to)
+		if err != nil {
+			return err
+		}
+		columns, rows = operationsColumns, operationRows(ops)
+	}
+
+	var out io.Writer = os.Stdout
+	if c.IsSet("output") {
+		f, err := os.Create(c.Path("output"))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		return writeJSONRows(out, columns, rows)
+	}
+	return writeCSVRows(out, columns, rows)
+}
+
+func operationRows(ops []*tinkoff.Operation) [][]string {
+	rows := make([][]string, len(ops))
+	for i, o := range ops {
+		rows[i] = []string{
+			formatReportTime(o.Date),
+			o.Id,
+			o.ParentOperationId,
+			o.Category.String(),
+			strings.Replace(o.OperationType.String(), "OPERATION_TYPE_", "", 1),
+			strings.Replace(o.State.String(), "OPERATION_STATE_", "", 1),
+			o.Figi,
+			o.InstrumentType,
+			strconv.FormatInt(o.Quantity, 10),
+			strconv.FormatInt(o.QuantityRest, 10),
+			formatMoneyValue(o.Price),
+			formatMoneyValue(o.Payment),
+			o.Currency,
+		}
+	}
+	return rows
+}
+
+func brokerReportRows(report []*tinkoff.BrokerReportItem) [][]string {
+	rows := make([][]string, len(report))
+	for i, r := range report {
+		currency := ""
+		if r.Price != nil {
+			currency = r.Price.Currency
+		}
+		rows[i] = []string{
+			formatReportTime(r.TradeDatetime),
+			r.TradeId,
+			r.OrderId,
+			r.Figi,
+			r.Ticker,
+			r.Name,
+			r.Exchange,
+			r.ClassCode,
+			r.Direction,
+			strconv.FormatInt(r.Quantity, 10),
+			formatMoneyValue(r.Price),
+			formatMoneyValue(r.OrderAmount),
+			formatMoneyValue(r.TotalOrderAmount),
+			formatMoneyValue(r.BrokerCommission),
+			formatMoneyValue(r.ExchangeCommission),
+			formatMoneyValue(r.ExchangeClearingCommission),
+			currency,
+			formatReportTime(r.ClearValueDate),
+			formatReportTime(r.SecValueDate),
+			r.BrokerStatus,
+		}
+	}
+	return rows
+}
+
+// время для выгрузки: RFC3339 в часовом поясе timezone, пустая строка для нулевого времени
+func formatReportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.In(alex.Location()).Format(time.RFC3339)
+}
+
+func formatMoneyValue(m *alex.Money) string {
+	if m == nil {
+		return ""
+	}
+	return m.Value.String()
+}
+
+func writeCSVRows(out io.Writer, columns []string, rows [][]string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(columns); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// массив объектов, ключи которых - имена столбцов
+func writeJSONRows(out io.Writer, columns []string, rows [][]string) error {
+	objects := make([]map[string]string, len(rows))
+	for i, row := range rows {
+		objects[i] = make(map[string]string, len(columns))
+		for j, column := range columns {
+			objects[i][column] = row[j]
+		}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(objects)
+}
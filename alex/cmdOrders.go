@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+var yesFlag = &cli.BoolFlag{
+	Name:    "yes",
+	Aliases: []string{"y"},
+	Usage:   "Не спрашивать подтверждение для боевого счёта",
+}
+
+var ordersCancelFlags = append(connectionFlags,
+	accountFlag,
+	figisFlag,
+	tickersFlag,
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "Отменить все активные заявки по счёту",
+	},
+	yesFlag,
+)
+
+var ordersPostFlags = append(connectionFlags,
+	accountFlag,
+	figisFlag,
+	tickersFlag,
+	&cli.StringFlag{
+		Name:     "side",
+		Usage:    "Направление: buy или sell",
+		Required: true,
+	},
+	&cli.Int64Flag{
+		Name:     "lots",
+		Usage:    "Количество лотов",
+		Required: true,
+	},
+	&cli.Float64Flag{
+		Name:  "price",
+		Usage: "Цена за 1 инструмент для лимитной заявки",
+	},
+	&cli.BoolFlag{
+		Name:  "market",
+		Usage: "Рыночная заявка",
+	},
+	yesFlag,
+)
+
+// для боевого счёта спрашивает подтверждение действия, если не указан аргумент yes
+func confirm(c *cli.Context, account alex.Account, action string) bool {
+	if account.GetEngineType() != alex.EngineType_REAL || c.Bool("yes") {
+		return true
+	}
+	fmt.Printf("%s на боевом счёте %s. Продолжить? [y/N] ", action, account.GetId())
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes", "д", "да":
+		return true
+	}
+	fmt.Println("отменено")
+	return false
+}
+
+func ordersList(c *cli.Context) error {
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
+	orders, err := account.GetOrders(c.Context)
+	if err != nil {
+		return err
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Id\tFigi\tTicker\tDirection\tStatus\tRequested\tExecuted\tOrderPrice\tDate\t")
+	for _, o := range orders {
+		fmt.Fprintf(tbl, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t\n",
+			o.GetOrderId(),
+			o.GetFigi(),
+			tickerByFigi(t, o.GetFigi()),
+			strings.Replace(o.GetDirection().String(), "ORDER_DIRECTION_", "", 1),
+			strings.Replace(o.GetExecutionReportStatus().String(), "EXECUTION_REPORT_STATUS_", "", 1),
+			o.GetLotsRequested(),
+			o.GetLotsExecuted(),
+			formatMoney(o.GetInitialOrderPrice()),
+			alex.FormatTime(o.GetOrderDate()),
+		)
+	}
+	tbl.Flush()
+
+	return nil
+}
+
+func ordersCancel(c *cli.Context) error {
+	ids := c.Args().Slice()
+	filterByFigi := c.IsSet("figi") || c.IsSet("ticker")
+	if len(ids) == 0 && !c.Bool("all") && !filterByFigi {
+		return errors.New("укажите идентификаторы заявок, --all или --figi")
+	}
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
+	if len(ids) == 0 {
+		figis := make(map[string]bool)
+		if filterByFigi {
+			list, err := instrumentFigis(c, t)
+			if err != nil {
+				return err
+			}
+			for _, figi := range list {
+				figis[figi] = true
+			}
+		}
+		orders, err := account.GetOrders(c.Context)
+		if err != nil {
+			return err
+		}
+		for _, o := range orders {
+			if o.IsActive() && (!filterByFigi || figis[o.GetFigi()]) {
+				ids = append(ids, o.GetOrderId())
+			}
+		}
+		if len(ids) == 0 {
+			fmt.Println("нет активных заявок для отмены")
+			return nil
+		}
+	}
+	if !confirm(c, account, fmt.Sprintf("Будет отменено заявок: %d", len(ids))) {
+		return nil
+	}
+	for _, id := range ids {
+		canceled, err := account.CancelOrder(c.Context, id)
+		if err != nil {
+			return fmt.Errorf("не смог отменить заявку %s: %w", id, err)
+		}
+		fmt.Printf("заявка %s отменена в %s\n", id, alex.FormatTime(canceled))
+	}
+
+	return nil
+}
+
+func ordersPost(c *cli.Context) error {
+	var direction proto.OrderDirection
+	switch strings.ToLower(c.String("side")) {
+	case "buy":
+		direction = proto.OrderDirection_ORDER_DIRECTION_BUY
+	case "sell":
+		direction = proto.OrderDirection_ORDER_DIRECTION_SELL
+	default:
+		return fmt.Errorf("неизвестное направление %q, ожидается buy или sell", c.String("side"))
+	}
+	if c.Int64("lots") <= 0 {
+		return errors.New("количество лотов должно быть больше нуля")
+	}
+	if c.Bool("market") == c.IsSet("price") {
+		return errors.New("укажите либо --price для лимитной заявки, либо --market для рыночной")
+	}
+	orderType, price := proto.OrderType_ORDER_TYPE_LIMIT, big.NewDecimal(c.Float64("price"))
+	if c.Bool("market") {
+		orderType, price = proto.OrderType_ORDER_TYPE_MARKET, big.ZERO
+	}
+
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	figis, err := instrumentFigis(c, t)
+	if err != nil {
+		return err
+	}
+	if len(figis) != 1 {
+		return errors.New("заявка выставляется по одному инструменту")
+	}
+	instrument := t.GetInstrument(figis[0])
+	if instrument == nil {
+		return fmt.Errorf("инструмент %s не найден", figis[0])
+	}
+	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
+
+	description := fmt.Sprintf("%s %d лот. %s (%s) %s",
+		strings.Replace(direction.String(), "ORDER_DIRECTION_", "", 1),
+		c.Int64("lots"),
+		instrument.GetTicker(),
+		instrument.GetFigi(),
+		strings.Replace(orderType.String(), "ORDER_TYPE_", "", 1),
+	)
+	if orderType == proto.OrderType_ORDER_TYPE_LIMIT {
+		description += " по " + price.String()
+	}
+	if !confirm(c, account, description) {
+		return nil
+	}
+	o, err := account.PostOrder(c.Context, instrument, c.Int64("lots"), price, direction, orderType, uuid.NewString())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("выставлена заявка %s, статус %s\n",
+		o.GetOrderId(),
+		strings.Replace(o.GetExecutionReportStatus().String(), "EXECUTION_REPORT_STATUS_", "", 1),
+	)
+
+	return nil
+}
+
+func ordersState(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return errors.New("укажите идентификатор заявки")
+	}
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account, err := tinkoffAccount(c, t)
+	if err != nil {
+		return err
+	}
+	o, err := account.GetOrderState(c.Context, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', 0)
+	fmt.Fprintf(tbl, "Id\t%s\n", o.GetOrderId())
+	fmt.Fprintf(tbl, "Figi\t%s\n", o.GetFigi())
+	fmt.Fprintf(tbl, "Ticker\t%s\n", tickerByFigi(t, o.GetFigi()))
+	fmt.Fprintf(tbl, "Type\t%s\n", strings.Replace(o.GetOrderType().String(), "ORDER_TYPE_", "", 1))
+	fmt.Fprintf(tbl, "Direction\t%s\n", strings.Replace(o.GetDirection().String(), "ORDER_DIRECTION_", "", 1))
+	fmt.Fprintf(tbl, "Status\t%s\n", strings.Replace(o.GetExecutionReportStatus().String(), "EXECUTION_REPORT_STATUS_", "", 1))
+	fmt.Fprintf(tbl, "Date\t%s\n", alex.FormatTime(o.GetOrderDate()))
+	fmt.Fprintf(tbl, "Lots\t%d / %d\n", o.GetLotsExecuted(), o.GetLotsRequested())
+	fmt.Fprintf(tbl, "Price\t%s\n", formatMoney(o.GetInitialSecurityPrice()))
+	fmt.Fprintf(tbl, "OrderPrice\t%s\n", formatMoney(o.GetInitialOrderPrice()))
+	fmt.Fprintf(tbl, "ExecutedPrice\t%s\n", formatMoney(o.GetExecutedOrderPrice()))
+	fmt.Fprintf(tbl, "AveragePrice\t%s\n", formatMoney(o.AveragePositionPrice))
+	fmt.Fprintf(tbl, "Commission\t%s\n", formatMoney(o.GetExecutedCommission()))
+	for _, s := range o.Stages {
+		fmt.Fprintf(tbl, "Trade %s\t%d x %s\n", s.TradeId, s.Quantity, formatMoney(s.Price))
+	}
+	tbl.Flush()
+
+	return nil
+}
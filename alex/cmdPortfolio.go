@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
+)
+
+// счёт из аргумента account, с методами, специфичными для tinkoff
+func tinkoffAccount(c *cli.Context, t *tinkoff.Client) (tinkoff.Account, error) {
+	a, err := t.Accounts.Get(c.Context, c.String("account"))
+	if err != nil {
+		return nil, err
+	}
+	account, ok := a.(tinkoff.Account)
+	if !ok {
+		return nil, errors.New("счёт не является счётом tinkoff")
+	}
+	return account, nil
+}
+
+// тикер инструмента, или пустая строка, если инструмента нет в справочнике
+func tickerByFigi(t *tinkoff.Client, figi string) string {
+	i := t.GetInstrument(figi)
+	if i == nil {
+		return ""
+	}
+	return i.GetTicker()
+}
+
+func formatMoney(m *alex.Money) string {
+	if m == nil {
+		return ""
+	}
+	return m.Value.FormattedString(2) + " " + m.Currency
+}
+
+func portfolio(c *cli.Context) error {
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account, err := tinkoffAccount(c, t)
+	if err != nil {
+		return err
+	}
+	p, err := account.GetPortfolio(c.Context)
+	if err != nil {
+		return err
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Type\tFigi\tTicker\tQuantity\tLots\tAveragePrice\tCurrentPrice\tExpectedYield\t")
+	for _, position := range p.Positions {
+		fmt.Fprintf(tbl, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n",
+			position.InstrumentType,
+			position.Figi,
+			tickerByFigi(t, position.Figi),
+			position.Quantity.String(),
+			position.QuantityLots.String(),
+			formatMoney(position.AveragePositionPrice),
+			formatMoney(position.CurrentPrice),
+			position.ExpectedYield.FormattedString(2),
+		)
+	}
+	tbl.Flush()
+
+	fmt.Println()
+	tbl = tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tbl, "Акции\t%s\t\n", formatMoney(p.TotalAmountShares))
+	fmt.Fprintf(tbl, "Облигации\t%s\t\n", formatMoney(p.TotalAmountBonds))
+	fmt.Fprintf(tbl, "Фонды\t%s\t\n", formatMoney(p.TotalAmountEtf))
+	fmt.Fprintf(tbl, "Валюты\t%s\t\n", formatMoney(p.TotalAmountCurrencies))
+	fmt.Fprintf(tbl, "Фьючерсы\t%s\t\n", formatMoney(p.TotalAmountFutures))
+	fmt.Fprintf(tbl, "Доходность портфеля, %%\t%s\t\n", p.ExpectedYield.FormattedString(2))
+	tbl.Flush()
+
+	return nil
+}
+
+func positions(c *cli.Context) error {
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account, err := tinkoffAccount(c, t)
+	if err != nil {
+		return err
+	}
+	p, err := account.GetPositions(c.Context)
+	if err != nil {
+		return err
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Currency\tMoney\tBlocked\t")
+	blocked := make(map[string]*alex.Money)
+	for _, m := range p.Blocked {
+		blocked[m.Currency] = m
+	}
+	for _, m := range p.Money {
+		fmt.Fprintf(tbl, "%s\t%s\t%s\t\n", m.Currency, m.Value.FormattedString(2), formatMoneyValue(blocked[m.Currency]))
+	}
+	tbl.Flush()
+
+	fmt.Println()
+	tbl = tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Figi\tTicker\tBalance\tBlocked\t")
+	figis := make([]string, 0, len(p.Positions))
+	for figi := range p.Positions {
+		figis = append(figis, figi)
+	}
+	sort.Strings(figis)
+	for _, figi := range figis {
+		position := p.Positions[figi]
+		fmt.Fprintf(tbl, "%s\t%s\t%d\t%d\t\n", figi, tickerByFigi(t, figi), position.GetBalance(), position.GetBlocked())
+	}
+	tbl.Flush()
+
+	if p.LimitsLoadingInProgress {
+		fmt.Println("Брокер ещё выгружает лимиты, позиции могут быть неполными")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex/tinkoff"
+)
+
+var recordFlags = append(connectionFlags,
+	dataFlag,
+	figisFlag,
+	tickersFlag,
+	&cli.BoolFlag{
+		Name:  "candles",
+		Usage: "Записывать свечи размера candles-period",
+	},
+	candlesPeriodFlag,
+	&cli.BoolFlag{
+		Name:  "orderbook",
+		Usage: "Записывать стакан",
+	},
+	&cli.IntFlag{
+		Name:  "depth",
+		Value: 20,
+		Usage: "Глубина стакана: 1, 10, 20, 30, 40 или 50",
+	},
+	&cli.BoolFlag{
+		Name:  "trades",
+		Usage: "Записывать обезличенные сделки",
+	},
+	&cli.BoolFlag{
+		Name:  "last-price",
+		Usage: "Записывать последние цены",
+	},
+)
+
+func record(c *cli.Context) error {
+	opts := tinkoff.RecordOptions{
+		Candles:        c.Bool("candles"),
+		CandlesPeriod:  c.Duration("candles-period"),
+		OrderBook:      c.Bool("orderbook"),
+		OrderBookDepth: int32(c.Int("depth")),
+		Trades:         c.Bool("trades"),
+		LastPrice:      c.Bool("last-price"),
+	}
+	if !opts.Candles && !opts.OrderBook && !opts.Trades && !opts.LastPrice {
+		return errors.New("не указано, что записывать: --candles, --orderbook, --trades или --last-price")
+	}
+
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	figis, err := instrumentFigis(c, t)
+	if err != nil {
+		return err
+	}
+	recorder := tinkoff.NewRecorder(t, c.String("data"))
+	for _, figi := range figis {
+		if err := recorder.Add(figi, opts); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(c.Context)
+	defer cancel()
+	go func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+		cancel()
+	}()
+	return recorder.Run(ctx)
+}
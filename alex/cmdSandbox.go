@@ -3,14 +3,13 @@ package main
 import (
 	"fmt"
 
-	"github.com/go-trading/alex/tinkoff"
 	"github.com/sdcoffey/big"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
 )
 
 func sandboxOpenAccount(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 	if err := t.Open(c.Context); err != nil {
 		l.DPanic("не смог открыть соединение", zap.Error(err))
 	}
@@ -26,7 +25,7 @@ func sandboxOpenAccount(c *cli.Context) error {
 }
 
 func sandboxCloseAccount(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 	if err := t.Open(c.Context); err != nil {
 		l.DPanic("не смог открыть соединение", zap.Error(err))
 	}
@@ -42,7 +41,7 @@ func sandboxCloseAccount(c *cli.Context) error {
 }
 
 func sandboxPayIn(c *cli.Context) error {
-	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t := newClient(c)
 	if err := t.Open(c.Context); err != nil {
 		l.DPanic("не смог открыть соединение", zap.Error(err))
 	}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+)
+
+func stopOrdersList(c *cli.Context) error {
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
+	stopOrders, err := account.GetStopOrders(c.Context)
+	if err != nil {
+		return err
+	}
+	if account.GetEngineType() == alex.EngineType_SANDBOX {
+		fmt.Println("В песочнице стоп-заявки эмулируются и живут только пока работает программа, которая их выставила")
+	}
+
+	tbl := tabwriter.NewWriter(os.Stdout, 1, 1, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tbl, "Id\tType\tFigi\tDirection\tLots\tStopPrice\tPrice\tCreateDate\tExpirationTime\t")
+	for _, o := range stopOrders {
+		expiration := ""
+		if !o.ExpirationTime.IsZero() {
+			expiration = alex.FormatTime(o.ExpirationTime)
+		}
+		price := ""
+		if !o.Price.NaN() {
+			price = o.Price.String()
+		}
+		fmt.Fprintf(tbl, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t\n",
+			o.StopOrderId,
+			strings.Replace(o.OrderType.String(), "STOP_ORDER_TYPE_", "", 1),
+			o.Figi,
+			strings.Replace(o.Direction.String(), "STOP_ORDER_DIRECTION_", "", 1),
+			o.LotsRequested,
+			o.StopPrice.String(),
+			price,
+			alex.FormatTime(o.CreateDate),
+			expiration,
+		)
+	}
+	tbl.Flush()
+
+	return nil
+}
+
+func stopOrdersCancel(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("укажите идентификаторы стоп-заявок")
+	}
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		l.Fatal("не смог открыть соединение", zap.Error(err))
+	}
+	defer t.Close()
+
+	account := t.Accounts.GetOrDie(c.Context, c.String("account"))
+	for _, id := range c.Args().Slice() {
+		canceled, err := account.CancelStopOrder(c.Context, id)
+		if err != nil {
+			return fmt.Errorf("не смог отменить стоп-заявку %s: %w", id, err)
+		}
+		fmt.Printf("стоп-заявка %s отменена в %s\n", id, alex.FormatTime(canceled))
+	}
+
+	return nil
+}
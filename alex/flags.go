@@ -3,9 +3,14 @@ package main
 // описание аргументов командной строки
 
 import (
+	"errors"
 	"time"
 
 	"github.com/urfave/cli/v2"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
 )
 
 var (
@@ -21,10 +26,19 @@ var (
 		Value: 200000,
 	}
 	figisFlag = &cli.StringSliceFlag{
-		Name:     "figi",
-		Usage:    "Идентификатор инструмента",
-		Required: true,
-		EnvVars:  []string{"ALEX_FIGI"},
+		Name:    "figi",
+		Usage:   "Идентификатор инструмента. Вместо него можно указать ticker",
+		EnvVars: []string{"ALEX_FIGI"},
+	}
+	tickersFlag = &cli.StringSliceFlag{
+		Name:    "ticker",
+		Usage:   "Тикер инструмента, если нужно с классом через @, например SBER@TQBR. Также можно указать isin",
+		EnvVars: []string{"ALEX_TICKER"},
+	}
+	historyFigisFlag = &cli.StringSliceFlag{
+		Name:    "figi",
+		Usage:   "Идентификатор инструмента, под которым скачана история. Вместо него можно указать ticker",
+		EnvVars: []string{"ALEX_FIGI"},
 	}
 	candlesPeriodFlag = &cli.DurationFlag{
 		Name:    "candles-period",
@@ -56,16 +70,28 @@ var (
 		Value:   1,
 		EnvVars: []string{"ALEX_MAX_POSITION"},
 	}
+	staleThresholdFlag = &cli.DurationFlag{
+		Name:    "stale-threshold",
+		Usage:   "Через сколько без рыночных данных по инструменту считать их устаревшими и не выставлять заявки. 0 - проверять только, что поток не завис",
+		Value:   0,
+		EnvVars: []string{"ALEX_STALE_THRESHOLD"},
+	}
 	dataFlag = &cli.PathFlag{
 		Name:    "data",
 		Value:   "./data/",
 		Usage:   "Каталог в котором хранятся скаченные свечи",
 		EnvVars: []string{"DATA"},
 	}
+	storageFlag = &cli.StringFlag{
+		Name:    "storage",
+		Value:   alex.CandleStoreFormat_CSV,
+		Usage:   "Формат хранения свечей в каталоге data: csv - текстовый, bin - сжатый бинарный по месяцам",
+		EnvVars: []string{"ALEX_STORAGE"},
+	}
 	fromFlag = &cli.TimestampFlag{
 		Name:    "from",
 		Value:   cli.NewTimestamp(time.Now().AddDate(0, 0, -7)),
-		Usage:   "Время c которого нужно производить действие (В зависимости от команды: скачивать историю, или тестировать робота)",
+		Usage:   "Время c которого нужно производить действие (В зависимости от команды: скачивать историю, или тестировать робота), в часовом поясе timezone",
 		Layout:  "2006-01-02T15:04",
 		EnvVars: []string{"ALEX_FROM"},
 	}
@@ -73,19 +99,25 @@ var (
 	toFlag = &cli.TimestampFlag{
 		Name:    "to",
 		Value:   cli.NewTimestamp(time.Now()),
-		Usage:   "Время по которое нужно производить действие (В зависимости от команды: скачивать историю, или тестировать робота)",
+		Usage:   "Время по которое нужно производить действие (В зависимости от команды: скачивать историю, или тестировать робота), в часовом поясе timezone",
 		Layout:  "2006-01-02T15:04",
 		EnvVars: []string{"ALEX_TO"},
 	}
 
+	apiFlag = &cli.StringFlag{
+		Name:    "api",
+		Value:   "invest-public-api.tinkoff.ru:443",
+		Usage:   "host:port api tinkoff к которому требуется подключиться",
+		Aliases: []string{"a"},
+		EnvVars: []string{"ALEX_TINKOFF_API"},
+	}
+	plaintextFlag = &cli.BoolFlag{
+		Name:    "plaintext",
+		Usage:   "Соединяться без TLS, например с локальным fake-server",
+		EnvVars: []string{"ALEX_TINKOFF_PLAINTEXT"},
+	}
 	connectionFlags = []cli.Flag{
-		&cli.StringFlag{
-			Name:    "api",
-			Value:   "invest-public-api.tinkoff.ru:443",
-			Usage:   "host:port api tinkoff к которому требуется подключиться",
-			Aliases: []string{"a"},
-			EnvVars: []string{"ALEX_TINKOFF_API"},
-		},
+		apiFlag,
 		&cli.StringFlag{
 			Name:     "token",
 			Usage:    "Токен, для доступа к api Tinkoff",
@@ -93,6 +125,27 @@ var (
 			Aliases:  []string{"t"},
 			EnvVars:  []string{"ALEX_TINKOFF_TOKEN"},
 		},
+		plaintextFlag,
+		&cli.PathFlag{
+			Name:  "record-traffic",
+			Usage: "Записать весь обмен с api в файл, чтобы потом повторить сессию через replay-traffic",
+		},
+		&cli.PathFlag{
+			Name:  "replay-traffic",
+			Usage: "Повторить сессию из файла record-traffic без подключения к api, с теми же паузами. Токен любой",
+		},
+	}
+	// для команд, которые работают с api только для поиска инструментов по ticker
+	lookupFlags = []cli.Flag{
+		tickersFlag,
+		apiFlag,
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "Токен, для доступа к api Tinkoff. Нужен только для поиска инструментов по ticker",
+			Aliases: []string{"t"},
+			EnvVars: []string{"ALEX_TINKOFF_TOKEN"},
+		},
+		plaintextFlag,
 	}
 	globalFlags = []cli.Flag{
 		&cli.BoolFlag{
@@ -102,6 +155,13 @@ var (
 			Aliases: []string{"d"},
 			EnvVars: []string{"ALEX_DEBUG"},
 		},
+		&cli.StringFlag{
+			Name:    "timezone",
+			Value:   "Europe/Moscow",
+			Usage:   "Часовой пояс, в котором задаётся и выводится время, и в котором заданы границы торговой сессии",
+			Aliases: []string{"tz"},
+			EnvVars: []string{"ALEX_TIMEZONE"},
+		},
 		&cli.StringFlag{
 			Name:    "monitoring",
 			Usage:   "Адрес, по которому включить метрики prometeus. Например :8080",
@@ -110,3 +170,62 @@ var (
 		},
 	}
 )
+
+// время из аргумента типа TimestampFlag. Введённое пользователем время считается заданным в часовом поясе timezone,
+// значение по умолчанию уже содержит часовой пояс и не меняется
+func timestamp(c *cli.Context, name string) time.Time {
+	t := *c.Timestamp(name)
+	if c.IsSet(name) {
+		return alex.WallClock(t)
+	}
+	return t
+}
+
+// клиент tinkoff по аргументам соединения
+func newClient(c *cli.Context) *tinkoff.Client {
+	t := tinkoff.NewClient(c.String("api"), c.String("token"), c.String("data"))
+	t.SetPlaintext(c.Bool("plaintext"))
+	if c.IsSet("record-traffic") {
+		if err := t.RecordTraffic(c.Path("record-traffic")); err != nil {
+			l.Fatal("не смог создать файл записи обмена с api", zap.Error(err))
+		}
+	}
+	if c.IsSet("replay-traffic") {
+		if err := t.ReplayTraffic(c.Path("replay-traffic"), true); err != nil {
+			l.Fatal("не смог прочитать запись обмена с api", zap.Error(err))
+		}
+	}
+	return t
+}
+
+// figi инструментов из аргументов figi и ticker. Тикеры и isin ищутся в справочнике инструментов
+func instrumentFigis(c *cli.Context, t *tinkoff.Client) ([]string, error) {
+	figis := c.StringSlice("figi")
+	for _, ticker := range c.StringSlice("ticker") {
+		i, err := t.Instruments.Find(c.Context, ticker)
+		if err != nil {
+			return nil, err
+		}
+		figis = append(figis, i.GetFigi())
+	}
+	if len(figis) == 0 {
+		return nil, errors.New("не указан инструмент: --figi или --ticker")
+	}
+	return figis, nil
+}
+
+// figi инструментов для команд, работающих без api. К api подключаюсь, только если указан ticker
+func lookupFigis(c *cli.Context) ([]string, error) {
+	if !c.IsSet("ticker") {
+		return c.StringSlice("figi"), nil
+	}
+	if c.String("token") == "" {
+		return nil, errors.New("для поиска инструментов по --ticker нужен --token")
+	}
+	t := newClient(c)
+	if err := t.Open(c.Context); err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	return instrumentFigis(c, t)
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/go-trading/alex"
 	"github.com/go-trading/alex/history"
 	"github.com/go-trading/alex/tinkoff"
+	"github.com/go-trading/alex/tinkoff/fake"
 	"go.uber.org/zap"
 )
 
@@ -22,4 +23,5 @@ func initDebugLogger() {
 	alex.SetLogger(l)
 	tinkoff.SetLogger(l)
 	history.SetLogger(l)
+	fake.SetLogger(l)
 }
@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
+	_ "time/tzdata" // база часовых поясов в бинарнике, в образе alpine её нет
 
+	"github.com/go-trading/alex"
 	"github.com/go-trading/alex/tinkoff"
 	"github.com/urfave/cli/v2"
 	"go.uber.org/zap"
@@ -30,6 +33,14 @@ func before(c *cli.Context) error {
 	if c.Bool("debug") {
 		initDebugLogger()
 	}
+	location := alex.MoscowLocation()
+	if c.String("timezone") != "Europe/Moscow" {
+		var err error
+		if location, err = time.LoadLocation(c.String("timezone")); err != nil {
+			return err
+		}
+	}
+	alex.SetLocation(location)
 	monitoring := c.App.Metadata["monitoring"].(*tinkoff.PrometheusService)
 	if monitoring != nil {
 		if c.IsSet("monitoring") {
@@ -0,0 +1,125 @@
+package alex
+
+// Ошибки api брокера.
+// gRPC ошибка api превращается в APIError, который хранит код ошибки tinkoff (он приходит в сообщении статуса)
+// и её вид. Вид проверяется через errors.Is(err, alex.ErrInsufficientFunds), сама ошибка достаётся через
+// errors.As(err, &apiError). Исходный gRPC статус сохраняется, поэтому status.Code(err) продолжает работать
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// виды ошибок
+var (
+	ErrInsufficientFunds      = errors.New("недостаточно средств")
+	ErrInstrumentNotAvailable = errors.New("инструмент недоступен для торговли")
+	ErrTradingClosed          = errors.New("торги не ведутся")
+	ErrRateLimit              = errors.New("превышен лимит запросов")
+	ErrNotFound               = errors.New("не найдено")
+	ErrInternal               = errors.New("внутренняя ошибка сервера")
+	ErrInvalidArgument        = errors.New("неверные параметры запроса")
+	ErrPermissionDenied       = errors.New("нет доступа")
+	ErrUnknown                = errors.New("неизвестная ошибка")
+)
+
+// коды ошибок tinkoff, вид которых отличается от вида по gRPC коду. Описание кодов https://tinkoff.github.io/investAPI/errors/
+var TinkoffErrorCodes = map[string]error{
+	"30034": ErrInsufficientFunds,      // недостаточно средств для совершения сделки
+	"30042": ErrInsufficientFunds,      // недостаточно активов для маржинальной сделки
+	"30052": ErrInstrumentNotAvailable, // для данного инструмента недоступна торговля через API
+	"30079": ErrInstrumentNotAvailable, // инструмент недоступен для торгов
+	"40002": ErrPermissionDenied,       // недостаточно прав для совершения операции
+	"40003": ErrPermissionDenied,       // токен доступа не найден или не активен
+	"50001": ErrNotFound,               // инструмент не найден
+	"70001": ErrInternal,               // внутренняя ошибка сервиса
+	"70002": ErrInternal,               // неизвестная сетевая ошибка
+	"80001": ErrRateLimit,              // превышен лимит одновременных открытых потоков
+	"80002": ErrRateLimit,              // превышен лимит запросов в минуту
+}
+
+// вид ошибки по gRPC коду, если код tinkoff неизвестен
+var grpcCode2kind = map[codes.Code]error{
+	codes.InvalidArgument:    ErrInvalidArgument,
+	codes.NotFound:           ErrNotFound,
+	codes.ResourceExhausted:  ErrRateLimit,
+	codes.FailedPrecondition: ErrTradingClosed, // торговый статус инструмента не позволяет выполнить операцию
+	codes.PermissionDenied:   ErrPermissionDenied,
+	codes.Unauthenticated:    ErrPermissionDenied,
+	codes.Internal:           ErrInternal,
+	codes.Unavailable:        ErrInternal,
+	codes.DeadlineExceeded:   ErrInternal,
+	codes.Unknown:            ErrInternal,
+}
+
+type APIError struct {
+	Code    string // код ошибки tinkoff, например 30042
+	Message string // описание ошибки от сервера, если оно пришло
+	Kind    error  // вид ошибки, одна из Err... переменных
+	status  *status.Status
+	err     error
+}
+
+// оборачивает ошибку gRPC вызова. message - описание из метаданных ответа, может быть пустым.
+// Ошибки, которые не являются gRPC ошибками, и уже обёрнутые ошибки возвращаются как есть
+func NewAPIError(err error, message string) error {
+	if err == nil {
+		return nil
+	}
+	var apiError *APIError
+	if errors.As(err, &apiError) {
+		return err
+	}
+	s, ok := status.FromError(err)
+	if !ok || s.Code() == codes.Canceled {
+		return err
+	}
+	kind, ok := TinkoffErrorCodes[s.Message()]
+	if !ok {
+		kind, ok = grpcCode2kind[s.Code()]
+	}
+	if !ok {
+		kind = ErrUnknown
+	}
+	return &APIError{
+		Code:    s.Message(),
+		Message: message,
+		Kind:    kind,
+		status:  s,
+		err:     err,
+	}
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: %s (%s %s)", e.Kind, e.Message, e.status.Code(), e.Code)
+	}
+	return fmt.Sprintf("%s (%s %s)", e.Kind, e.status.Code(), e.Code)
+}
+
+func (e *APIError) Unwrap() error { return e.err }
+
+// errors.Is(err, alex.ErrRateLimit) проверяет вид ошибки
+func (e *APIError) Is(target error) bool { return e.Kind == target }
+
+// для совместимости с status.Code и status.FromError
+func (e *APIError) GRPCStatus() *status.Status { return e.status }
+
+// имеет ли смысл повторить запрос позднее
+func (e *APIError) IsRetryable() bool {
+	return e.Kind == ErrRateLimit || e.Kind == ErrInternal
+}
+
+// имеет ли смысл повторить запрос, завершившийся ошибкой err
+func IsRetryable(err error) bool {
+	var apiError *APIError
+	return errors.As(err, &apiError) && apiError.IsRetryable()
+}
+
+// ошибка превышения лимитов: на счёте не хватает средств или активов для заявки
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrInsufficientFunds)
+}
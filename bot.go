@@ -22,7 +22,8 @@ func (bs Bots) StartAll() error {
 	for _, b := range bs {
 		if err := b.Start(); err != nil {
 			bs.StopAll() //nolint:golint,errcheck
-			l.DPanic("не смог стартовать робота", zap.Error(err))
+			l.Error("не смог стартовать робота", zap.String("bot", b.Name()), zap.Error(err))
+			return err
 		}
 	}
 	return nil
@@ -1,6 +1,7 @@
 package alex
 
 import (
+	"fmt"
 	"time"
 
 	"go.uber.org/zap"
@@ -27,47 +28,91 @@ func NewConfig(name string, account Account, instrument Instrument, values map[s
 	}
 }
 
-func (c *BotConfig) GetAny(key string) any {
+// значение параметра робота. Если параметра нет, возвращается ошибка ErrNotFound
+func (c *BotConfig) Get(key string) (any, error) {
 	v, ok := c.Values[key]
 	if !ok {
-		l.Error("config key not found",
-			zap.String("name", c.Name),
-			zap.String("key", key),
-		)
-		panic("config key not found")
+		return nil, fmt.Errorf("параметр %s робота %s: %w", key, c.Name, ErrNotFound)
 	}
-	return v
+	return v, nil
 }
 
-func (c *BotConfig) GetIntOrDie(key string) int {
-	v := c.GetAny(key)
-
+func (c *BotConfig) GetInt(key string) (int, error) {
+	v, err := c.Get(key)
+	if err != nil {
+		return 0, err
+	}
 	typedValue, ok := v.(int)
 	if !ok {
-		l.Error("value not int", zap.String("name", c.Name), zap.String("key", key))
-		panic("value not string")
+		return 0, fmt.Errorf("параметр %s робота %s должен быть int, а не %T", key, c.Name, v)
 	}
-	return typedValue
+	return typedValue, nil
 }
 
-func (c *BotConfig) GetStringOrDie(key string) string {
-	v := c.GetAny(key)
-
+func (c *BotConfig) GetString(key string) (string, error) {
+	v, err := c.Get(key)
+	if err != nil {
+		return "", err
+	}
 	typedValue, ok := v.(string)
 	if !ok {
-		l.Error("value not string", zap.String("name", c.Name), zap.String("key", key))
-		panic("value not string")
+		return "", fmt.Errorf("параметр %s робота %s должен быть string, а не %T", key, c.Name, v)
 	}
-	return typedValue
+	return typedValue, nil
 }
 
-func (c *BotConfig) GetDurationOrDie(key string) time.Duration {
-	v := c.GetAny(key)
-
+func (c *BotConfig) GetDuration(key string) (time.Duration, error) {
+	v, err := c.Get(key)
+	if err != nil {
+		return 0, err
+	}
 	typedValue, ok := v.(time.Duration)
 	if !ok {
-		l.Error("value not time.Duration", zap.String("name", c.Name), zap.String("key", key))
-		panic("value not time.Duration")
+		return 0, fmt.Errorf("параметр %s робота %s должен быть time.Duration, а не %T", key, c.Name, v)
+	}
+	return typedValue, nil
+}
+
+// ...OrDie методы паникуют при отсутствии параметра или неверном типе. Для утилит командной строки и примеров,
+// роботам лучше использовать методы, возвращающие ошибку
+
+func (c *BotConfig) GetAnyOrDie(key string) any {
+	v, err := c.Get(key)
+	if err != nil {
+		l.Error("config key not found", zap.Error(err))
+		panic(err)
+	}
+	return v
+}
+
+// Deprecated: используйте GetAnyOrDie
+func (c *BotConfig) GetAny(key string) any {
+	return c.GetAnyOrDie(key)
+}
+
+func (c *BotConfig) GetIntOrDie(key string) int {
+	v, err := c.GetInt(key)
+	if err != nil {
+		l.Error("value not int", zap.Error(err))
+		panic(err)
 	}
-	return typedValue
+	return v
+}
+
+func (c *BotConfig) GetStringOrDie(key string) string {
+	v, err := c.GetString(key)
+	if err != nil {
+		l.Error("value not string", zap.Error(err))
+		panic(err)
+	}
+	return v
+}
+
+func (c *BotConfig) GetDurationOrDie(key string) time.Duration {
+	v, err := c.GetDuration(key)
+	if err != nil {
+		l.Error("value not time.Duration", zap.Error(err))
+		panic(err)
+	}
+	return v
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/go-trading/alex"
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sdcoffey/big"
 )
@@ -23,6 +24,7 @@ type BestInOrderbookBot struct {
 	instrument      alex.Instrument
 	candles         alex.Candles
 	candlesChan     alex.CandleChan
+	orderBookChan   alex.OrderBookChan
 	maxPositionLots int64
 	sleepTime       time.Time
 }
@@ -38,24 +40,46 @@ func NewBestInOrderbookBot(ctx context.Context) *BestInOrderbookBot {
 
 //Настроить робота. Если робот не готов торговать с такими настройками, то должен вернуть ошибку
 func (b *BestInOrderbookBot) Config(configs *alex.BotConfig) error {
+	maxPositionLots, err := configs.GetInt("max-position")
+	if err != nil {
+		return err
+	}
 	b.name = configs.Name
 	b.account = configs.Account
 	b.instrument = configs.Instrument
 	b.candles = configs.Instrument.GetCandles(time.Minute)
-	b.maxPositionLots = int64(configs.GetIntOrDie("max-position"))
+	b.maxPositionLots = int64(maxPositionLots)
 	return nil
 }
 
 //Начать торговлю
 func (b *BestInOrderbookBot) Start() (err error) {
 	b.candlesChan, err = b.candles.Subscribe()
+	if err != nil {
+		return err
+	}
+	// робот постоянно сверяет свои заявки с лучшими ценами, поэтому стакан получаю из потока, а не запросами
+	b.orderBookChan, err = b.instrument.SubscribeOrderBook(1)
+	if err != nil {
+		// подписка на свечи уже оформлена, без робота она не нужна
+		if unsubscribeErr := b.candles.Unsubscribe(b.candlesChan); unsubscribeErr != nil {
+			err = multierror.Append(err, unsubscribeErr)
+		}
+		b.candlesChan = nil
+		return err
+	}
 	go b.botLoop()
-	return err
+	return nil
 }
 
 //Остановить торговлю
 func (b *BestInOrderbookBot) Stop() error {
 	b.cancel()
+	if b.orderBookChan != nil {
+		if err := b.instrument.UnsubscribeOrderBook(b.orderBookChan); err != nil {
+			return err
+		}
+	}
 	return b.account.DoPosition(b.ctx, b, b.instrument, 0)
 }
 
@@ -70,6 +94,17 @@ func (b *BestInOrderbookBot) botLoop() {
 				b.OnCandle()
 				timer.ObserveDuration()
 			}
+		case _, ok := <-b.orderBookChan:
+			if !ok {
+				return
+			}
+			// изменение лучших цен обрабатываю так же, как новую свечу
+			if len(b.orderBookChan) == 0 && b.instrument.Now().After(b.sleepTime) {
+				b.sleepTime = b.instrument.Now().Add(5 * time.Second)
+				timer := prometheus.NewTimer(botDurationMetric.WithLabelValues(b.name))
+				b.OnCandle()
+				timer.ObserveDuration()
+			}
 		case <-b.ctx.Done():
 			b.account.GetClient().Printf("Завершаю обработку свечей роботом.\n")
 			return
@@ -82,6 +117,10 @@ func (b *BestInOrderbookBot) OnCandle() {
 	if !b.instrument.IsStatus(proto.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING) {
 		return
 	}
+	// по устаревшим данным лучшую цену не определить
+	if !b.instrument.IsDataFresh() {
+		return
+	}
 	balanceLots := b.account.GetBalance(b.ctx, b.instrument) / int64(b.instrument.GetLot())
 	blockedLots := b.account.GetBlocked(b.ctx, b.instrument) / int64(b.instrument.GetLot())
 
@@ -90,7 +129,9 @@ func (b *BestInOrderbookBot) OnCandle() {
 	needBuyTotalLots := b.maxPositionLots - needSellTotalLots
 	orders, err := b.account.GetOrders(b.ctx)
 	if err != nil {
-		panic(err)
+		// попробую ещё раз на следующей свече
+		b.account.GetClient().Printf("%s не смог получить заявки: %v\n", b.name, err)
+		return
 	}
 	//рассчитываю, какое количество уже сейчас выставленно, паралельно отменяю заявки, не по лучшей цене
 	inOrderSellLots := int64(0)
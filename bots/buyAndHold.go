@@ -29,10 +29,14 @@ func NewBuyAndHoldBot(ctx context.Context) *BuyAndHoldBot {
 
 //Настроить робота. Если робот не готов торговать с такими настройками, то должен вернуть ошибку
 func (b *BuyAndHoldBot) Config(configs *alex.BotConfig) error {
+	maxPosition, err := configs.GetInt("max-position")
+	if err != nil {
+		return err
+	}
 	b.name = configs.Name
 	b.account = configs.Account
 	b.instrument = configs.Instrument
-	b.maxPosition = int64(configs.GetIntOrDie("max-position"))
+	b.maxPosition = int64(maxPosition)
 	return nil
 }
 
@@ -47,19 +47,33 @@ func NewRSIBot(ctx context.Context) *RSIBot {
 // Конфигурация нового робота - вынесено из конструктора, т.к. можно изменять в конфигурацию в процессе
 // потребуется в будущем, при разработке инструментов оптимизации параметров робота
 func (b *RSIBot) Config(configs *alex.BotConfig) error {
-	period := configs.GetDurationOrDie("candles-period")
-	if alex.Duration2CandleInterval(period) == proto.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED {
+	period, err := configs.GetDuration("candles-period")
+	if err != nil {
+		return err
+	}
+	// api tinkoff отдаёт только свечи фиксированных периодов, на истории можно использовать любые построенные свечи
+	if configs.Account.GetEngineType() != alex.EngineType_HISTORICAL &&
+		alex.Duration2CandleInterval(period) == proto.CandleInterval_CANDLE_INTERVAL_UNSPECIFIED {
 		return errors.New("INCORRECT CANDLES PERIOD")
 	}
 	b.name = configs.Name
 	b.account = configs.Account
 	b.instrument = configs.Instrument
 	b.candles = configs.Instrument.GetCandles(period)
-	//TODO при неправельной конфигурации возвращать ошибку, а не кидать исключение
-	b.timeframe = configs.GetIntOrDie("timeframe")
-	b.rsi4buy = configs.GetIntOrDie("rsi4buy")
-	b.rsi4sell = configs.GetIntOrDie("rsi4sell")
-	b.maxPosition = int64(configs.GetIntOrDie("max-position"))
+	if b.timeframe, err = configs.GetInt("timeframe"); err != nil {
+		return err
+	}
+	if b.rsi4buy, err = configs.GetInt("rsi4buy"); err != nil {
+		return err
+	}
+	if b.rsi4sell, err = configs.GetInt("rsi4sell"); err != nil {
+		return err
+	}
+	maxPosition, err := configs.GetInt("max-position")
+	if err != nil {
+		return err
+	}
+	b.maxPosition = int64(maxPosition)
 	// для всех инструментов выставляю заявки по цене немного лучше чем лучшая в стакане
 	// для BBG000000001 разница между покупкой и продажей всегда равна minIncriment, и заявка сразу исполняется
 	//    и чтобы продемонстрировать работу с долгоживущими заявками на BBG000000001 буду ставить заявки по цене равной лучшей
@@ -73,11 +87,15 @@ func (b *RSIBot) Config(configs *alex.BotConfig) error {
 //Начать торговлю
 func (b *RSIBot) Start() error {
 	//если запустить робота в середине дня, он сразу скачает нужные ему свечи
-	//в начале дня свечей ещё не будет, можно подумать над более хитрым механизмом инициализации, который бы позволил скачать свечи из предыдущего торгового дня
-	// TODO реализовать _, err := b.candles.GetLast(b.ctx, b.timeframe)
-	err := b.candles.Load(b.ctx,
-		b.instrument.Now().Add(time.Duration(-int(b.candles.GetPeriod())*(b.timeframe+1))),
-		b.instrument.Now())
+	//в начале дня свечей ещё не будет, поэтому дополнительно скачиваю свечи предыдущего торгового дня
+	from := b.instrument.Now().Add(time.Duration(-int(b.candles.GetPeriod()) * (b.timeframe + 1)))
+	previousDay, err := b.instrument.GetSchedule().PreviousTradingDay(b.ctx, b.instrument.Now())
+	if err != nil {
+		b.account.GetClient().Printf("%s не смог получить предыдущий торговый день: %v\n", b.name, err)
+	} else if previousDay.Start.Before(from) {
+		from = previousDay.Start
+	}
+	err = b.candles.Load(b.ctx, from, b.instrument.Now())
 	if err != nil {
 		return err
 	}
@@ -151,11 +169,17 @@ func (b *RSIBot) OnCandle() {
 	// вывожу статус / отладочную информацию.
 	// делаю это через b.account.Printf, т.к. куда пишиет робот, зависит от режима (на истории / на реальном счёте), и за это отвечает account
 	b.account.GetClient().Printf("%s %s rsi=%s\ttargetPosition=%d\n",
-		b.instrument.Now().Format(time.StampMilli),
+		b.instrument.Now().In(alex.Location()).Format(time.StampMilli),
 		b.name,
 		rsi.FormattedString(2),
 		targetPosition)
 
+	// на устаревших данных не торгую, SDK само снимет выставленные заявки
+	if hasMadeADecision && !b.instrument.IsDataFresh() {
+		b.account.GetClient().Printf("%s рыночные данные устарели, решение о позиции не исполняю\n", b.name)
+		return
+	}
+
 	// если решение о позиции было принято, то сообщаю желаемую позицию в SDK, дальще SDK само выставит нужные заявки, и отследит их исполнение
 	if hasMadeADecision {
 		pos := b.account.DoPositionExtended(b.ctx, b, b.instrument, targetPosition, b.bestPriceInc)
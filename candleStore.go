@@ -0,0 +1,180 @@
+package alex
+
+// Хранилища исторических свечей. Через них работают и загрузчик tinkoff, и тестирование на истории
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sdcoffey/techan"
+)
+
+// форматы хранилищ свечей, которые можно выбрать в командной строке
+const (
+	CandleStoreFormat_CSV    = "csv" // текстовый csv, по файлу на figi и период
+	CandleStoreFormat_BINARY = "bin" // сжатый бинарный колоночный формат, по файлу на каждый месяц
+)
+
+// интерфейс хранилища исторических свечей
+type CandleStore interface {
+	// загрузить все сохранённые свечи инструмента указанного периода
+	Load(figi string, period time.Duration) (*techan.TimeSeries, error)
+	// загрузить свечи, начало которых попадает в интервал [from, to)
+	LoadRange(figi string, period time.Duration, from time.Time, to time.Time) (*techan.TimeSeries, error)
+	// сохранить свечи. Ранее сохранённые свечи с тем же временем начала будут заменены
+	Save(figi string, period time.Duration, series *techan.TimeSeries) error
+	// список сохранённых серий свечей
+	List() ([]CandleSeriesInfo, error)
+	// загрузить свечи в том виде и порядке, в котором они хранятся, без сортировки и удаления дублей.
+	// Нечитаемые части хранилища не прерывают загрузку, а возвращаются в виде списка проблем
+	LoadRaw(figi string, period time.Duration) ([]*techan.Candle, []DataIssue, error)
+	// полностью заменить сохранённые свечи инструмента указанного периода
+	Replace(figi string, period time.Duration, series *techan.TimeSeries) error
+}
+
+// описание серии свечей, сохранённой в хранилище
+type CandleSeriesInfo struct {
+	Figi   string
+	Period time.Duration
+}
+
+// разбирает имя вида FIGI_period, используемое обоими хранилищами
+func parseSeriesName(name string) (CandleSeriesInfo, bool) {
+	idx := strings.LastIndex(name, "_")
+	if idx <= 0 {
+		return CandleSeriesInfo{}, false
+	}
+	period, err := time.ParseDuration(name[idx+1:])
+	if err != nil || period <= 0 {
+		return CandleSeriesInfo{}, false
+	}
+	return CandleSeriesInfo{Figi: name[:idx], Period: period}, true
+}
+
+func sortSeriesInfo(list []CandleSeriesInfo) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Figi != list[j].Figi {
+			return list[i].Figi < list[j].Figi
+		}
+		return list[i].Period < list[j].Period
+	})
+}
+
+// создать хранилище свечей указанного формата в каталоге dataDir
+func NewCandleStore(format string, dataDir string) (CandleStore, error) {
+	switch format {
+	case CandleStoreFormat_CSV, "":
+		return NewCSVCandleStore(dataDir), nil
+	case CandleStoreFormat_BINARY:
+		return NewBinaryCandleStore(dataDir), nil
+	default:
+		return nil, fmt.Errorf("неизвестный формат хранилища свечей %q", format)
+	}
+}
+
+// оставляет в серии только свечи, начало которых попадает в интервал [from, to)
+func FilterSeries(series *techan.TimeSeries, from time.Time, to time.Time) *techan.TimeSeries {
+	result := techan.NewTimeSeries()
+	if series == nil {
+		return result
+	}
+	for _, c := range series.Candles {
+		if !c.Period.Start.Before(from) && c.Period.Start.Before(to) {
+			result.Candles = append(result.Candles, c)
+		}
+	}
+	return result
+}
+
+// сливает две отсортированные по времени серии. При совпадении времени начала побеждает свеча из newer
+func MergeSeries(older *techan.TimeSeries, newer *techan.TimeSeries) *techan.TimeSeries {
+	result := techan.NewTimeSeries()
+	result.Candles = make([]*techan.Candle, 0, len(older.Candles)+len(newer.Candles))
+	i, j := 0, 0
+	for i < len(older.Candles) || j < len(newer.Candles) {
+		switch {
+		case j == len(newer.Candles):
+			result.Candles = append(result.Candles, older.Candles[i])
+			i++
+		case i == len(older.Candles):
+			result.Candles = append(result.Candles, newer.Candles[j])
+			j++
+		case older.Candles[i].Period.Start.Before(newer.Candles[j].Period.Start):
+			result.Candles = append(result.Candles, older.Candles[i])
+			i++
+		case older.Candles[i].Period.Start.Equal(newer.Candles[j].Period.Start):
+			result.Candles = append(result.Candles, newer.Candles[j])
+			i++
+			j++
+		default:
+			result.Candles = append(result.Candles, newer.Candles[j])
+			j++
+		}
+	}
+	return result
+}
+
+var _ CandleStore = (*CSVCandleStore)(nil)
+
+// хранилище свечей в csv файлах вида FIGI_period.csv
+type CSVCandleStore struct {
+	dataDir string
+}
+
+func NewCSVCandleStore(dataDir string) *CSVCandleStore {
+	return &CSVCandleStore{dataDir: dataDir}
+}
+
+func (s *CSVCandleStore) Load(figi string, period time.Duration) (*techan.TimeSeries, error) {
+	return LoadTimeSeries(s.dataDir, figi, period)
+}
+
+func (s *CSVCandleStore) LoadRange(figi string, period time.Duration, from time.Time, to time.Time) (*techan.TimeSeries, error) {
+	series, err := LoadTimeSeries(s.dataDir, figi, period)
+	if err != nil {
+		return nil, err
+	}
+	return FilterSeries(series, from, to), nil
+}
+
+func (s *CSVCandleStore) List() ([]CandleSeriesInfo, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var result []CandleSeriesInfo
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".csv" {
+			continue
+		}
+		if info, ok := parseSeriesName(strings.TrimSuffix(e.Name(), ".csv")); ok {
+			result = append(result, info)
+		}
+	}
+	sortSeriesInfo(result)
+	return result, nil
+}
+
+func (s *CSVCandleStore) LoadRaw(figi string, period time.Duration) ([]*techan.Candle, []DataIssue, error) {
+	return readCSVCandles(getFileName(s.dataDir, figi, period), period)
+}
+
+func (s *CSVCandleStore) Replace(figi string, period time.Duration, series *techan.TimeSeries) error {
+	return SaveTimeSeries(s.dataDir, figi, period, series)
+}
+
+// csv файл целиком перезаписывается, поэтому сначала сливаю новые свечи с уже сохранёнными
+func (s *CSVCandleStore) Save(figi string, period time.Duration, series *techan.TimeSeries) error {
+	saved, err := LoadTimeSeries(s.dataDir, figi, period)
+	if os.IsNotExist(err) {
+		return SaveTimeSeries(s.dataDir, figi, period, series)
+	}
+	if err != nil {
+		return err
+	}
+	return SaveTimeSeries(s.dataDir, figi, period, MergeSeries(saved, series))
+}
@@ -0,0 +1,465 @@
+package alex
+
+// Бинарное хранилище свечей.
+// Свечи одного figi и периода лежат в каталоге FIGI_period, по файлу на каждый календарный месяц (UTC).
+// Внутри файла (сжатого gzip) данные записаны по колонкам: время, open, high, low, close, volume.
+// Каждая колонка хранится как разница с предыдущим значением в varint, цены - в миллиардных долях (как units+nano в api).
+// Благодаря разбиению по месяцам, дозапись свежих свечей переписывает только последний файл,
+// а чтение диапазона открывает только нужные месяцы
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	mathbig "math/big"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+	"go.uber.org/zap"
+)
+
+const (
+	binaryCandleMagic   = "ALXC"
+	binaryCandleVersion = 1
+	binaryChunkLayout   = "2006-01"
+	binaryChunkExt      = ".bin"
+)
+
+var _ CandleStore = (*BinaryCandleStore)(nil)
+
+type BinaryCandleStore struct {
+	dataDir string
+}
+
+func NewBinaryCandleStore(dataDir string) *BinaryCandleStore {
+	return &BinaryCandleStore{dataDir: dataDir}
+}
+
+func (s *BinaryCandleStore) seriesDir(figi string, period time.Duration) string {
+	return path.Join(s.dataDir, figi+"_"+period.String())
+}
+
+func (s *BinaryCandleStore) chunkFileName(figi string, period time.Duration, month time.Time) string {
+	return path.Join(s.seriesDir(figi, period), month.Format(binaryChunkLayout)+binaryChunkExt)
+}
+
+// месяцы, за которые есть сохранённые свечи, в порядке возрастания
+func (s *BinaryCandleStore) chunks(figi string, period time.Duration) ([]time.Time, error) {
+	entries, err := os.ReadDir(s.seriesDir(figi, period))
+	if err != nil {
+		l.Debug("Ранее скаченных файлов со свечами нет", zap.String("figi", figi), zap.Error(err))
+		return nil, err
+	}
+	var result []time.Time
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != binaryChunkExt {
+			continue
+		}
+		month, err := time.Parse(binaryChunkLayout, e.Name()[:len(e.Name())-len(binaryChunkExt)])
+		if err != nil {
+			l.Warn("посторонний файл в каталоге свечей", zap.String("name", e.Name()))
+			continue
+		}
+		result = append(result, month)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Before(result[j]) })
+	return result, nil
+}
+
+func (s *BinaryCandleStore) Load(figi string, period time.Duration) (*techan.TimeSeries, error) {
+	months, err := s.chunks(figi, period)
+	if err != nil {
+		return nil, err
+	}
+	result := techan.NewTimeSeries()
+	for _, month := range months {
+		chunk, err := s.readChunk(figi, period, month)
+		if err != nil {
+			return nil, err
+		}
+		result.Candles = append(result.Candles, chunk.Candles...)
+	}
+	return result, nil
+}
+
+func (s *BinaryCandleStore) LoadRange(figi string, period time.Duration, from time.Time, to time.Time) (*techan.TimeSeries, error) {
+	months, err := s.chunks(figi, period)
+	if err != nil {
+		return nil, err
+	}
+	firstMonth := monthStart(from)
+	result := techan.NewTimeSeries()
+	for _, month := range months {
+		if month.Before(firstMonth) || !month.Before(to) {
+			continue
+		}
+		chunk, err := s.readChunk(figi, period, month)
+		if err != nil {
+			return nil, err
+		}
+		result.Candles = append(result.Candles, FilterSeries(chunk, from, to).Candles...)
+	}
+	return result, nil
+}
+
+func (s *BinaryCandleStore) List() ([]CandleSeriesInfo, error) {
+	entries, err := os.ReadDir(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	var result []CandleSeriesInfo
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if info, ok := parseSeriesName(e.Name()); ok {
+			result = append(result, info)
+		}
+	}
+	sortSeriesInfo(result)
+	return result, nil
+}
+
+// месяцы, которые не удалось прочитать, возвращаются как проблемы, остальные загружаются
+func (s *BinaryCandleStore) LoadRaw(figi string, period time.Duration) ([]*techan.Candle, []DataIssue, error) {
+	months, err := s.chunks(figi, period)
+	if err != nil {
+		return nil, nil, err
+	}
+	var result []*techan.Candle
+	var issues []DataIssue
+	for _, month := range months {
+		chunk, err := s.readChunk(figi, period, month)
+		if err != nil {
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Time: month, Message: err.Error()})
+			continue
+		}
+		result = append(result, chunk.Candles...)
+	}
+	return result, issues, nil
+}
+
+// записывает свечи заново. Новые месяцы сначала пишутся во временные файлы, и только когда все записаны, заменяют
+// старые, а лишние месяцы удаляются в самом конце, поэтому при ошибке записи сохранённая история не теряется. Месяцы, которые не удалось прочитать,
+// не удаляются, а переименовываются в *.bad, чтобы их можно было изучить
+func (s *BinaryCandleStore) Replace(figi string, period time.Duration, series *techan.TimeSeries) error {
+	months, err := s.chunks(figi, period)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(s.seriesDir(figi, period), os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+
+	tmpNames := make(map[time.Time]string)
+	removeTmp := func() {
+		for _, tmpName := range tmpNames {
+			os.Remove(tmpName) //nolint:golint,errcheck
+		}
+	}
+	for month, chunk := range splitByMonth(series) {
+		tmpName, err := s.writeChunkTmp(figi, period, month, chunk)
+		if err != nil {
+			removeTmp()
+			return err
+		}
+		tmpNames[month] = tmpName
+	}
+
+	var obsolete []string
+	for _, month := range months {
+		fileName := s.chunkFileName(figi, period, month)
+		if _, err := s.readChunk(figi, period, month); err != nil {
+			badName := fileName + "." + time.Now().Format("20060102T150405") + ".bad"
+			l.Warn("нечитаемый месяц сохранён отдельно", zap.String("fileName", badName), zap.Error(err))
+			if err := os.Rename(fileName, badName); err != nil {
+				removeTmp()
+				return err
+			}
+			continue
+		}
+		if _, ok := tmpNames[month]; !ok {
+			obsolete = append(obsolete, fileName)
+		}
+	}
+
+	for month, tmpName := range tmpNames {
+		if err := os.Rename(tmpName, s.chunkFileName(figi, period, month)); err != nil {
+			removeTmp()
+			return err
+		}
+		delete(tmpNames, month)
+	}
+	// месяцы, которых нет в новых свечах, удаляются последними, когда новые месяцы уже на месте
+	for _, fileName := range obsolete {
+		if err := os.Remove(fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitByMonth(series *techan.TimeSeries) map[time.Time]*techan.TimeSeries {
+	byMonth := make(map[time.Time]*techan.TimeSeries)
+	for _, c := range series.Candles {
+		month := monthStart(c.Period.Start)
+		if byMonth[month] == nil {
+			byMonth[month] = techan.NewTimeSeries()
+		}
+		byMonth[month].Candles = append(byMonth[month].Candles, c)
+	}
+	return byMonth
+}
+
+// свечи раскладываются по месяцам, и каждый затронутый месяц сливается с ранее сохранённым
+func (s *BinaryCandleStore) Save(figi string, period time.Duration, series *techan.TimeSeries) error {
+	if err := os.MkdirAll(s.seriesDir(figi, period), os.ModePerm); err != nil && !os.IsExist(err) {
+		l.Error("не смог создать каталог", zap.String("path", s.seriesDir(figi, period)), zap.Error(err))
+		return err
+	}
+
+	byMonth := splitByMonth(series)
+
+	for month, newer := range byMonth {
+		saved, err := s.readChunk(figi, period, month)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if saved != nil {
+			newer = MergeSeries(saved, newer)
+		}
+		if err := s.writeChunk(figi, period, month, newer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BinaryCandleStore) readChunk(figi string, period time.Duration, month time.Time) (*techan.TimeSeries, error) {
+	fileName := s.chunkFileName(figi, period, month)
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	defer zr.Close()
+
+	series, err := decodeCandles(bufio.NewReader(zr), period)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return series, nil
+}
+
+// пишу во временный файл и переименовываю, чтобы при падении не остался наполовину записанный месяц
+func (s *BinaryCandleStore) writeChunk(figi string, period time.Duration, month time.Time, series *techan.TimeSeries) error {
+	tmpName, err := s.writeChunkTmp(figi, period, month, series)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, s.chunkFileName(figi, period, month))
+}
+
+// записывает месяц во временный файл рядом с файлом месяца, и возвращает его имя
+func (s *BinaryCandleStore) writeChunkTmp(figi string, period time.Duration, month time.Time, series *techan.TimeSeries) (string, error) {
+	tmpName := s.chunkFileName(figi, period, month) + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		l.Error("не открыть файл", zap.String("fileName", tmpName), zap.Error(err))
+		return "", err
+	}
+
+	zw := gzip.NewWriter(file)
+	w := bufio.NewWriter(zw)
+	err = encodeCandles(w, period, series)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		l.Error("не смог записать в файл", zap.String("fileName", tmpName), zap.Error(err))
+		os.Remove(tmpName) //nolint:golint,errcheck
+		return "", err
+	}
+	return tmpName, nil
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// значение в миллиардных долях, как units*10^9+nano в api. Целая часть отделяется до умножения, поэтому большие
+// цены и объёмы не теряют точность через float64. Значение, которое не помещается в int64, - ошибка
+func decimal2Nano(d big.Decimal) (int64, error) {
+	if d.NaN() {
+		return 0, errors.New("значение NaN")
+	}
+	f := d.Float()
+	if math.Abs(f) > math.MaxInt64/1e9+1 {
+		return 0, fmt.Errorf("значение %s не помещается в хранилище", d)
+	}
+	units := int64(math.Floor(f))
+	frac := d.Sub(big.NewFromInt(int(units)))
+	// при переводе во float64 дробная часть могла округлиться до целого
+	for frac.LT(big.ZERO) {
+		units--
+		frac = frac.Add(big.ONE)
+	}
+	for frac.GTE(big.ONE) {
+		units++
+		frac = frac.Sub(big.ONE)
+	}
+	nano := int64(math.Round(frac.Mul(big10_9).Float()))
+
+	v := new(mathbig.Int).Mul(mathbig.NewInt(units), int10_9)
+	v.Add(v, mathbig.NewInt(nano))
+	if !v.IsInt64() {
+		return 0, fmt.Errorf("значение %s не помещается в хранилище", d)
+	}
+	return v.Int64(), nil
+}
+
+// обратное decimal2Nano. Значение собирается из десятичной записи, чтобы округлиться один раз
+func nano2Decimal(v int64) big.Decimal {
+	sign := ""
+	u := uint64(v)
+	if v < 0 {
+		sign = "-"
+		u = -u
+	}
+	return big.NewFromString(fmt.Sprintf("%s%d.%09d", sign, u/1000000000, u%1000000000))
+}
+
+func encodeCandles(w io.Writer, period time.Duration, series *techan.TimeSeries) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	putVarint := func(v int64) error {
+		_, err := w.Write(buf[:binary.PutVarint(buf, v)])
+		return err
+	}
+
+	if _, err := io.WriteString(w, binaryCandleMagic); err != nil {
+		return err
+	}
+	if err := putVarint(binaryCandleVersion); err != nil {
+		return err
+	}
+	if err := putVarint(int64(period)); err != nil {
+		return err
+	}
+	if err := putVarint(int64(len(series.Candles))); err != nil {
+		return err
+	}
+
+	columns := []func(c *techan.Candle) (int64, error){
+		func(c *techan.Candle) (int64, error) { return c.Period.Start.Unix(), nil },
+		func(c *techan.Candle) (int64, error) { return decimal2Nano(c.OpenPrice) },
+		func(c *techan.Candle) (int64, error) { return decimal2Nano(c.MaxPrice) },
+		func(c *techan.Candle) (int64, error) { return decimal2Nano(c.MinPrice) },
+		func(c *techan.Candle) (int64, error) { return decimal2Nano(c.ClosePrice) },
+		func(c *techan.Candle) (int64, error) { return decimal2Nano(c.Volume) },
+	}
+	for _, column := range columns {
+		prev := int64(0)
+		for _, c := range series.Candles {
+			v, err := column(c)
+			if err != nil {
+				return fmt.Errorf("свеча %s: %w", c.Period.Start.Format(time.RFC3339), err)
+			}
+			// разница может переполниться, но при чтении переполнится обратно, поэтому значение восстановится
+			if err := putVarint(v - prev); err != nil {
+				return err
+			}
+			prev = v
+		}
+	}
+	return nil
+}
+
+func decodeCandles(r io.ByteReader, period time.Duration) (*techan.TimeSeries, error) {
+	magic := make([]byte, len(binaryCandleMagic))
+	for i := range magic {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		magic[i] = b
+	}
+	if string(magic) != binaryCandleMagic {
+		return nil, errors.New("файл не является хранилищем свечей")
+	}
+	version, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if version != binaryCandleVersion {
+		return nil, fmt.Errorf("неподдерживаемая версия формата %d", version)
+	}
+	storedPeriod, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if time.Duration(storedPeriod) != period {
+		return nil, fmt.Errorf("период свечей в файле %s, ожидался %s", time.Duration(storedPeriod), period)
+	}
+	count, err := binary.ReadVarint(r)
+	if err != nil {
+		return nil, err
+	}
+	// в файле свечи одного месяца, больше в нём быть не может. Иначе файл повреждён, и память под него не выделяется
+	if period <= 0 {
+		return nil, fmt.Errorf("неверный период свечей %s", period)
+	}
+	if maxCount := int64(31*24*time.Hour/period) + 1; count < 0 || count > maxCount {
+		return nil, fmt.Errorf("неверное количество свечей в файле %d, за месяц не больше %d", count, maxCount)
+	}
+
+	columns := make([][]int64, 6)
+	for col := range columns {
+		columns[col] = make([]int64, count)
+		prev := int64(0)
+		for i := range columns[col] {
+			delta, err := binary.ReadVarint(r)
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
+			}
+			prev += delta
+			columns[col][i] = prev
+		}
+	}
+
+	result := techan.NewTimeSeries()
+	result.Candles = make([]*techan.Candle, count)
+	for i := range result.Candles {
+		result.Candles[i] = &techan.Candle{
+			Period:     techan.NewTimePeriod(time.Unix(columns[0][i], 0).UTC(), period),
+			OpenPrice:  nano2Decimal(columns[1][i]),
+			MaxPrice:   nano2Decimal(columns[2][i]),
+			MinPrice:   nano2Decimal(columns[3][i]),
+			ClosePrice: nano2Decimal(columns[4][i]),
+			Volume:     nano2Decimal(columns[5][i]),
+		}
+	}
+	return result, nil
+}
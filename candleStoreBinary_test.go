@@ -0,0 +1,209 @@
+package alex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+var testMonth = time.Date(2022, 5, 1, 0, 0, 0, 0, time.UTC)
+
+func testCandle(start time.Time, open, high, low, close, volume string) *techan.Candle {
+	c := techan.NewCandle(techan.NewTimePeriod(start, time.Minute))
+	c.OpenPrice = big.NewFromString(open)
+	c.MaxPrice = big.NewFromString(high)
+	c.MinPrice = big.NewFromString(low)
+	c.ClosePrice = big.NewFromString(close)
+	c.Volume = big.NewFromString(volume)
+	return c
+}
+
+func testSeries(candles ...*techan.Candle) *techan.TimeSeries {
+	series := techan.NewTimeSeries()
+	series.Candles = candles
+	return series
+}
+
+func encodeTestSeries(t *testing.T, series *techan.TimeSeries) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encodeCandles(&buf, time.Minute, series); err != nil {
+		t.Fatal("encodeCandles:", err)
+	}
+	return buf.Bytes()
+}
+
+// заголовок файла с произвольным количеством свечей
+func encodeTestHeader(count int64) []byte {
+	buf := []byte(binaryCandleMagic)
+	varint := make([]byte, binary.MaxVarintLen64)
+	for _, v := range []int64{binaryCandleVersion, int64(time.Minute), count} {
+		buf = append(buf, varint[:binary.PutVarint(varint, v)]...)
+	}
+	return buf
+}
+
+func TestBinaryCandlesRoundTrip(t *testing.T) {
+	series := testSeries(
+		testCandle(testMonth, "130.5", "131", "129.999999999", "130.01", "1500"),
+		testCandle(testMonth.Add(time.Minute), "0.000000001", "92233720.368547758", "0", "-1.5", "0"),
+		testCandle(testMonth.Add(time.Hour), "1234567.123456789", "1234567.2", "1234567", "1234567.1", "123456789"),
+	)
+	decoded, err := decodeCandles(bytes.NewReader(encodeTestSeries(t, series)), time.Minute)
+	if err != nil {
+		t.Fatal("decodeCandles:", err)
+	}
+	if len(decoded.Candles) != len(series.Candles) {
+		t.Fatalf("прочитано %d свечей, записано %d", len(decoded.Candles), len(series.Candles))
+	}
+	for i, want := range series.Candles {
+		got := decoded.Candles[i]
+		if !got.Period.Start.Equal(want.Period.Start) || got.Period.End != want.Period.End {
+			t.Errorf("свеча %d: период %v, ожидался %v", i, got.Period, want.Period)
+		}
+		for _, p := range []struct {
+			name      string
+			got, want big.Decimal
+		}{
+			{"open", got.OpenPrice, want.OpenPrice},
+			{"high", got.MaxPrice, want.MaxPrice},
+			{"low", got.MinPrice, want.MinPrice},
+			{"close", got.ClosePrice, want.ClosePrice},
+			{"volume", got.Volume, want.Volume},
+		} {
+			if !p.got.EQ(p.want) {
+				t.Errorf("свеча %d: %s %s, ожидалось %s", i, p.name, p.got.FormattedString(9), p.want.FormattedString(9))
+			}
+		}
+	}
+}
+
+func TestDecimal2Nano(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		nano  int64
+	}{
+		{"0", 0},
+		{"1", 1000000000},
+		{"0.000000001", 1},
+		{"-1.5", -1500000000},
+		{"1234567.123456789", 1234567123456789},
+	} {
+		nano, err := decimal2Nano(big.NewFromString(tc.value))
+		if err != nil {
+			t.Errorf("%s: %v", tc.value, err)
+			continue
+		}
+		if nano != tc.nano {
+			t.Errorf("%s: %d, ожидалось %d", tc.value, nano, tc.nano)
+		}
+	}
+	for _, value := range []string{"9223372037", "-9223372037", "1e30"} {
+		if _, err := decimal2Nano(big.NewFromString(value)); err == nil {
+			t.Errorf("%s: ожидалась ошибка переполнения", value)
+		}
+	}
+}
+
+func TestDecodeCandlesCorrupt(t *testing.T) {
+	valid := encodeTestSeries(t, testSeries(
+		testCandle(testMonth, "1", "2", "0.5", "1.5", "10"),
+		testCandle(testMonth.Add(time.Minute), "1.5", "2", "1", "1", "20"),
+	))
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"пустой файл", nil},
+		{"чужой файл", []byte("not a candle file")},
+		{"обрезан заголовок", valid[:len(binaryCandleMagic)+1]},
+		{"обрезаны данные", valid[:len(valid)-1]},
+		{"отрицательное количество", encodeTestHeader(-1)},
+		{"количество больше месяца", encodeTestHeader(31*24*60 + 2)},
+		{"огромное количество", encodeTestHeader(1 << 60)},
+	} {
+		if _, err := decodeCandles(bytes.NewReader(tc.data), time.Minute); err == nil {
+			t.Errorf("%s: ожидалась ошибка", tc.name)
+		}
+	}
+	if _, err := decodeCandles(bytes.NewReader(valid), time.Hour); err == nil {
+		t.Error("файл с другим периодом прочитан без ошибки")
+	}
+}
+
+// повреждённый месяц не роняет чтение и замену, а сохраняется как *.bad
+func TestBinaryCandleStoreCorruptChunk(t *testing.T) {
+	store := NewBinaryCandleStore(t.TempDir())
+	const figi = "TEST"
+	may := testSeries(testCandle(testMonth, "1", "2", "0.5", "1.5", "10"))
+	if err := store.Save(figi, time.Minute, may); err != nil {
+		t.Fatal("Save:", err)
+	}
+
+	june := testMonth.AddDate(0, 1, 0)
+	file, err := os.Create(store.chunkFileName(figi, time.Minute, june))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := gzip.NewWriter(file)
+	w := bufio.NewWriter(zw)
+	w.Write(encodeTestHeader(1 << 60)) //nolint:golint,errcheck
+	w.Flush()                          //nolint:golint,errcheck
+	zw.Close()                         //nolint:golint,errcheck
+	file.Close()                       //nolint:golint,errcheck
+
+	candles, issues, err := store.LoadRaw(figi, time.Minute)
+	if err != nil {
+		t.Fatal("LoadRaw:", err)
+	}
+	if len(candles) != 1 || len(issues) != 1 || issues[0].Type != DataIssue_MALFORMED {
+		t.Fatalf("прочитано %d свечей, проблемы %+v", len(candles), issues)
+	}
+
+	if err := store.Replace(figi, time.Minute, may); err != nil {
+		t.Fatal("Replace:", err)
+	}
+	bad, err := filepath.Glob(filepath.Join(store.seriesDir(figi, time.Minute), june.Format(binaryChunkLayout)+binaryChunkExt+".*.bad"))
+	if err != nil || len(bad) != 1 {
+		t.Fatalf("повреждённый месяц не сохранён как .bad: %v %v", bad, err)
+	}
+	loaded, err := store.Load(figi, time.Minute)
+	if err != nil {
+		t.Fatal("Load:", err)
+	}
+	if len(loaded.Candles) != 1 {
+		t.Fatalf("после замены %d свечей, ожидалась 1", len(loaded.Candles))
+	}
+}
+
+// если новый месяц не удалось поставить на место, месяцы, которых нет в новых свечах, остаются
+func TestBinaryCandleStoreReplaceKeepsHistoryOnError(t *testing.T) {
+	store := NewBinaryCandleStore(t.TempDir())
+	const figi = "TEST"
+	if err := store.Save(figi, time.Minute, testSeries(testCandle(testMonth, "1", "2", "0.5", "1.5", "10"))); err != nil {
+		t.Fatal("Save:", err)
+	}
+	june := testMonth.AddDate(0, 1, 0)
+	// на месте файла июня каталог, переименование в него не удастся
+	if err := os.MkdirAll(filepath.Join(store.chunkFileName(figi, time.Minute, june), "x"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Replace(figi, time.Minute, testSeries(testCandle(june, "1", "2", "0.5", "1.5", "10"))); err == nil {
+		t.Fatal("Replace: ожидалась ошибка")
+	}
+	if _, err := store.readChunk(figi, time.Minute, testMonth); err != nil {
+		t.Fatal("май потерян:", err)
+	}
+	if tmp, _ := filepath.Glob(filepath.Join(store.seriesDir(figi, time.Minute), "*.tmp")); len(tmp) != 0 {
+		t.Fatalf("остались временные файлы %v", tmp)
+	}
+}
@@ -0,0 +1,56 @@
+package alex
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCSVCandleStoreReplace(t *testing.T) {
+	store := NewCSVCandleStore(t.TempDir())
+	const figi = "TEST"
+	may := testSeries(
+		testCandle(testMonth, "1", "2", "0.5", "1.5", "10"),
+		testCandle(testMonth.Add(time.Minute), "1.5", "2", "1", "1", "20"),
+	)
+	if err := store.Save(figi, time.Minute, may); err != nil {
+		t.Fatal("Save:", err)
+	}
+	if err := store.Replace(figi, time.Minute, testSeries(may.Candles[1])); err != nil {
+		t.Fatal("Replace:", err)
+	}
+	loaded, err := store.Load(figi, time.Minute)
+	if err != nil {
+		t.Fatal("Load:", err)
+	}
+	if len(loaded.Candles) != 1 || !loaded.Candles[0].Period.Start.Equal(may.Candles[1].Period.Start) {
+		t.Fatalf("после замены %d свечей", len(loaded.Candles))
+	}
+	if _, err := os.Stat(getFileName(store.dataDir, figi, time.Minute) + ".tmp"); !os.IsNotExist(err) {
+		t.Fatal("остался временный файл:", err)
+	}
+}
+
+// при ошибке записи сохранённый файл не обрезается
+func TestCSVCandleStoreReplaceKeepsHistoryOnError(t *testing.T) {
+	store := NewCSVCandleStore(t.TempDir())
+	const figi = "TEST"
+	may := testSeries(testCandle(testMonth, "1", "2", "0.5", "1.5", "10"))
+	if err := store.Save(figi, time.Minute, may); err != nil {
+		t.Fatal("Save:", err)
+	}
+	// на месте временного файла каталог, записать его не удастся
+	if err := os.Mkdir(getFileName(store.dataDir, figi, time.Minute)+".tmp", os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Replace(figi, time.Minute, testSeries()); err == nil {
+		t.Fatal("Replace: ожидалась ошибка")
+	}
+	loaded, err := store.Load(figi, time.Minute)
+	if err != nil {
+		t.Fatal("Load:", err)
+	}
+	if len(loaded.Candles) != 1 {
+		t.Fatalf("после ошибки %d свечей, ожидалась 1", len(loaded.Candles))
+	}
+}
@@ -0,0 +1,240 @@
+package alex
+
+// Проверка качества скаченной истории свечей: пропуски внутри торговых сессий, дубли, нарушение порядка,
+// некорректные OHLC, серии свечей без объёма и подозрительные скачки цены
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+// тип найденной проблемы
+type DataIssueType int32
+
+const (
+	DataIssue_MALFORMED    DataIssueType = iota // строка или блок файла не разбирается
+	DataIssue_GAP          DataIssueType = iota // пропущены свечи внутри торговой сессии
+	DataIssue_DUPLICATE    DataIssueType = iota // несколько свечей с одним временем начала
+	DataIssue_OUT_OF_ORDER DataIssueType = iota // свеча записана раньше предыдущей по времени
+	DataIssue_OHLC         DataIssueType = iota // high < low, open или close вне диапазона, не число
+	DataIssue_ZERO_VOLUME  DataIssueType = iota // длинная серия свечей без объёма
+	DataIssue_PRICE_JUMP   DataIssueType = iota // подозрительный скачок цены между соседними свечами
+)
+
+var DataIssueType2string = map[DataIssueType]string{
+	DataIssue_MALFORMED:    "malformed",
+	DataIssue_GAP:          "gap",
+	DataIssue_DUPLICATE:    "duplicate",
+	DataIssue_OUT_OF_ORDER: "out-of-order",
+	DataIssue_OHLC:         "ohlc",
+	DataIssue_ZERO_VOLUME:  "zero-volume",
+	DataIssue_PRICE_JUMP:   "price-jump",
+}
+
+func (t DataIssueType) String() string {
+	return DataIssueType2string[t]
+}
+
+// найденная в данных проблема
+type DataIssue struct {
+	Type       DataIssueType // тип проблемы
+	Time       time.Time     // время начала свечи, к которой относится проблема
+	Line       int           // номер строки в файле, если известен
+	Count      int           // количество затронутых свечей (для пропусков и серий без объёма)
+	Message    string        // описание проблемы
+	Repairable bool          // может ли проблема быть исправлена RepairSeries
+}
+
+func (i DataIssue) String() string {
+	result := i.Type.String()
+	if !i.Time.IsZero() {
+		result += " " + FormatTime(i.Time)
+	}
+	if i.Line > 0 {
+		result += fmt.Sprintf(" line %d", i.Line)
+	}
+	if i.Count > 0 {
+		result += fmt.Sprintf(" count %d", i.Count)
+	}
+	if i.Message != "" {
+		result += ": " + i.Message
+	}
+	return result
+}
+
+// параметры проверки
+type DataCheckConfig struct {
+	Location         *time.Location // часовой пояс биржи, в котором заданы границы сессии
+	SessionStart     time.Duration  // начало торговой сессии от полуночи
+	SessionEnd       time.Duration  // окончание торговой сессии от полуночи
+	MaxZeroVolumeRun int            // сколько свечей подряд без объёма считать нормой
+	MaxPriceJump     float64        // максимально допустимое относительное изменение цены между соседними свечами
+}
+
+// параметры проверки по умолчанию: основная сессия Московской биржи, часовой пояс из Location()
+func NewDataCheckConfig() DataCheckConfig {
+	return DataCheckConfig{
+		Location:         Location(),
+		SessionStart:     10 * time.Hour,
+		SessionEnd:       18*time.Hour + 40*time.Minute,
+		MaxZeroVolumeRun: 5,
+		MaxPriceJump:     0.1,
+	}
+}
+
+// внутри ли торговой сессии начинается свеча
+func (cfg DataCheckConfig) inSession(t time.Time) bool {
+	t = t.In(cfg.Location)
+	if t.Weekday() == time.Saturday || t.Weekday() == time.Sunday {
+		return false
+	}
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	return sinceMidnight >= cfg.SessionStart && sinceMidnight < cfg.SessionEnd
+}
+
+func (cfg DataCheckConfig) day(t time.Time) string {
+	return t.In(cfg.Location).Format("2006-01-02")
+}
+
+// проверить свечи, в том порядке в котором они хранятся
+func CheckSeries(candles []*techan.Candle, period time.Duration, cfg DataCheckConfig) []DataIssue {
+	var issues []DataIssue
+
+	// дни, в которые есть хоть одна свеча. Дни без свечей считаю неторговыми, и пропуски в них не ищу
+	tradingDays := make(map[string]bool)
+	for _, c := range candles {
+		tradingDays[cfg.day(c.Period.Start)] = true
+	}
+
+	seen := make(map[int64]bool)
+	zeroVolumeRun := 0
+	var zeroVolumeStart time.Time
+	var prev *techan.Candle
+	for _, c := range candles {
+		start := c.Period.Start
+
+		if seen[start.UnixNano()] {
+			issues = append(issues, DataIssue{Type: DataIssue_DUPLICATE, Time: start, Repairable: true})
+		}
+		seen[start.UnixNano()] = true
+
+		if issue, ok := checkOHLC(c); ok {
+			issues = append(issues, issue)
+		}
+
+		if c.Volume.IsZero() {
+			if zeroVolumeRun == 0 {
+				zeroVolumeStart = start
+			}
+			zeroVolumeRun++
+		} else {
+			if zeroVolumeRun > cfg.MaxZeroVolumeRun {
+				issues = append(issues, DataIssue{Type: DataIssue_ZERO_VOLUME, Time: zeroVolumeStart, Count: zeroVolumeRun})
+			}
+			zeroVolumeRun = 0
+		}
+
+		if prev != nil {
+			switch {
+			case start.Before(prev.Period.Start):
+				issues = append(issues, DataIssue{Type: DataIssue_OUT_OF_ORDER, Time: start, Repairable: true,
+					Message: "предыдущая свеча " + FormatTime(prev.Period.Start)})
+			case start.After(prev.Period.Start):
+				if missed := countMissed(prev.Period.Start, start, period, cfg, tradingDays); missed > 0 {
+					issues = append(issues, DataIssue{Type: DataIssue_GAP, Time: prev.Period.Start.Add(period), Count: missed})
+				}
+				if jump, ok := priceJump(prev.ClosePrice, c.OpenPrice); ok && jump > cfg.MaxPriceJump {
+					issues = append(issues, DataIssue{Type: DataIssue_PRICE_JUMP, Time: start,
+						Message: fmt.Sprintf("%s -> %s (%.1f%%)", prev.ClosePrice.FormattedString(2), c.OpenPrice.FormattedString(2), jump*100)})
+				}
+			}
+		}
+		if prev == nil || !start.Before(prev.Period.Start) {
+			prev = c
+		}
+	}
+	if zeroVolumeRun > cfg.MaxZeroVolumeRun {
+		issues = append(issues, DataIssue{Type: DataIssue_ZERO_VOLUME, Time: zeroVolumeStart, Count: zeroVolumeRun})
+	}
+	return issues
+}
+
+func checkOHLC(c *techan.Candle) (DataIssue, bool) {
+	issue := DataIssue{Type: DataIssue_OHLC, Time: c.Period.Start}
+	switch {
+	case c.OpenPrice.NaN() || c.MaxPrice.NaN() || c.MinPrice.NaN() || c.ClosePrice.NaN() || c.Volume.NaN():
+		issue.Message = "не число в ценах или объёме"
+		issue.Repairable = true // такая свеча будет удалена
+	case c.MaxPrice.LT(c.MinPrice):
+		issue.Message = "high < low"
+		issue.Repairable = true
+	case c.OpenPrice.GT(c.MaxPrice) || c.OpenPrice.LT(c.MinPrice):
+		issue.Message = "open вне диапазона low-high"
+		issue.Repairable = true
+	case c.ClosePrice.GT(c.MaxPrice) || c.ClosePrice.LT(c.MinPrice):
+		issue.Message = "close вне диапазона low-high"
+		issue.Repairable = true
+	default:
+		return issue, false
+	}
+	return issue, true
+}
+
+// относительное изменение цены
+func priceJump(from big.Decimal, to big.Decimal) (float64, bool) {
+	if from.NaN() || to.NaN() || from.IsZero() {
+		return 0, false
+	}
+	return to.Sub(from).Div(from).Abs().Float(), true
+}
+
+// количество свечей, которые должны были быть внутри торговой сессии между from и to, но отсутствуют
+func countMissed(from time.Time, to time.Time, period time.Duration, cfg DataCheckConfig, tradingDays map[string]bool) int {
+	// для дневных и более крупных свечей торговые сессии не проверяются
+	if period >= 24*time.Hour {
+		return 0
+	}
+	missed := 0
+	for t := from.Add(period); t.Before(to); t = t.Add(period) {
+		if cfg.inSession(t) && tradingDays[cfg.day(t)] {
+			missed++
+		}
+	}
+	return missed
+}
+
+// исправляет то, что можно исправить: сортирует свечи, удаляет дубли (оставляя последнюю записанную),
+// приводит high и low к диапазону, включающему open и close.
+// Пропуски, серии без объёма и скачки цены не исправляются, т.к. для этого нужны данные, которых нет
+func RepairSeries(candles []*techan.Candle) *techan.TimeSeries {
+	sorted := make([]*techan.Candle, len(candles))
+	copy(sorted, candles)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Period.Start.Before(sorted[j].Period.Start)
+	})
+
+	result := techan.NewTimeSeries()
+	for _, c := range sorted {
+		if c.OpenPrice.NaN() || c.MaxPrice.NaN() || c.MinPrice.NaN() || c.ClosePrice.NaN() {
+			continue
+		}
+		fixed := *c
+		fixed.MaxPrice = big.MaxSlice(c.OpenPrice, c.MaxPrice, c.MinPrice, c.ClosePrice)
+		fixed.MinPrice = big.MinSlice(c.OpenPrice, c.MaxPrice, c.MinPrice, c.ClosePrice)
+		if fixed.Volume.NaN() {
+			fixed.Volume = big.ZERO
+		}
+
+		last := result.LastCandle()
+		if last != nil && last.Period.Start.Equal(fixed.Period.Start) {
+			result.Candles[result.LastIndex()] = &fixed
+		} else {
+			result.Candles = append(result.Candles, &fixed)
+		}
+	}
+	return result
+}
@@ -13,38 +13,18 @@ import (
 	"github.com/sdcoffey/big"
 	"github.com/sdcoffey/techan"
 	"go.uber.org/zap"
-	"golang.org/x/exp/slices"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-//TODO очень не оптимально, надо переделывать, например на деление пополам с кешированием последней найденной позиции
+// поиск делением пополам, без запоминания последней позиции. При частых обращениях лучше использовать IndexedSeries
 func FindSeries(series *techan.TimeSeries, time time.Time) int {
 	if series == nil {
 		return -1
 	}
-	for idx, c := range series.Candles {
-		if (time.After(c.Period.Start) && time.Before(c.Period.End)) ||
-			time.Equal(c.Period.Start) {
-			return idx
-		}
-	}
-	return -1
+	return searchSeries(series.Candles, time)
 }
 
 func UpsertSeries(series *techan.TimeSeries, newCandle *techan.Candle) {
-	idx := FindSeries(series, newCandle.Period.Start)
-
-	if idx != -1 {
-		series.Candles[idx] = newCandle
-	} else {
-		if !series.AddCandle(newCandle) {
-			series.Candles = append(series.Candles, newCandle)
-			slices.SortFunc(series.Candles, func(a *techan.Candle, b *techan.Candle) bool {
-				return a.Period.Start.Before(b.Period.Start)
-			})
-		}
-	}
+	WrapSeries(series).Upsert(newCandle)
 }
 
 func getFileName(dataDir string, figi string, period time.Duration) string {
@@ -53,13 +33,35 @@ func getFileName(dataDir string, figi string, period time.Duration) string {
 
 func LoadTimeSeries(dataDir string, figi string, period time.Duration) (*techan.TimeSeries, error) {
 	fileName := getFileName(dataDir, figi, period)
-	file, err := os.Open(fileName)
+	candles, issues, err := readCSVCandles(fileName, period)
 	if err != nil {
-		l.Debug("Ранее скаченных файлов со свечами нет", zap.String("fileName", fileName), zap.Error(err))
 		return nil, err
 	}
+	if len(issues) > 0 {
+		l.Error("Ошибка парсинга файла", zap.String("fileName", fileName), zap.String("issue", issues[0].String()))
+		return nil, fmt.Errorf("ошибка парсинга файла %s: %s", fileName, issues[0].String())
+	}
 	result := techan.NewTimeSeries()
+	for _, c := range candles {
+		result.AddCandle(c)
+	}
+	return result, nil
+}
+
+// читает свечи из csv файла в том порядке, в котором они записаны.
+// Строки, которые не удалось разобрать, не прерывают чтение, а возвращаются в виде списка проблем
+func readCSVCandles(fileName string, period time.Duration) ([]*techan.Candle, []DataIssue, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		l.Debug("Ранее скаченных файлов со свечами нет", zap.String("fileName", fileName), zap.Error(err))
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	var result []*techan.Candle
+	var issues []DataIssue
 	r := csv.NewReader(bufio.NewReader(file))
+	r.FieldsPerRecord = -1
 	line := 0
 	for {
 		line++
@@ -68,26 +70,25 @@ func LoadTimeSeries(dataDir string, figi string, period time.Duration) (*techan.
 			break
 		}
 		if err != nil {
-			l.Fatal("Ошибка парсинга файла", zap.String("fileName", fileName), zap.Error(err))
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Line: line, Message: err.Error()})
+			continue
 		}
 		if len(record) != 6 {
-			l.Fatal("Количество столбцов отличается от 6", zap.Int("line", line), zap.String("fileName", fileName))
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Line: line, Message: "количество столбцов отличается от 6"})
+			continue
 		}
 		if line == 1 {
 			//пропускаем строку с загоовком
 			continue
 		}
 
-		t, err := time.Parse("2006-01-02 15:04", record[0])
+		t, err := ParseStorageTime(record[0])
 		if err != nil {
-			l.DPanic("time.Parse error",
-				zap.String("fileName", fileName),
-				zap.Int("line", line),
-				zap.Error(err),
-			)
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Line: line, Message: err.Error()})
+			continue
 		}
 
-		result.AddCandle(&techan.Candle{
+		result = append(result, &techan.Candle{
 			Period:     techan.NewTimePeriod(t, period),
 			OpenPrice:  big.NewFromString(record[1]),
 			MaxPrice:   big.NewFromString(record[2]),
@@ -96,35 +97,51 @@ func LoadTimeSeries(dataDir string, figi string, period time.Duration) (*techan.
 			Volume:     big.NewFromString(record[5]),
 		})
 	}
-	return result, nil
+	return result, issues, nil
 }
 
 func SaveTimeSeries(dataDir string, figi string, period time.Duration, timeSeries *techan.TimeSeries) error {
 	fileName := getFileName(dataDir, figi, period)
 	path := filepath.Dir(fileName)
 	if err := os.MkdirAll(path, os.ModePerm); err != nil && !os.IsExist(err) {
-		l.DPanic("не смог создать каталог",
+		l.Error("не смог создать каталог",
 			zap.String("path", path),
 			zap.Error(err))
 		return err
 	}
 
-	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	// пишу во временный файл и переименовываю, чтобы при ошибке записи не потерять сохранённые свечи
+	tmpName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		l.DPanic("не открыть файл",
-			zap.String("fileName", fileName),
+		l.Error("не открыть файл",
+			zap.String("fileName", tmpName),
 			zap.Error(err))
 		return err
 	}
-	defer file.Close()
 
-	datawriter := bufio.NewWriter(file)
-	defer datawriter.Flush()
+	err = writeTimeSeries(file, timeSeries)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		l.Error("не смог записать в файл",
+			zap.String("fileName", tmpName),
+			zap.Error(err))
+		os.Remove(tmpName) //nolint:golint,errcheck
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
 
-	datawriter.WriteString("Time,Open,High,Low,Close,Volume\n") //nolint:golint,errcheck
+func writeTimeSeries(w io.Writer, timeSeries *techan.TimeSeries) error {
+	datawriter := bufio.NewWriter(w)
+	if _, err := datawriter.WriteString("Time,Open,High,Low,Close,Volume\n"); err != nil {
+		return err
+	}
 	for _, candle := range timeSeries.Candles {
-		_, err = datawriter.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s\n",
-			candle.Period.Start.Format("2006-01-02 15:04"),
+		_, err := datawriter.WriteString(fmt.Sprintf("%s,%s,%s,%s,%s,%s\n",
+			FormatStorageTime(candle.Period.Start),
 			candle.OpenPrice,
 			candle.MaxPrice,
 			candle.MinPrice,
@@ -132,27 +149,8 @@ func SaveTimeSeries(dataDir string, figi string, period time.Duration, timeSerie
 			candle.Volume,
 		))
 		if err != nil {
-			l.DPanic("не смог записать в файл",
-				zap.String("fileName", fileName),
-				zap.Error(err))
 			return err
 		}
 	}
-	return nil
-}
-
-//TODO надо вводить свой класс ошибок
-func IsLimitError(e error) bool {
-	if se, ok := e.(interface {
-		GRPCStatus() *status.Status
-	}); ok {
-		l.Error("se.GRPCStatus()",
-			zap.Any("GRPCStatus", se.GRPCStatus()),
-			zap.String("Code", se.GRPCStatus().Code().String()),
-			zap.Any("Message", se.GRPCStatus().Message()),
-		)
-		return se.GRPCStatus().Code() == codes.InvalidArgument &&
-			se.GRPCStatus().Message() == "30042"
-	}
-	return false
+	return datawriter.Flush()
 }
@@ -115,8 +115,8 @@ func (a *account) PostOrderWithBestPrice(ctx context.Context, instrument alex.In
 
 	ob, err := instrument.GetOrderBook(ctx, 1)
 	if err != nil {
-		l.DPanic("GetOrderBook", zap.Error(err))
-		return nil, nil
+		l.Error("GetOrderBook", zap.Error(err))
+		return nil, err
 	}
 
 	bestBid := big.NaN
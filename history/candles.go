@@ -11,7 +11,7 @@ import (
 var _ alex.Candles = (*Candles)(nil)
 
 type Candles struct {
-	series      *techan.TimeSeries
+	series      *alex.IndexedSeries
 	subscribers []alex.CandleChan
 	figi        string
 	client      *Client
@@ -23,7 +23,7 @@ func NewCandles(figi string, client *Client, instrument *instrument) *Candles {
 		figi:       figi,
 		client:     client,
 		instrument: instrument,
-		series:     techan.NewTimeSeries(),
+		series:     alex.NewIndexedSeries(),
 	}
 }
 
@@ -31,17 +31,17 @@ func (c *Candles) GetFigi() string {
 	return c.figi
 }
 func (c *Candles) GetPeriod() time.Duration {
-	return time.Minute
+	return c.client.period
 }
 func (c *Candles) GetSeries() *techan.TimeSeries {
-	return c.series
+	return c.series.TimeSeries
 }
 func (c *Candles) Load(ctx context.Context, from time.Time, to time.Time) error {
 	now := c.client.now
 	for _, historyCandle := range c.instrument.FUTURE.Candles {
 		if historyCandle.Period.End.Before(now) {
 			if from.Before(historyCandle.Period.Start) && to.After(historyCandle.Period.Start) {
-				alex.UpsertSeries(c.series, historyCandle)
+				c.series.Upsert(historyCandle)
 			}
 		} else {
 			break
@@ -61,7 +61,7 @@ func (c *Candles) Unsubscribe(candleChan alex.CandleChan) error {
 	return nil
 }
 func (c *Candles) OnTick(cc *techan.Candle) {
-	alex.UpsertSeries(c.series, cc)
+	c.series.Upsert(cc)
 
 	for _, ch := range c.subscribers {
 		ch <- cc
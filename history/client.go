@@ -9,8 +9,15 @@ import (
 
 var _ alex.Client = (*Client)(nil)
 
+// сколько истории до from загружать по умолчанию: роботу нужен предыдущий торговый день,
+// а расписание ищет его до 30 дней назад и загружается по неделе в каждую сторону
+const DefaultLookback = 45 * 24 * time.Hour
+
 type Client struct {
 	dataDir     string
+	candleStore alex.CandleStore
+	period      time.Duration
+	lookback    time.Duration
 	from        time.Time
 	to          time.Time
 	now         time.Time
@@ -21,6 +28,9 @@ type Client struct {
 func NewClient(dataDir string, from time.Time, to time.Time) *Client {
 	return &Client{
 		dataDir:     dataDir,
+		candleStore: alex.NewCSVCandleStore(dataDir),
+		period:      time.Minute,
+		lookback:    DefaultLookback,
 		from:        from,
 		to:          to,
 		now:         from,
@@ -40,6 +50,22 @@ func (c *Client) GetInstrument(figi string) alex.Instrument {
 	return i
 }
 
+// заменить хранилище, из которого загружается история. Вызывать до LoadData
+func (c *Client) SetCandleStore(store alex.CandleStore) {
+	c.candleStore = store
+}
+
+// задать размер свечей, на которых идёт тестирование (по умолчанию минутные).
+// Можно использовать свечи любого периода, например построенные командой data resample. Вызывать до LoadData
+func (c *Client) SetCandlesPeriod(period time.Duration) {
+	c.period = period
+}
+
+// задать, сколько истории до from загружать для прогрева роботов (по умолчанию DefaultLookback). Вызывать до LoadData
+func (c *Client) SetLookback(lookback time.Duration) {
+	c.lookback = lookback
+}
+
 func (c *Client) LoadData(figi string) (err error) {
 	i := newInstrument(c, figi)
 	err = i.load()
@@ -71,7 +97,7 @@ func (c *Client) Run() error {
 	for c.now = c.from.Add(time.Second); c.now.Before(c.to); {
 		// OPEN
 		for figi, instrument := range c.instruments {
-			idx := alex.FindSeries(instrument.FUTURE, c.now)
+			idx := instrument.FUTURE.Find(c.now)
 			if idx == -1 {
 				continue
 			}
@@ -90,7 +116,7 @@ func (c *Client) Run() error {
 		// HI
 		c.now = c.now.Add(time.Second)
 		for figi, instrument := range c.instruments {
-			idx := alex.FindSeries(instrument.FUTURE, c.now)
+			idx := instrument.FUTURE.Find(c.now)
 			if idx == -1 {
 				continue
 			}
@@ -111,7 +137,7 @@ func (c *Client) Run() error {
 		//LOW
 		c.now = c.now.Add(time.Second)
 		for figi, instrument := range c.instruments {
-			idx := alex.FindSeries(instrument.FUTURE, c.now)
+			idx := instrument.FUTURE.Find(c.now)
 			if idx == -1 {
 				continue
 			}
@@ -130,9 +156,9 @@ func (c *Client) Run() error {
 			}
 		}
 		//CLOSE
-		c.now = c.now.Add(58 * time.Second)
+		c.now = c.now.Add(c.period - 2*time.Second)
 		for figi, instrument := range c.instruments {
-			idx := alex.FindSeries(instrument.FUTURE, c.now.Add(-time.Minute))
+			idx := instrument.FUTURE.Find(c.now.Add(-c.period))
 			if idx == -1 {
 				continue
 			}
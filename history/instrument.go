@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/go-trading/alex"
@@ -16,15 +17,21 @@ import (
 var _ alex.Instrument = (*instrument)(nil)
 
 type instrument struct {
-	client     *Client
-	figi       string
-	candles    *Candles
-	lastPrices []*alex.LastPrice
-	orderBook  *alex.OrderBook
-	orders     []*order
-	positions  map[*account]*position
+	client               *Client
+	figi                 string
+	candles              *Candles
+	lastPrices           []*alex.LastPrice
+	orderBook            *alex.OrderBook
+	orderBookSubscribers []alex.OrderBookChan
+	lastPriceSubscribers []alex.LastPriceChan
+	tradeSubscribers     []alex.TradeChan
+	dataFreshSubscribers []alex.DataFreshChan
+	orders               []*order
+	stopOrders           []*stopOrder
+	positions            map[*account]*position
 
-	FUTURE *techan.TimeSeries
+	FUTURE   *alex.IndexedSeries
+	schedule *alex.Schedule
 }
 
 func newInstrument(client *Client, figi string) *instrument {
@@ -38,8 +45,13 @@ func newInstrument(client *Client, figi string) *instrument {
 }
 
 func (i *instrument) load() (err error) {
-	i.FUTURE, err = alex.LoadTimeSeries(i.client.dataDir, i.figi, time.Minute)
-	return err
+	// загружается только окно тестирования и история перед ним, а не вся серия
+	series, err := i.client.candleStore.LoadRange(i.figi, i.client.period, i.client.from.Add(-i.client.lookback), i.client.to.Add(i.client.period))
+	if err != nil {
+		return err
+	}
+	i.FUTURE = alex.WrapSeries(series)
+	return nil
 }
 
 func (i *instrument) GetFigi() string                   { return i.figi }
@@ -65,8 +77,8 @@ func (i *instrument) IsStatus(tradingStatus ...proto.SecurityTradingStatus) bool
 }
 
 func (i *instrument) GetCandles(period time.Duration) alex.Candles {
-	if period != time.Minute {
-		l.DPanic("торги на истории доступны только на минутных свечках")
+	if period != i.client.period {
+		l.DPanic("торги на истории доступны только на свечах, загруженных в клиент", zap.Duration("period", period))
 	}
 	return i.candles
 }
@@ -77,6 +89,106 @@ func (i *instrument) GetOrderBook(ctx context.Context, depth int32) (*alex.Order
 	return i.orderBook, nil
 }
 
+// на истории стакан строится по последней цене, и всегда имеет глубину 1
+func (i *instrument) SubscribeOrderBook(depth int32) (alex.OrderBookChan, error) {
+	ch := make(alex.OrderBookChan, 10)
+	i.orderBookSubscribers = append(i.orderBookSubscribers, ch)
+	return ch, nil
+}
+func (i *instrument) UnsubscribeOrderBook(orderBookChan alex.OrderBookChan) error {
+	for idx, ch := range i.orderBookSubscribers {
+		if ch == orderBookChan {
+			i.orderBookSubscribers = append(i.orderBookSubscribers[:idx], i.orderBookSubscribers[idx+1:]...)
+			close(ch)
+			return nil
+		}
+	}
+	l.DPanic("отписываюсь от стакана, хотя не подписывался на него")
+	return errors.New("NO SUBSCRIPTION")
+}
+func (i *instrument) SubscribeLastPrice() (alex.LastPriceChan, error) {
+	ch := make(alex.LastPriceChan, 50)
+	i.lastPriceSubscribers = append(i.lastPriceSubscribers, ch)
+	return ch, nil
+}
+func (i *instrument) UnsubscribeLastPrice(lastPriceChan alex.LastPriceChan) error {
+	for idx, ch := range i.lastPriceSubscribers {
+		if ch == lastPriceChan {
+			i.lastPriceSubscribers = append(i.lastPriceSubscribers[:idx], i.lastPriceSubscribers[idx+1:]...)
+			close(ch)
+			return nil
+		}
+	}
+	l.DPanic("отписываюсь от последних цен, хотя не подписывался на них")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+// на истории каждое изменение цены считается сделкой в 1 лот
+func (i *instrument) SubscribeTrades() (alex.TradeChan, error) {
+	ch := make(alex.TradeChan, 100)
+	i.tradeSubscribers = append(i.tradeSubscribers, ch)
+	return ch, nil
+}
+func (i *instrument) UnsubscribeTrades(tradeChan alex.TradeChan) error {
+	for idx, ch := range i.tradeSubscribers {
+		if ch == tradeChan {
+			i.tradeSubscribers = append(i.tradeSubscribers[:idx], i.tradeSubscribers[idx+1:]...)
+			close(ch)
+			return nil
+		}
+	}
+	l.DPanic("отписываюсь от сделок, хотя не подписывался на них")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+// на истории торговыми считаются дни, в которые есть свечи. Торги идут с начала первой свечи дня до конца последней
+func (i *instrument) GetSchedule() *alex.Schedule {
+	if i.schedule == nil {
+		i.schedule = alex.NewSchedule(alex.Location(), i.loadTradingDays)
+	}
+	return i.schedule
+}
+
+func (i *instrument) loadTradingDays(ctx context.Context, from time.Time, to time.Time) ([]*alex.TradingDay, error) {
+	var result []*alex.TradingDay
+	var day *alex.TradingDay
+	candles := i.FUTURE.Candles
+	first := sort.Search(len(candles), func(idx int) bool { return !candles[idx].Period.Start.Before(from) })
+	for _, c := range candles[first:] {
+		if !c.Period.Start.Before(to) {
+			break
+		}
+		local := c.Period.Start.In(alex.Location())
+		date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, alex.Location())
+		if day == nil || !day.Date.Equal(date) {
+			day = &alex.TradingDay{Date: date, IsTradingDay: true, Start: c.Period.Start}
+			result = append(result, day)
+		}
+		day.End = c.Period.End
+	}
+	return result, nil
+}
+
+// на истории данные всегда актуальны, поэтому события не отправляются
+func (i *instrument) IsDataFresh() bool                         { return true }
+func (i *instrument) SetStaleThreshold(threshold time.Duration) {}
+func (i *instrument) SubscribeDataFresh() (alex.DataFreshChan, error) {
+	ch := make(alex.DataFreshChan)
+	i.dataFreshSubscribers = append(i.dataFreshSubscribers, ch)
+	return ch, nil
+}
+func (i *instrument) UnsubscribeDataFresh(dataFreshChan alex.DataFreshChan) error {
+	for idx, ch := range i.dataFreshSubscribers {
+		if ch == dataFreshChan {
+			i.dataFreshSubscribers = append(i.dataFreshSubscribers[:idx], i.dataFreshSubscribers[idx+1:]...)
+			close(ch)
+			return nil
+		}
+	}
+	l.DPanic("отписываюсь от актуальности данных, хотя не подписывался на неё")
+	return errors.New("NO SUBSCRIPTION")
+}
+
 //геттеры для позиций
 func (i *instrument) getPosition(a *account) *position { return i.positions[a] }
 func (i *instrument) getBalance(a *account) int64 {
@@ -102,6 +214,7 @@ func (i *instrument) getBuy(a *account) int64 {
 }
 
 func (i *instrument) Tick(lastPrice *alex.LastPrice) {
+	i.checkStopOrders(lastPrice)
 	//если цена подходит текущим ордерам, то исполнить их
 	for _, o := range i.orders {
 		//TODO чтобы увеличить производительность, можно выделить активные ардера в отдельный список
@@ -137,11 +250,42 @@ func (i *instrument) Tick(lastPrice *alex.LastPrice) {
 		ClosePrice: lastPrice.Price,
 		LimitUp:    big.NaN,
 		LimitDown:  big.NaN,
+		Time:       lastPrice.Time,
+	}
+	// подписчик, который не читает канал, не должен останавливать тестирование
+	for _, ch := range i.orderBookSubscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток обработки стаканов. медленная работа робота? deadlock?")
+		} else {
+			ch <- i.orderBook
+		}
+	}
+	for _, ch := range i.lastPriceSubscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток обработки последних цен. медленная работа робота? deadlock?")
+		} else {
+			ch <- lastPrice
+		}
+	}
+	if len(i.tradeSubscribers) > 0 {
+		trade := &alex.Trade{
+			Figi:     i.figi,
+			Price:    lastPrice.Price,
+			Quantity: 1,
+			Time:     lastPrice.Time,
+		}
+		for _, ch := range i.tradeSubscribers {
+			if len(ch) == cap(ch) {
+				l.Error("переполнен поток обработки сделок. медленная работа робота? deadlock?")
+			} else {
+				ch <- trade
+			}
+		}
 	}
 	candle := i.candles.series.LastCandle()
 	if candle == nil || candle.Period.End.Before(lastPrice.Time.Add(1)) {
 		candle = &techan.Candle{
-			Period: techan.NewTimePeriod(lastPrice.Time, time.Minute),
+			Period: techan.NewTimePeriod(lastPrice.Time, i.client.period),
 		}
 	}
 	candle.AddTrade(big.ONE, lastPrice.Price)
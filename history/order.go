@@ -77,7 +77,7 @@ func (o *order) IsActive() bool {
 func (o *order) IsBestInOrderBook(ctx context.Context) bool {
 	ob, err := o.instrument.GetOrderBook(ctx, 1)
 	if err != nil {
-		l.DPanic("GetOrderBook", zap.Error(err))
+		l.Error("GetOrderBook", zap.Error(err))
 		return false
 	}
 	return (o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY &&
@@ -95,7 +95,7 @@ func (o *order) IsLimitError() bool { return false }
 
 //Stringer interface
 func (o *order) String() string {
-	return o.filledTime.Format("2006-01-02 15:04") + "\t" +
+	return alex.FormatTime(o.filledTime) + "\t" +
 		o.instrument.figi + "\t" +
 		strings.ReplaceAll(o.direction.String(), "ORDER_DIRECTION_", "") + "\t" +
 		strconv.Itoa(int(o.quantity)) + "\t" +
@@ -0,0 +1,88 @@
+package history
+
+// Стоп-заявки на истории. Проверяются на каждом изменении цены, до исполнения обычных заявок,
+// поэтому сработавшая стоп-заявка исполняется на той же цене
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+)
+
+type stopOrder struct {
+	alex.StopOrder
+	account *account
+}
+
+func (a *account) PostStopOrder(_ context.Context, inst alex.Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error) {
+	i := inst.(*instrument)
+	o := &stopOrder{
+		StopOrder: alex.StopOrder{
+			StopOrderId:    uuid.NewString(),
+			Figi:           i.figi,
+			LotsRequested:  quantity,
+			Direction:      direction,
+			Currency:       i.GetCurrency(),
+			OrderType:      stopOrderType,
+			CreateDate:     i.Now(),
+			ExpirationTime: expireDate,
+			Price:          price,
+			StopPrice:      stopPrice,
+		},
+		account: a,
+	}
+	i.stopOrders = append(i.stopOrders, o)
+	return o.StopOrderId, nil
+}
+
+func (a *account) GetStopOrders(_ context.Context) (result []*alex.StopOrder, _ error) {
+	for _, instrument := range a.client.instruments {
+		for _, o := range instrument.stopOrders {
+			if o.account == a {
+				result = append(result, &o.StopOrder)
+			}
+		}
+	}
+	return result, nil
+}
+
+func (a *account) CancelStopOrder(_ context.Context, stopOrderId string) (time.Time, error) {
+	for _, instrument := range a.client.instruments {
+		for idx, o := range instrument.stopOrders {
+			if o.StopOrderId == stopOrderId && o.account == a {
+				instrument.stopOrders = append(instrument.stopOrders[:idx], instrument.stopOrders[idx+1:]...)
+				return instrument.Now(), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("стоп-заявка %s: %w", stopOrderId, alex.ErrNotFound)
+}
+
+// снимает просроченные стоп-заявки, и выставляет заявки по сработавшим
+func (i *instrument) checkStopOrders(lastPrice *alex.LastPrice) {
+	active := i.stopOrders[:0]
+	var triggered []*stopOrder
+	for _, o := range i.stopOrders {
+		switch {
+		case o.IsExpired(lastPrice.Time):
+		case o.IsTriggered(lastPrice.Price):
+			o.ActivationDate = lastPrice.Time
+			triggered = append(triggered, o)
+		default:
+			active = append(active, o)
+		}
+	}
+	i.stopOrders = active
+	for _, o := range triggered {
+		price := lastPrice.Price
+		if o.GetOrderType() == proto.OrderType_ORDER_TYPE_LIMIT {
+			price = o.Price
+		}
+		i.PostOrder(newOrder(o.account, i, o.LotsRequested, price, o.GetOrderDirection(), o.GetOrderType()))
+	}
+}
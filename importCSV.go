@@ -0,0 +1,258 @@
+package alex
+
+// Импорт свечей из csv файлов других поставщиков (экспорт Финама, выгрузки MOEX ISS и т.п.)
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+// поля свечи, которые можно сопоставить столбцам файла
+const (
+	ImportField_DATE   = "date"   // дата, или дата и время, если они в одном столбце
+	ImportField_TIME   = "time"   // время, если оно в отдельном столбце
+	ImportField_OPEN   = "open"   // цена открытия
+	ImportField_HIGH   = "high"   // максимальная цена
+	ImportField_LOW    = "low"    // минимальная цена
+	ImportField_CLOSE  = "close"  // цена закрытия
+	ImportField_VOLUME = "volume" // объём
+)
+
+var requiredImportFields = []string{ImportField_DATE, ImportField_OPEN, ImportField_HIGH, ImportField_LOW, ImportField_CLOSE, ImportField_VOLUME}
+
+// настройки разбора csv файла
+type CSVImportConfig struct {
+	Comma      rune              // разделитель столбцов
+	Columns    map[string]string // поле свечи -> имя столбца в заголовке, или номер столбца начиная с 0
+	DateLayout string            // формат даты в нотации go, например 20060102 или 2006-01-02 15:04:05
+	TimeLayout string            // формат времени, если оно в отдельном столбце, например 150405
+	Location   *time.Location    // часовой пояс, в котором записано время в файле
+	// объём в файле в штуках, а в хранилище он в лотах, поэтому при импорте объём делится на Lot
+	VolumeInShares bool
+	Lot            int // лотность инструмента, обязательна при VolumeInShares
+}
+
+// готовые настройки для известных поставщиков. Время в обоих случаях московское, и обозначает начало свечи
+func CSVImportPresets() map[string]CSVImportConfig {
+	moscow := MoscowLocation()
+	return map[string]CSVImportConfig{
+		// экспорт котировок с сайта finam.ru
+		"finam": {
+			Comma: ',',
+			Columns: map[string]string{
+				ImportField_DATE:   "<DATE>",
+				ImportField_TIME:   "<TIME>",
+				ImportField_OPEN:   "<OPEN>",
+				ImportField_HIGH:   "<HIGH>",
+				ImportField_LOW:    "<LOW>",
+				ImportField_CLOSE:  "<CLOSE>",
+				ImportField_VOLUME: "<VOL>",
+			},
+			DateLayout: "20060102",
+			TimeLayout: "150405",
+			Location:   moscow,
+		},
+		// iss.moex.com/iss/engines/stock/markets/shares/securities/XXX/candles.csv
+		// объём в выгрузке в штуках, а не в лотах, поэтому для импорта нужна лотность инструмента
+		"moex": {
+			Comma: ';',
+			Columns: map[string]string{
+				ImportField_DATE:   "begin",
+				ImportField_OPEN:   "open",
+				ImportField_HIGH:   "high",
+				ImportField_LOW:    "low",
+				ImportField_CLOSE:  "close",
+				ImportField_VOLUME: "volume",
+			},
+			DateLayout:     "2006-01-02 15:04:05",
+			Location:       moscow,
+			VolumeInShares: true,
+		},
+	}
+}
+
+// разбирает сопоставление столбцов вида "date:<DATE>,open:3,..."
+func ParseImportColumns(s string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("ожидается поле:столбец, получено %q", pair)
+		}
+		column := strings.TrimSpace(kv[1])
+		if idx, err := strconv.Atoi(column); err == nil && idx < 0 {
+			return nil, fmt.Errorf("номер столбца не может быть отрицательным: %q", pair)
+		}
+		result[strings.TrimSpace(kv[0])] = column
+	}
+	return result, nil
+}
+
+// читает свечи из csv файла стороннего поставщика.
+// Если столбцы заданы по имени, то заголовком считается первая строка, в которой есть все указанные имена,
+// все строки до неё пропускаются. Строки, которые не удалось разобрать, возвращаются в виде списка проблем
+func ImportCSV(r io.Reader, period time.Duration, cfg CSVImportConfig) ([]*techan.Candle, []DataIssue, error) {
+	for _, field := range requiredImportFields {
+		if _, ok := cfg.Columns[field]; !ok {
+			return nil, nil, fmt.Errorf("не задан столбец для поля %s", field)
+		}
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.UTC
+	}
+	if cfg.VolumeInShares && cfg.Lot <= 0 {
+		return nil, nil, errors.New("объём в файле в штуках, для перевода в лоты нужна лотность инструмента")
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = cfg.Comma
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	indexes, byName := columnIndexes(cfg.Columns)
+	headerFound := !byName
+
+	var result []*techan.Candle
+	var issues []DataIssue
+	line := 0
+	for {
+		line++
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Line: line, Message: err.Error()})
+			continue
+		}
+		if !headerFound {
+			headerFound = findHeader(record, cfg.Columns, indexes)
+			continue
+		}
+
+		candle, err := parseImportRecord(record, indexes, period, cfg)
+		if err != nil {
+			// файлы с номерами столбцов часто начинаются с заголовка, его не считаю ошибкой
+			if line == 1 {
+				continue
+			}
+			issues = append(issues, DataIssue{Type: DataIssue_MALFORMED, Line: line, Message: err.Error()})
+			continue
+		}
+		result = append(result, candle)
+	}
+	if !headerFound {
+		return nil, issues, errors.New("в файле не найден заголовок с указанными столбцами")
+	}
+	return result, issues, nil
+}
+
+// номера столбцов, заданных числом. Второй результат - есть ли столбцы, заданные по имени
+func columnIndexes(columns map[string]string) (map[string]int, bool) {
+	indexes := make(map[string]int)
+	byName := false
+	for field, column := range columns {
+		idx, err := strconv.Atoi(column)
+		if err != nil {
+			byName = true
+			continue
+		}
+		indexes[field] = idx
+	}
+	return indexes, byName
+}
+
+func findHeader(record []string, columns map[string]string, indexes map[string]int) bool {
+	found := make(map[string]int)
+	for idx, name := range record {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		for field, column := range columns {
+			if strings.EqualFold(name, column) {
+				found[field] = idx
+			}
+		}
+	}
+	for field := range columns {
+		if _, ok := indexes[field]; ok {
+			continue
+		}
+		if _, ok := found[field]; !ok {
+			return false
+		}
+	}
+	for field, idx := range found {
+		indexes[field] = idx
+	}
+	return true
+}
+
+func parseImportRecord(record []string, indexes map[string]int, period time.Duration, cfg CSVImportConfig) (*techan.Candle, error) {
+	get := func(field string) (string, error) {
+		idx, ok := indexes[field]
+		if !ok {
+			return "", nil
+		}
+		if idx < 0 || idx >= len(record) {
+			return "", fmt.Errorf("нет столбца %d для поля %s", idx, field)
+		}
+		return strings.TrimSpace(record[idx]), nil
+	}
+	number := func(field string) (big.Decimal, error) {
+		value, err := get(field)
+		if err != nil {
+			return big.NaN, err
+		}
+		// в русских выгрузках дробная часть часто отделяется запятой
+		value = strings.ReplaceAll(strings.ReplaceAll(value, " ", ""), ",", ".")
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return big.NaN, fmt.Errorf("поле %s: %w", field, err)
+		}
+		return big.NewFromString(value), nil
+	}
+
+	date, err := get(ImportField_DATE)
+	if err != nil {
+		return nil, err
+	}
+	layout := cfg.DateLayout
+	if cfg.TimeLayout != "" {
+		clock, err := get(ImportField_TIME)
+		if err != nil {
+			return nil, err
+		}
+		date += " " + clock
+		layout += " " + cfg.TimeLayout
+	}
+	t, err := time.ParseInLocation(layout, date, cfg.Location)
+	if err != nil {
+		return nil, err
+	}
+
+	candle := &techan.Candle{Period: techan.NewTimePeriod(t.UTC(), period)}
+	for field, value := range map[string]*big.Decimal{
+		ImportField_OPEN:   &candle.OpenPrice,
+		ImportField_HIGH:   &candle.MaxPrice,
+		ImportField_LOW:    &candle.MinPrice,
+		ImportField_CLOSE:  &candle.ClosePrice,
+		ImportField_VOLUME: &candle.Volume,
+	} {
+		if *value, err = number(field); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.VolumeInShares {
+		candle.Volume = candle.Volume.Div(big.NewFromInt(cfg.Lot))
+	}
+	return candle, nil
+}
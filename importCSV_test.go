@@ -0,0 +1,41 @@
+package alex
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportColumns(t *testing.T) {
+	columns, err := ParseImportColumns("date:0, open:Open ,close:4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if columns["date"] != "0" || columns["open"] != "Open" || columns["close"] != "4" {
+		t.Fatalf("неверные столбцы %v", columns)
+	}
+	for _, s := range []string{"open:-1", "open", "date:0,close:-5"} {
+		if _, err := ParseImportColumns(s); err == nil {
+			t.Errorf("%q: ожидалась ошибка", s)
+		}
+	}
+}
+
+// отрицательный номер столбца, заданный в обход ParseImportColumns, даёт ошибку строки, а не панику
+func TestImportCSVNegativeColumn(t *testing.T) {
+	cfg := CSVImportConfig{
+		Comma: ',',
+		Columns: map[string]string{
+			ImportField_DATE: "0", ImportField_OPEN: "-1", ImportField_HIGH: "2",
+			ImportField_LOW: "3", ImportField_CLOSE: "4", ImportField_VOLUME: "5",
+		},
+		DateLayout: "2006-01-02 15:04",
+	}
+	candles, issues, err := ImportCSV(strings.NewReader("date,open,high,low,close,volume\n2022-05-01 10:00,1,2,0.5,1.5,10\n"), time.Minute, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candles) != 0 || len(issues) != 1 {
+		t.Fatalf("свечи %d, проблемы %+v", len(candles), issues)
+	}
+}
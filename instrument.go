@@ -8,6 +8,9 @@ import (
 	"github.com/sdcoffey/big"
 )
 
+// канал, в который передаётся актуальность рыночных данных инструмента при её изменении
+type DataFreshChan chan bool
+
 // получение информации по инструменту
 type Instrument interface {
 	GetCandles(period time.Duration) Candles                           // Получить интерфейс дл работы со свечами указанного периода
@@ -21,9 +24,20 @@ type Instrument interface {
 	GetName() string                                                   // Название инструмента.
 	GetLastPrices(ctx context.Context) ([]*LastPrice, error)           // Получить массив последних цен инструмента
 	GetOrderBook(ctx context.Context, depth int32) (*OrderBook, error) // Получить стакан инструмента
+	SubscribeOrderBook(depth int32) (OrderBookChan, error)             // Подписаться на изменения стакана указанной глубины
+	UnsubscribeOrderBook(orderBookChan OrderBookChan) error            // Отписаться от изменений стакана
+	SubscribeLastPrice() (LastPriceChan, error)                        // Подписаться на последние цены
+	UnsubscribeLastPrice(lastPriceChan LastPriceChan) error            // Отписаться от последних цен
+	SubscribeTrades() (TradeChan, error)                               // Подписаться на обезличенные сделки
+	UnsubscribeTrades(tradeChan TradeChan) error                       // Отписаться от обезличенных сделок
+	IsDataFresh() bool                                                 // Актуальны ли рыночные данные: поток жив, и данные по инструменту приходили не позже порога
+	SetStaleThreshold(threshold time.Duration)                         // Через сколько без данных по инструменту считать их устаревшими. 0 - проверять только поток
+	SubscribeDataFresh() (DataFreshChan, error)                        // Подписаться на изменение актуальности данных
+	UnsubscribeDataFresh(dataFreshChan DataFreshChan) error            // Отписаться от изменения актуальности данных
 	GetMinPriceIncrement() big.Decimal                                 // Шаг цены.
 	IsStatus(tradingStatus ...proto.SecurityTradingStatus) bool        // Проверяет, является ли статус инструмента, любым из указанных в аргументах
 	IsLimitOrderAvailable() bool                                       // Можно ли выставлять лимитные заявки по данному инструменту
 	IsMarketOrderAvailable() bool                                      // Можно ли выставлять рыночные заявки по данному инструменту
 	Now() time.Time                                                    // Текущее (для тестирования на истории)
+	GetSchedule() *Schedule                                            // Расписание торгов площадки инструмента
 }
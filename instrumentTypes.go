@@ -0,0 +1,86 @@
+package alex
+
+// Дополнительная информация по облигациям, фьючерсам и валютам.
+// Интерфейсы необязательные: инструмент реализует тот, который соответствует его типу, поэтому
+// проверяется он приведением типа, например if bond, ok := instrument.(alex.BondInstrument); ok {...}
+
+import (
+	"context"
+	"time"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+	"github.com/sdcoffey/big"
+)
+
+// облигация
+type BondInstrument interface {
+	Instrument
+	GetNominal() *Money                                                    // Номинал облигации
+	GetAciValue() *Money                                                   // НКД (накопленный купонный доход) на дату загрузки справочника
+	GetCouponQuantityPerYear() int32                                       // Количество выплат по купонам в год
+	GetMaturityDate() time.Time                                            // Дата погашения. Нулевое время для бессрочных облигаций
+	IsFloatingCoupon() bool                                                // Облигация с плавающим купоном
+	IsAmortization() bool                                                  // Облигация с амортизацией долга
+	GetCoupons(ctx context.Context, from, to time.Time) ([]*Coupon, error) // Купоны с датой выплаты в указанном периоде
+}
+
+// фьючерс
+type FuturesInstrument interface {
+	Instrument
+	GetFirstTradeDate() time.Time                          // Дата начала обращения контракта
+	GetLastTradeDate() time.Time                           // Дата, до которой возможно проведение операций с фьючерсом
+	GetExpirationDate() time.Time                          // Дата истечения срока
+	GetFuturesType() string                                // Тип фьючерса: physical_delivery или cash_settlement
+	GetAssetType() string                                  // Тип актива: commodity, currency, security или index
+	GetBasicAsset() string                                 // Основной актив
+	GetBasicAssetSize() big.Decimal                        // Размер основного актива
+	GetPointValue(ctx context.Context) (*Money, error)     // Стоимость одного пункта цены в валюте расчётов
+	GetInitialMargin(ctx context.Context) (*Margin, error) // Гарантийное обеспечение
+}
+
+// валюта. Торгуется в паре с валютой расчётов GetCurrency(), например USD/RUB
+type CurrencyInstrument interface {
+	Instrument
+	GetIsoCurrencyName() string // ISO-код торгуемой валюты
+	GetNominal() *Money         // Номинал
+}
+
+// купон облигации
+type Coupon struct {
+	Figi            string           // Figi-идентификатор облигации
+	Number          int64            // Номер купона
+	Date            time.Time        // Дата выплаты
+	FixDate         time.Time        // Дата фиксации реестра, если известна
+	PayOneBond      *Money           // Выплата на одну облигацию
+	Type            proto.CouponType // Тип купона
+	PeriodStartDate time.Time        // Начало купонного периода
+	PeriodEndDate   time.Time        // Окончание купонного периода
+	PeriodDays      int32            // Купонный период в днях
+}
+
+func NewCoupon(c *proto.Coupon) *Coupon {
+	coupon := &Coupon{
+		Figi:       c.Figi,
+		Number:     c.CouponNumber,
+		Date:       c.CouponDate.AsTime(),
+		PayOneBond: NewMoney(c.PayOneBond),
+		Type:       c.CouponType,
+		PeriodDays: c.CouponPeriod,
+	}
+	if c.FixDate != nil {
+		coupon.FixDate = c.FixDate.AsTime()
+	}
+	if c.CouponStartDate != nil {
+		coupon.PeriodStartDate = c.CouponStartDate.AsTime()
+	}
+	if c.CouponEndDate != nil {
+		coupon.PeriodEndDate = c.CouponEndDate.AsTime()
+	}
+	return coupon
+}
+
+// гарантийное обеспечение по фьючерсу
+type Margin struct {
+	OnBuy  *Money // при покупке
+	OnSell *Money // при продаже
+}
@@ -66,6 +66,9 @@ type LastPrice struct {
 	Time  time.Time   //Время получения последней цены в часовом поясе UTC по времени биржи.
 }
 
+// канал получения последних цен
+type LastPriceChan chan *LastPrice
+
 func NewLastPrice(figi string, price big.Decimal, time time.Time) *LastPrice {
 	return &LastPrice{
 		Figi:  figi,
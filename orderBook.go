@@ -1,6 +1,8 @@
 package alex
 
 import (
+	"time"
+
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 	"github.com/sdcoffey/big"
 )
@@ -32,8 +34,12 @@ type OrderBook struct {
 	ClosePrice big.Decimal      //Цена закрытия за 1 инструмент. Для получения стоимости лота требуется умножить на лотность инструмента.
 	LimitUp    big.Decimal      //Верхний лимит цены за 1 инструмент. Для получения стоимости лота требуется умножить на лотность инструмента.
 	LimitDown  big.Decimal      //Нижний лимит цены за 1 инструмент. Для получения стоимости лота требуется умножить на лотность инструмента.
+	Time       time.Time        //Время формирования стакана. Заполняется только для стаканов из потока
 }
 
+// канал получения изменений стакана
+type OrderBookChan chan *OrderBook
+
 func NewOrderBook(ob *proto.GetOrderBookResponse) *OrderBook {
 	return &OrderBook{
 		Figi:       ob.Figi,
@@ -46,3 +52,34 @@ func NewOrderBook(ob *proto.GetOrderBookResponse) *OrderBook {
 		Bids:       NewOrderBookOrders(ob.Bids),
 	}
 }
+
+// стакан из потока рыночных данных. Цены последней сделки и закрытия в потоке не передаются
+func NewStreamOrderBook(ob *proto.OrderBook) *OrderBook {
+	return &OrderBook{
+		Figi:       ob.Figi,
+		Depth:      ob.Depth,
+		LastPrice:  big.NaN,
+		ClosePrice: big.NaN,
+		LimitUp:    NewDecimal(ob.LimitUp),
+		LimitDown:  NewDecimal(ob.LimitDown),
+		Asks:       NewOrderBookOrders(ob.Asks),
+		Bids:       NewOrderBookOrders(ob.Bids),
+		Time:       ob.Time.AsTime(),
+	}
+}
+
+// стакан меньшей глубины
+func (ob *OrderBook) Truncate(depth int32) *OrderBook {
+	if ob.Depth <= depth {
+		return ob
+	}
+	result := *ob
+	result.Depth = depth
+	if len(result.Bids) > int(depth) {
+		result.Bids = result.Bids[:depth]
+	}
+	if len(result.Asks) > int(depth) {
+		result.Asks = result.Asks[:depth]
+	}
+	return &result
+}
@@ -0,0 +1,82 @@
+package alex
+
+// Построение свечей старших периодов (10m, 30m, 4h, неделя, ...) из свечей младших периодов.
+// Внутри дня границы свечей отсчитываются от начала торговой сессии в часовом поясе биржи,
+// поэтому 4h свечи на Московской бирже начинаются в 10:00, 14:00 и 18:00, а не в 00:00 UTC
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+const week = 7 * 24 * time.Hour
+
+// проверяет, что свечи периода from можно собрать в свечи периода to
+func CheckResamplePeriods(from time.Duration, to time.Duration) error {
+	if from <= 0 || to <= 0 {
+		return fmt.Errorf("период свечей должен быть больше нуля")
+	}
+	if to <= from || to%from != 0 {
+		return fmt.Errorf("период %s не кратен периоду %s", to, from)
+	}
+	if to > 24*time.Hour && to != week {
+		return fmt.Errorf("из периодов больше суток поддерживается только неделя (%s)", week)
+	}
+	// внутридневные свечи каждый день отсчитываются заново, поэтому сутки должны делиться на них без остатка,
+	// иначе последняя свеча дня перекрывалась бы со свечами следующего дня
+	if to < 24*time.Hour && (24*time.Hour)%to != 0 {
+		return fmt.Errorf("период %s не делит сутки без остатка", to)
+	}
+	return nil
+}
+
+// время начала свечи периода period, в которую попадает момент t.
+// Для внутридневных периодов свечи отсчитываются от начала сессии sessionStart в часовом поясе location,
+// дневные свечи начинаются в полночь, недельные - в полночь понедельника
+func BucketStart(t time.Time, period time.Duration, location *time.Location, sessionStart time.Duration) time.Time {
+	local := t.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	switch {
+	case period == week:
+		daysSinceMonday := (int(midnight.Weekday()) + 6) % 7
+		return midnight.AddDate(0, 0, -daysSinceMonday)
+	case period >= 24*time.Hour:
+		return midnight
+	}
+	anchor := midnight.Add(sessionStart)
+	offset := local.Sub(anchor)
+	buckets := offset / period
+	if offset < 0 && offset%period != 0 {
+		buckets-- // округление вниз для свечей до начала сессии
+	}
+	return anchor.Add(buckets * period)
+}
+
+// собирает свечи периода period из отсортированной по времени серии свечей меньшего периода
+func ResampleSeries(series *techan.TimeSeries, period time.Duration, location *time.Location, sessionStart time.Duration) *techan.TimeSeries {
+	result := techan.NewTimeSeries()
+	var current *techan.Candle
+	for _, c := range series.Candles {
+		start := BucketStart(c.Period.Start, period, location, sessionStart)
+		if current == nil || !current.Period.Start.Equal(start) {
+			current = &techan.Candle{
+				Period:     techan.NewTimePeriod(start.UTC(), period),
+				OpenPrice:  c.OpenPrice,
+				MaxPrice:   c.MaxPrice,
+				MinPrice:   c.MinPrice,
+				ClosePrice: c.ClosePrice,
+				Volume:     c.Volume,
+			}
+			result.Candles = append(result.Candles, current)
+			continue
+		}
+		current.MaxPrice = big.MaxSlice(current.MaxPrice, c.MaxPrice)
+		current.MinPrice = big.MinSlice(current.MinPrice, c.MinPrice)
+		current.ClosePrice = c.ClosePrice
+		current.Volume = current.Volume.Add(c.Volume)
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package alex
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+func TestCheckResamplePeriods(t *testing.T) {
+	for _, tc := range []struct {
+		from, to time.Duration
+		ok       bool
+	}{
+		{time.Minute, 5 * time.Minute, true},
+		{time.Minute, 4 * time.Hour, true},
+		{time.Hour, 24 * time.Hour, true},
+		{time.Hour, week, true},
+		{time.Minute, 7 * time.Hour, false},   // сутки не делятся на 7 часов
+		{time.Minute, 7 * time.Minute, false}, // и на 7 минут
+		{5 * time.Minute, 12 * time.Minute, false},
+		{time.Hour, time.Hour, false},
+		{time.Hour, 48 * time.Hour, false},
+		{0, time.Hour, false},
+	} {
+		err := CheckResamplePeriods(tc.from, tc.to)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s -> %s: %v", tc.from, tc.to, err)
+		}
+	}
+}
+
+func TestBucketStart(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	session := 10 * time.Hour
+	msk := func(day, hour, min int) time.Time { return time.Date(2022, 5, day, hour, min, 0, 0, moscow) }
+	for _, tc := range []struct {
+		t      time.Time
+		period time.Duration
+		want   time.Time
+	}{
+		{msk(4, 10, 0), 4 * time.Hour, msk(4, 10, 0)},
+		{msk(4, 13, 59), 4 * time.Hour, msk(4, 10, 0)},
+		{msk(4, 18, 30), 4 * time.Hour, msk(4, 18, 0)},
+		{msk(4, 23, 59), 4 * time.Hour, msk(4, 22, 0)},
+		// до начала сессии свеча начинается накануне, так же как последняя свеча предыдущего дня
+		{msk(5, 1, 0), 4 * time.Hour, msk(4, 22, 0)},
+		{msk(5, 9, 59), 4 * time.Hour, msk(5, 6, 0)},
+		{msk(4, 10, 7), 5 * time.Minute, msk(4, 10, 5)},
+		{msk(4, 9, 58), 5 * time.Minute, msk(4, 9, 55)},
+		{msk(4, 15, 0), 24 * time.Hour, msk(4, 0, 0)},
+		// 4 мая 2022 - среда
+		{msk(4, 15, 0), week, msk(2, 0, 0)},
+		{msk(8, 23, 0), week, msk(2, 0, 0)},
+		{msk(9, 0, 0), week, msk(9, 0, 0)},
+	} {
+		got := BucketStart(tc.t.UTC(), tc.period, moscow, session)
+		if !got.Equal(tc.want) {
+			t.Errorf("%s %s: %s, ожидалось %s", tc.t, tc.period, got.In(moscow), tc.want)
+		}
+	}
+}
+
+func TestResampleSeries(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	series := techan.NewTimeSeries()
+	// часовые свечи с 20:00 4 мая до 03:00 5 мая по Москве, цена растёт на 1 каждый час
+	start := time.Date(2022, 5, 4, 20, 0, 0, 0, moscow)
+	for i := 0; i < 8; i++ {
+		c := techan.NewCandle(techan.NewTimePeriod(start.Add(time.Duration(i)*time.Hour).UTC(), time.Hour))
+		c.OpenPrice = big.NewFromInt(100 + i)
+		c.MaxPrice = big.NewFromInt(101 + i)
+		c.MinPrice = big.NewFromInt(99 + i)
+		c.ClosePrice = big.NewFromInt(100 + i)
+		c.Volume = big.NewFromInt(10)
+		series.Candles = append(series.Candles, c)
+	}
+
+	result := ResampleSeries(series, 4*time.Hour, moscow, 10*time.Hour)
+	// 18:00-22:00, 22:00-02:00 (через полночь), 02:00-06:00
+	want := []struct {
+		start           time.Time
+		open, high, low int
+		close, volume   int
+	}{
+		{time.Date(2022, 5, 4, 18, 0, 0, 0, moscow), 100, 102, 99, 101, 20},
+		{time.Date(2022, 5, 4, 22, 0, 0, 0, moscow), 102, 106, 101, 105, 40},
+		{time.Date(2022, 5, 5, 2, 0, 0, 0, moscow), 106, 108, 105, 107, 20},
+	}
+	if len(result.Candles) != len(want) {
+		t.Fatalf("получено %d свечей, ожидалось %d", len(result.Candles), len(want))
+	}
+	for i, w := range want {
+		c := result.Candles[i]
+		if !c.Period.Start.Equal(w.start) || c.Period.End.Sub(c.Period.Start) != 4*time.Hour {
+			t.Errorf("свеча %d: период %v, ожидалось начало %s", i, c.Period, w.start)
+		}
+		if !c.OpenPrice.EQ(big.NewFromInt(w.open)) || !c.MaxPrice.EQ(big.NewFromInt(w.high)) ||
+			!c.MinPrice.EQ(big.NewFromInt(w.low)) || !c.ClosePrice.EQ(big.NewFromInt(w.close)) ||
+			!c.Volume.EQ(big.NewFromInt(w.volume)) {
+			t.Errorf("свеча %d: %s %s %s %s %s", i, c.OpenPrice, c.MaxPrice, c.MinPrice, c.ClosePrice, c.Volume)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package alex
+
+// Расписание торгов площадки.
+// Торговые дни загружаются загрузчиком по мере обращения к ним и кешируются. День, на который приходится момент
+// времени, определяется в часовом поясе площадки
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	scheduleLoadDays  = 7  // сколько дней до и после запрошенной даты загружать за раз
+	scheduleSearchMax = 30 // сколько дней просматривать в поисках торгового дня
+)
+
+var ErrNoSchedule = errors.New("нет расписания торгов")
+
+// торговый день площадки
+type TradingDay struct {
+	Date         time.Time // дата (полночь в часовом поясе площадки)
+	IsTradingDay bool      // идут ли в этот день торги
+	Start        time.Time // начало торгов
+	End          time.Time // окончание торгов, включая вечернюю сессию
+}
+
+// идут ли торги в момент t
+func (d *TradingDay) IsOpen(t time.Time) bool {
+	return d.IsTradingDay && !t.Before(d.Start) && t.Before(d.End)
+}
+
+// загружает торговые дни площадки в интервале [from, to)
+type TradingDaysLoader func(ctx context.Context, from time.Time, to time.Time) ([]*TradingDay, error)
+
+type Schedule struct {
+	locker   sync.Mutex
+	location *time.Location
+	load     TradingDaysLoader
+	days     map[string]*TradingDay // дата в формате 2006-01-02 -> торговый день
+}
+
+func NewSchedule(location *time.Location, load TradingDaysLoader) *Schedule {
+	return &Schedule{
+		location: location,
+		load:     load,
+		days:     make(map[string]*TradingDay),
+	}
+}
+
+func (s *Schedule) dateKey(t time.Time) string {
+	return t.In(s.location).Format("2006-01-02")
+}
+
+// торговый день, на который приходится момент t
+func (s *Schedule) GetTradingDay(ctx context.Context, t time.Time) (*TradingDay, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	key := s.dateKey(t)
+	if day, ok := s.days[key]; ok {
+		return day, nil
+	}
+	local := t.In(s.location)
+	date := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location)
+	days, err := s.load(ctx, date.AddDate(0, 0, -scheduleLoadDays), date.AddDate(0, 0, scheduleLoadDays+1))
+	if err != nil {
+		return nil, err
+	}
+	for _, day := range days {
+		s.days[s.dateKey(day.Date)] = day
+	}
+	day, ok := s.days[key]
+	if !ok {
+		// загрузчик ничего не знает про этот день, запоминаю как неторговый, чтобы не загружать повторно
+		day = &TradingDay{Date: date}
+		s.days[key] = day
+	}
+	return day, nil
+}
+
+func (s *Schedule) IsTradingDay(ctx context.Context, t time.Time) (bool, error) {
+	day, err := s.GetTradingDay(ctx, t)
+	if err != nil {
+		return false, err
+	}
+	return day.IsTradingDay, nil
+}
+
+// начало торгов в день, на который приходится t. Нулевое время, если день неторговый
+func (s *Schedule) SessionOpen(ctx context.Context, t time.Time) (time.Time, error) {
+	day, err := s.GetTradingDay(ctx, t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.Start, nil
+}
+
+// окончание торгов в день, на который приходится t. Нулевое время, если день неторговый
+func (s *Schedule) SessionClose(ctx context.Context, t time.Time) (time.Time, error) {
+	day, err := s.GetTradingDay(ctx, t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return day.End, nil
+}
+
+// сколько осталось до окончания торгов. 0, если торги в момент t не идут
+func (s *Schedule) TimeToClose(ctx context.Context, t time.Time) (time.Duration, error) {
+	day, err := s.GetTradingDay(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	if !day.IsOpen(t) {
+		return 0, nil
+	}
+	return day.End.Sub(t), nil
+}
+
+// начало ближайших торгов после момента t
+func (s *Schedule) NextSessionStart(ctx context.Context, t time.Time) (time.Time, error) {
+	for i := 0; i < scheduleSearchMax; i++ {
+		day, err := s.GetTradingDay(ctx, t.AddDate(0, 0, i))
+		if err != nil {
+			return time.Time{}, err
+		}
+		if day.IsTradingDay && day.Start.After(t) {
+			return day.Start, nil
+		}
+	}
+	return time.Time{}, ErrNoSchedule
+}
+
+// последний торговый день до дня, на который приходится t
+func (s *Schedule) PreviousTradingDay(ctx context.Context, t time.Time) (*TradingDay, error) {
+	for i := 1; i <= scheduleSearchMax; i++ {
+		day, err := s.GetTradingDay(ctx, t.AddDate(0, 0, -i))
+		if err != nil {
+			return nil, err
+		}
+		if day.IsTradingDay {
+			return day, nil
+		}
+	}
+	return nil, ErrNoSchedule
+}
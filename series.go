@@ -0,0 +1,87 @@
+package alex
+
+// Серия свечей с быстрым поиском по времени.
+// Свечи в серии упорядочены по времени начала, поэтому поиск выполняется делением пополам.
+// Дополнительно запоминается позиция последней найденной свечи: и на истории, и в реальном времени
+// обращения идут к той же или следующей свече, и такой поиск выполняется за O(1).
+// Добавление свечи позже последней тоже выполняется за O(1)
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sdcoffey/techan"
+)
+
+type IndexedSeries struct {
+	*techan.TimeSeries
+	lastIdx int // позиция последней найденной свечи
+}
+
+func NewIndexedSeries() *IndexedSeries {
+	return WrapSeries(techan.NewTimeSeries())
+}
+
+// индекс поверх уже упорядоченной по времени серии. Серия не копируется
+func WrapSeries(series *techan.TimeSeries) *IndexedSeries {
+	if series == nil {
+		series = techan.NewTimeSeries()
+	}
+	return &IndexedSeries{TimeSeries: series}
+}
+
+// индекс свечи, в которую попадает момент t, или -1
+func (s *IndexedSeries) Find(t time.Time) int {
+	if s == nil {
+		return -1
+	}
+	candles := s.Candles
+	// сначала проверяю последнюю найденную свечу и следующую за ней
+	for idx := s.lastIdx; idx < len(candles) && idx <= s.lastIdx+1; idx++ {
+		if candleContains(candles[idx], t) {
+			s.lastIdx = idx
+			return idx
+		}
+	}
+	idx := searchSeries(candles, t)
+	if idx != -1 {
+		s.lastIdx = idx
+	}
+	return idx
+}
+
+// добавляет свечу, или заменяет свечу, в которую попадает начало новой
+func (s *IndexedSeries) Upsert(newCandle *techan.Candle) {
+	last := s.LastCandle()
+	if last == nil || newCandle.Period.Start.After(last.Period.Start) && !candleContains(last, newCandle.Period.Start) {
+		s.Candles = append(s.Candles, newCandle)
+		return
+	}
+	if idx := s.Find(newCandle.Period.Start); idx != -1 {
+		s.Candles[idx] = newCandle
+		return
+	}
+	// свеча из прошлого, которой ещё нет в серии
+	idx := sort.Search(len(s.Candles), func(i int) bool {
+		return s.Candles[i].Period.Start.After(newCandle.Period.Start)
+	})
+	s.Candles = append(s.Candles, nil)
+	copy(s.Candles[idx+1:], s.Candles[idx:])
+	s.Candles[idx] = newCandle
+}
+
+func candleContains(c *techan.Candle, t time.Time) bool {
+	return (t.After(c.Period.Start) && t.Before(c.Period.End)) || t.Equal(c.Period.Start)
+}
+
+// поиск делением пополам в упорядоченных по времени начала свечах
+func searchSeries(candles []*techan.Candle, t time.Time) int {
+	// первая свеча, которая начинается позже t. Искомая - перед ней
+	idx := sort.Search(len(candles), func(i int) bool {
+		return candles[i].Period.Start.After(t)
+	}) - 1
+	if idx < 0 || !candleContains(candles[idx], t) {
+		return -1
+	}
+	return idx
+}
@@ -0,0 +1,272 @@
+package alex
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"github.com/sdcoffey/techan"
+)
+
+const benchSeriesLen = 500000
+
+// свечи, начинающиеся через minutes минут после benchSeriesStart
+func minuteCandles(minutes ...int) []*techan.Candle {
+	candles := make([]*techan.Candle, len(minutes))
+	for i, m := range minutes {
+		candles[i] = benchCandle(benchSeriesStart.Add(time.Duration(m) * time.Minute))
+	}
+	return candles
+}
+
+func at(minutes int, seconds int) time.Time {
+	return benchSeriesStart.Add(time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+}
+
+func TestFind(t *testing.T) {
+	// свечи 0, 1, 2, 5, 6 минут, между 3 и 5 минутой разрыв
+	series := techan.NewTimeSeries()
+	series.Candles = minuteCandles(0, 1, 2, 5, 6)
+	for _, tc := range []struct {
+		name string
+		t    time.Time
+		want int
+	}{
+		{"до первой", at(-1, 0), -1},
+		{"начало первой", at(0, 0), 0},
+		{"внутри свечи", at(1, 30), 1},
+		{"конец свечи - начало следующей", at(2, 0), 2},
+		{"в разрыве", at(3, 0), -1},
+		{"в конце разрыва", at(4, 59), -1},
+		{"после разрыва", at(5, 0), 3},
+		{"внутри последней", at(6, 59), 4},
+		{"конец последней", at(7, 0), -1},
+		{"после последней", at(60, 0), -1},
+	} {
+		if got := FindSeries(series, tc.t); got != tc.want {
+			t.Errorf("FindSeries %s: %d, ожидалось %d", tc.name, got, tc.want)
+		}
+		if got := findSeriesLinear(series, tc.t); got != tc.want {
+			t.Errorf("эталон %s: %d, ожидалось %d", tc.name, got, tc.want)
+		}
+		// IndexedSeries проверяю и на новом индексе, и после обращения к каждой свече, т.к. результат зависит от lastIdx
+		for last := -1; last < len(series.Candles); last++ {
+			s := WrapSeries(series)
+			if last >= 0 {
+				s.Find(series.Candles[last].Period.Start)
+			}
+			if got := s.Find(tc.t); got != tc.want {
+				t.Errorf("IndexedSeries %s после свечи %d: %d, ожидалось %d", tc.name, last, got, tc.want)
+			}
+		}
+	}
+	if FindSeries(nil, at(0, 0)) != -1 || (*IndexedSeries)(nil).Find(at(0, 0)) != -1 {
+		t.Error("в пустой серии свеча найдена")
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		series []int // начала свечей в минутах
+		upsert int
+		want   []int
+	}{
+		{"в пустую серию", nil, 3, []int{3}},
+		{"после последней", []int{0, 1}, 2, []int{0, 1, 2}},
+		{"после разрыва", []int{0, 1}, 5, []int{0, 1, 5}},
+		{"замена последней", []int{0, 1}, 1, []int{0, 1}},
+		{"замена в середине", []int{0, 1, 2}, 1, []int{0, 1, 2}},
+		{"вставка в разрыв", []int{0, 1, 5}, 3, []int{0, 1, 3, 5}},
+		{"вставка перед первой", []int{2, 3}, 0, []int{0, 2, 3}},
+	} {
+		s := WrapSeries(techan.NewTimeSeries())
+		s.Candles = minuteCandles(tc.series...)
+		candle := benchCandle(at(tc.upsert, 0))
+		s.Upsert(candle)
+		if len(s.Candles) != len(tc.want) {
+			t.Errorf("%s: %d свечей, ожидалось %d", tc.name, len(s.Candles), len(tc.want))
+			continue
+		}
+		for i, m := range tc.want {
+			if !s.Candles[i].Period.Start.Equal(at(m, 0)) {
+				t.Errorf("%s: свеча %d начинается в %s, ожидалось %s", tc.name, i, s.Candles[i].Period.Start, at(m, 0))
+			}
+		}
+		if idx := s.Find(at(tc.upsert, 0)); idx == -1 || s.Candles[idx] != candle {
+			t.Errorf("%s: добавленная свеча не найдена", tc.name)
+		}
+	}
+}
+
+// после вставки в середину запомненная позиция указывает на другую свечу
+func TestUpsertStaleLastIdx(t *testing.T) {
+	s := WrapSeries(techan.NewTimeSeries())
+	s.Candles = minuteCandles(0, 1, 5, 6)
+	if s.Find(at(5, 0)) != 2 {
+		t.Fatal("свеча 5 минуты не найдена")
+	}
+	s.Upsert(benchCandle(at(3, 0)))
+	for _, m := range []int{5, 6, 3, 0} {
+		if idx := s.Find(at(m, 30)); idx == -1 || !s.Candles[idx].Period.Start.Equal(at(m, 0)) {
+			t.Errorf("свеча %d минуты: индекс %d", m, idx)
+		}
+	}
+}
+
+// IndexedSeries и FindSeries совпадают с перебором на случайных обращениях
+func TestFindMatchesLinear(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	series := techan.NewTimeSeries()
+	for m := 0; m < 2000; m++ {
+		// серия с разрывами
+		if r.Intn(5) != 0 {
+			series.Candles = append(series.Candles, benchCandle(at(m, 0)))
+		}
+	}
+	s := WrapSeries(series)
+	for i := 0; i < 10000; i++ {
+		tm := at(r.Intn(2100)-50, r.Intn(60))
+		want := findSeriesLinear(series, tm)
+		if got := s.Find(tm); got != want {
+			t.Fatalf("IndexedSeries %s: %d, ожидалось %d", tm, got, want)
+		}
+		if got := FindSeries(series, tm); got != want {
+			t.Fatalf("FindSeries %s: %d, ожидалось %d", tm, got, want)
+		}
+	}
+}
+
+var benchSeriesStart = time.Date(2022, 1, 3, 0, 0, 0, 0, time.UTC)
+
+func benchCandle(start time.Time) *techan.Candle {
+	c := techan.NewCandle(techan.NewTimePeriod(start, time.Minute))
+	c.OpenPrice = big.NewFromInt(100)
+	c.ClosePrice = big.NewFromInt(100)
+	c.MaxPrice = big.NewFromInt(101)
+	c.MinPrice = big.NewFromInt(99)
+	c.Volume = big.NewFromInt(10)
+	return c
+}
+
+// минутная серия из benchSeriesLen свечей подряд
+func benchSeries() *techan.TimeSeries {
+	series := techan.NewTimeSeries()
+	series.Candles = make([]*techan.Candle, 0, benchSeriesLen)
+	for i := 0; i < benchSeriesLen; i++ {
+		series.Candles = append(series.Candles, benchCandle(benchSeriesStart.Add(time.Duration(i)*time.Minute)))
+	}
+	return series
+}
+
+// моменты внутри свечей серии в случайном порядке
+func benchRandomTimes() []time.Time {
+	r := rand.New(rand.NewSource(1))
+	times := make([]time.Time, 4096)
+	for i := range times {
+		times[i] = benchSeriesStart.Add(time.Duration(r.Intn(benchSeriesLen))*time.Minute + 30*time.Second)
+	}
+	return times
+}
+
+// обращения по порядку, как при тестировании на истории
+func BenchmarkIndexedSeriesFindSequential(b *testing.B) {
+	s := WrapSeries(benchSeries())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := benchSeriesStart.Add(time.Duration(i%benchSeriesLen) * time.Minute)
+		if s.Find(t) != i%benchSeriesLen {
+			b.Fatal("свеча не найдена", t)
+		}
+	}
+}
+
+func BenchmarkIndexedSeriesFindRandom(b *testing.B) {
+	s := WrapSeries(benchSeries())
+	times := benchRandomTimes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if s.Find(times[i%len(times)]) == -1 {
+			b.Fatal("свеча не найдена", times[i%len(times)])
+		}
+	}
+}
+
+// прежний FindSeries: перебор всех свечей. Для сравнения скорости и как эталон в тестах
+func findSeriesLinear(series *techan.TimeSeries, t time.Time) int {
+	for idx, c := range series.Candles {
+		if (t.After(c.Period.Start) && t.Before(c.Period.End)) || t.Equal(c.Period.Start) {
+			return idx
+		}
+	}
+	return -1
+}
+
+func BenchmarkFindSeriesLinear(b *testing.B) {
+	series := benchSeries()
+	times := benchRandomTimes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if findSeriesLinear(series, times[i%len(times)]) == -1 {
+			b.Fatal("свеча не найдена", times[i%len(times)])
+		}
+	}
+}
+
+func BenchmarkFindSeries(b *testing.B) {
+	series := benchSeries()
+	times := benchRandomTimes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if FindSeries(series, times[i%len(times)]) == -1 {
+			b.Fatal("свеча не найдена", times[i%len(times)])
+		}
+	}
+}
+
+// новые свечи после последней, как из потока в реальном времени
+func BenchmarkIndexedSeriesUpsertAppend(b *testing.B) {
+	s := WrapSeries(benchSeries())
+	candles := make([]*techan.Candle, b.N)
+	for i := range candles {
+		candles[i] = benchCandle(benchSeriesStart.Add(time.Duration(benchSeriesLen+i) * time.Minute))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Upsert(candles[i])
+	}
+	if len(s.Candles) != benchSeriesLen+b.N {
+		b.Fatal("неверная длина серии", len(s.Candles))
+	}
+}
+
+// обновление незакрытой последней свечи
+func BenchmarkIndexedSeriesUpsertLast(b *testing.B) {
+	s := WrapSeries(benchSeries())
+	candle := benchCandle(benchSeriesStart.Add((benchSeriesLen - 1) * time.Minute))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Upsert(candle)
+	}
+	if len(s.Candles) != benchSeriesLen {
+		b.Fatal("неверная длина серии", len(s.Candles))
+	}
+}
+
+// замена свечи в середине серии, например при докачке истории
+func BenchmarkIndexedSeriesUpsertReplace(b *testing.B) {
+	s := WrapSeries(benchSeries())
+	times := benchRandomTimes()
+	candles := make([]*techan.Candle, len(times))
+	for i, t := range times {
+		candles[i] = benchCandle(t.Truncate(time.Minute))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.Upsert(candles[i%len(candles)])
+	}
+	if len(s.Candles) != benchSeriesLen {
+		b.Fatal("неверная длина серии", len(s.Candles))
+	}
+}
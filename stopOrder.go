@@ -0,0 +1,90 @@
+package alex
+
+// Стоп-заявки: take-profit, stop-loss и stop-limit.
+// Стоп-заявка хранится у брокера, и при достижении ценой стоп-цены превращается в биржевую заявку:
+// take-profit и stop-loss - в рыночную, stop-limit - в лимитную по цене заявки
+
+import (
+	"time"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+	"github.com/sdcoffey/big"
+)
+
+type StopOrder struct {
+	StopOrderId    string                   // Идентификатор стоп-заявки
+	Figi           string                   // Figi-идентификатор инструмента
+	LotsRequested  int64                    // Запрошено лотов
+	Direction      proto.StopOrderDirection // Направление операции
+	Currency       string                   // Валюта стоп-заявки
+	OrderType      proto.StopOrderType      // Тип стоп-заявки
+	CreateDate     time.Time                // Дата и время выставления заявки
+	ActivationDate time.Time                // Дата и время конвертации стоп-заявки в биржевую. Нулевое время, если не сработала
+	ExpirationTime time.Time                // Дата и время снятия заявки. Нулевое время для заявок до отмены
+	Price          big.Decimal              // Цена биржевой заявки за 1 инструмент (для stop-limit)
+	StopPrice      big.Decimal              // Цена активации за 1 инструмент
+}
+
+func NewStopOrder(o *proto.StopOrder) *StopOrder {
+	stopOrder := &StopOrder{
+		StopOrderId:   o.StopOrderId,
+		Figi:          o.Figi,
+		LotsRequested: o.LotsRequested,
+		Direction:     o.Direction,
+		Currency:      o.Currency,
+		OrderType:     o.OrderType,
+		Price:         big.NaN,
+		StopPrice:     big.NaN,
+	}
+	if o.CreateDate != nil {
+		stopOrder.CreateDate = o.CreateDate.AsTime()
+	}
+	if o.ActivationDateTime != nil {
+		stopOrder.ActivationDate = o.ActivationDateTime.AsTime()
+	}
+	if o.ExpirationTime != nil {
+		stopOrder.ExpirationTime = o.ExpirationTime.AsTime()
+	}
+	if price := NewMoney(o.Price); price != nil {
+		stopOrder.Price = price.Value
+	}
+	if stopPrice := NewMoney(o.StopPrice); stopPrice != nil {
+		stopOrder.StopPrice = stopPrice.Value
+	}
+	return stopOrder
+}
+
+// сработала ли стоп-заявка при последней цене price.
+// take-profit на продажу срабатывает при росте цены до стоп-цены, а на покупку - при падении.
+// stop-loss и stop-limit - наоборот
+func (o *StopOrder) IsTriggered(price big.Decimal) bool {
+	up := o.Direction == proto.StopOrderDirection_STOP_ORDER_DIRECTION_SELL
+	if o.OrderType != proto.StopOrderType_STOP_ORDER_TYPE_TAKE_PROFIT {
+		up = !up
+	}
+	if up {
+		return price.GTE(o.StopPrice)
+	}
+	return price.LTE(o.StopPrice)
+}
+
+// истёк ли срок действия стоп-заявки к моменту now
+func (o *StopOrder) IsExpired(now time.Time) bool {
+	return !o.ExpirationTime.IsZero() && !now.Before(o.ExpirationTime)
+}
+
+// направление биржевой заявки, в которую превращается стоп-заявка
+func (o *StopOrder) GetOrderDirection() proto.OrderDirection {
+	if o.Direction == proto.StopOrderDirection_STOP_ORDER_DIRECTION_BUY {
+		return proto.OrderDirection_ORDER_DIRECTION_BUY
+	}
+	return proto.OrderDirection_ORDER_DIRECTION_SELL
+}
+
+// тип биржевой заявки, в которую превращается стоп-заявка
+func (o *StopOrder) GetOrderType() proto.OrderType {
+	if o.OrderType == proto.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT {
+		return proto.OrderType_ORDER_TYPE_LIMIT
+	}
+	return proto.OrderType_ORDER_TYPE_MARKET
+}
@@ -0,0 +1,63 @@
+package alex
+
+// Работа с часовыми поясами.
+// В хранилище время всегда пишется в UTC с явным указанием пояса (RFC3339), а пользователю показывается
+// и в аргументах командной строки задаётся в часовом поясе, который устанавливается через SetLocation.
+// По умолчанию это часовой пояс Московской биржи
+
+import (
+	"time"
+)
+
+const (
+	StorageTimeLayout       = time.RFC3339       // формат времени свечей в csv хранилище
+	legacyStorageTimeLayout = "2006-01-02 15:04" // прежний формат без часового пояса, время в нём в UTC
+	DisplayTimeLayout       = "2006-01-02 15:04" // формат времени для вывода пользователю
+)
+
+var location = MoscowLocation()
+
+// часовой пояс Московской биржи. Если в системе нет базы часовых поясов, то используется фиксированное смещение
+func MoscowLocation() *time.Location {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		return time.FixedZone("MSK", 3*60*60)
+	}
+	return moscow
+}
+
+// часовой пояс, в котором время показывается пользователю и задаётся в командной строке
+func Location() *time.Location {
+	return location
+}
+
+func SetLocation(loc *time.Location) {
+	location = loc
+}
+
+// время в часовом поясе Location() для вывода пользователю
+func FormatTime(t time.Time) string {
+	return t.In(location).Format(DisplayTimeLayout)
+}
+
+// то же время на часах, но в часовом поясе Location().
+// Нужно для времени, которое было разобрано без указания пояса и поэтому считается в UTC
+func WallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), location)
+}
+
+// разбирает время свечи из хранилища. Поддерживается и прежний формат без часового пояса
+func ParseStorageTime(s string) (time.Time, error) {
+	t, err := time.Parse(StorageTimeLayout, s)
+	if err != nil {
+		var legacyErr error
+		if t, legacyErr = time.Parse(legacyStorageTimeLayout, s); legacyErr != nil {
+			return time.Time{}, err
+		}
+	}
+	return t.UTC(), nil
+}
+
+func FormatStorageTime(t time.Time) string {
+	return t.UTC().Format(StorageTimeLayout)
+}
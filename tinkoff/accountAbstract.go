@@ -14,6 +14,9 @@ import (
 
 type Account interface {
 	alex.Account
+	GetOperations(ctx context.Context, from time.Time, to time.Time) ([]*Operation, error)
+	GetPortfolio(ctx context.Context) (*Portfolio, error)
+	GetOrderState(ctx context.Context, orderId string) (*FromGetOrder, error)
 }
 
 type AccountAbstract struct {
@@ -28,6 +31,7 @@ type AccountAbstract struct {
 	targetPositions *TargetPositions
 	engine          alex.AccountEngine
 	cache           *accountCache
+	submitter       *orderSubmitter // выставление заявок с повтором при временных ошибках
 }
 
 func NewAccountAbstract(client *Client, ad *proto.Account) *AccountAbstract {
@@ -83,11 +87,26 @@ func (a *AccountAbstract) CancelOrder(ctx context.Context, orderId string) (time
 	return a.engine.CancelOrder(ctx, orderId)
 }
 
+// уточняет у брокера исход заявок, выставление которых не подтвердилось до перезапуска программы.
+// Вызывается автоматически перед первой заявкой, но можно вызвать и раньше, при старте
+func (a *AccountAbstract) ResolvePendingOrders(ctx context.Context) error {
+	return a.submitter.resolvePending(ctx)
+}
+func (a *AccountAbstract) PostStopOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error) {
+	return a.engine.PostStopOrder(ctx, instrument, quantity, price, stopPrice, direction, stopOrderType, expireDate)
+}
+func (a *AccountAbstract) GetStopOrders(ctx context.Context) ([]*alex.StopOrder, error) {
+	return a.engine.GetStopOrders(ctx)
+}
+func (a *AccountAbstract) CancelStopOrder(ctx context.Context, stopOrderId string) (time.Time, error) {
+	return a.engine.CancelStopOrder(ctx, stopOrderId)
+}
+
 func AccountStringTableHead() string {
 	return "Id\tType\tName\tStatus\tOpenedDate\tClosedDate\tAccessLevel\t"
 }
 func (a *AccountAbstract) String() string {
-	closedDate := a.GetClosedDate().Format("2006-01-02")
+	closedDate := a.GetClosedDate().In(alex.Location()).Format("2006-01-02")
 	if closedDate == "1970-01-01" {
 		closedDate = ""
 	}
@@ -96,7 +115,7 @@ func (a *AccountAbstract) String() string {
 		strings.Replace(a.GetType().String(), "ACCOUNT_TYPE_", "", 1),
 		a.GetName(),
 		strings.Replace(a.GetStatus().String(), "ACCOUNT_STATUS_", "", 1),
-		a.GetOpenedDate().Format("2006-01-02"),
+		a.GetOpenedDate().In(alex.Location()).Format("2006-01-02"),
 		closedDate,
 		strings.Replace(a.GetAccessLevel().String(), "ACCOUNT_ACCESS_LEVEL_", "", 1),
 	)
@@ -62,7 +62,7 @@ func (ac *accountCache) GetPositions(ctx context.Context) (pp *alex.Positions, e
 
 		positions, err = ac.account.engine.GetPositions(ctx)
 		if err != nil {
-			l.DPanic("accountCache.GetPositions", zap.Error(err))
+			l.Error("accountCache.GetPositions", zap.Error(err))
 			return nil, err
 		}
 		ac.positions = positions
@@ -88,7 +88,7 @@ func (ac *accountCache) GetOrders(ctx context.Context) (oo []alex.Order, err err
 
 		orders, err = ac.account.engine.GetOrders(ctx)
 		if err != nil {
-			l.DPanic("accountCache.GetOrders", zap.Error(err))
+			l.Error("accountCache.GetOrders", zap.Error(err))
 			return nil, err
 		}
 		ac.orders = orders
@@ -101,7 +101,7 @@ func (ac *accountCache) GetOrders(ctx context.Context) (oo []alex.Order, err err
 func (ac *accountCache) GetBalance(ctx context.Context, i alex.Instrument) int64 {
 	positions, err := ac.GetPositions(ctx)
 	if err != nil {
-		l.DPanic("accountCache.Get", zap.Error(err))
+		l.Error("accountCache.Get", zap.Error(err))
 		return 0
 	}
 	position, ok := positions.Positions[i.GetFigi()]
@@ -114,7 +114,7 @@ func (ac *accountCache) GetBalance(ctx context.Context, i alex.Instrument) int64
 func (ac *accountCache) GetBlocked(ctx context.Context, i alex.Instrument) int64 {
 	positions, err := ac.GetPositions(ctx)
 	if err != nil {
-		l.DPanic("accountCache.Get", zap.Error(err))
+		l.Error("accountCache.Get", zap.Error(err))
 		return 0
 	}
 	position, ok := positions.Positions[i.GetFigi()]
@@ -10,10 +10,12 @@ import (
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 //статическая проверка, что тип RealAccount реализует интерфейс alex.Account
 var _ alex.Account = (*RealAccount)(nil)
+var _ Account = (*RealAccount)(nil)
 
 type RealAccount struct {
 	*AccountAbstract
@@ -24,16 +26,24 @@ func NewRealAccount(ctx context.Context, client *Client, ad *proto.Account) alex
 		AccountAbstract: NewAccountAbstract(client, ad),
 	}
 	this.engine = this
+	this.submitter = newOrderSubmitter(client, ad.GetId(), this)
 	l.Debug("подписываюсь на сделки по счёту", zap.String("account", ad.Id))
 	ch, err := client.orderTrades.Subscribe()
 	if err != nil {
-		l.DPanic("не смог подписаться на сделки по счёту", zap.Error(err))
+		l.Error("не смог подписаться на сделки по счёту", zap.Error(err))
+	} else {
+		go this.cache.ReadOrderTrades(ch)
 	}
-	go this.cache.ReadOrderTrades(ch)
 	return this
 }
 
+// выставляет заявку. orderId - ключ идемпотентности: при временных ошибках запрос повторяется с ним же
 func (ra *RealAccount) PostOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error) {
+	return ra.submitter.submit(ctx, instrument, quantity, price, direction, orderType, orderId)
+}
+
+// один запрос выставления заявки, без повторов
+func (ra *RealAccount) sendOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error) {
 	postOrderRequest := &proto.PostOrderRequest{
 		Figi:      instrument.GetFigi(),
 		Quantity:  quantity,
@@ -95,6 +105,19 @@ func (ra *RealAccount) GetOrders(ctx context.Context) ([]alex.Order, error) {
 	return orders, nil
 }
 
+//Метод получения статуса торгового поручения.
+func (ra *RealAccount) GetOrderState(ctx context.Context, orderId string) (*FromGetOrder, error) {
+	resp, err := ra.client.GetOrdersServiceClient().GetOrderState(ctx, &proto.GetOrderStateRequest{
+		AccountId: ra.id,
+		OrderId:   orderId,
+	})
+	if err != nil {
+		l.Error("GetOrderState", zap.Error(err), zap.String("orderId", orderId))
+		return nil, err
+	}
+	return NewOrderFromGet(ra, resp), nil
+}
+
 //Метод получения позиций по счёту.
 func (ra *RealAccount) GetPositions(ctx context.Context) (*alex.Positions, error) {
 	resp, err := ra.client.GetOperationsServiceClient().GetPositions(ctx, &proto.PositionsRequest{
@@ -122,3 +145,58 @@ func (ra *RealAccount) GetPositions(ctx context.Context) (*alex.Positions, error
 func (ra *RealAccount) GetEngineType() alex.EngineType {
 	return alex.EngineType_REAL
 }
+
+//Метод выставления стоп-заявки.
+func (ra *RealAccount) PostStopOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error) {
+	req := &proto.PostStopOrderRequest{
+		Figi:           instrument.GetFigi(),
+		Quantity:       quantity,
+		StopPrice:      alex.NewQuotation(stopPrice),
+		Direction:      direction,
+		AccountId:      ra.id,
+		ExpirationType: proto.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_CANCEL,
+		StopOrderType:  stopOrderType,
+	}
+	if !price.NaN() {
+		req.Price = alex.NewQuotation(price)
+	}
+	if !expireDate.IsZero() {
+		req.ExpirationType = proto.StopOrderExpirationType_STOP_ORDER_EXPIRATION_TYPE_GOOD_TILL_DATE
+		req.ExpireDate = timestamppb.New(expireDate)
+	}
+	resp, err := ra.client.GetStopOrdersServiceClient().PostStopOrder(ctx, req)
+	if err != nil {
+		l.Error("PostStopOrder", zap.Error(err), zap.Any("req", req))
+		return "", err
+	}
+	return resp.StopOrderId, nil
+}
+
+//Метод получения списка активных стоп заявок по счёту.
+func (ra *RealAccount) GetStopOrders(ctx context.Context) ([]*alex.StopOrder, error) {
+	resp, err := ra.client.GetStopOrdersServiceClient().GetStopOrders(ctx, &proto.GetStopOrdersRequest{
+		AccountId: ra.id,
+	})
+	if err != nil {
+		l.Error("GetStopOrders", zap.Error(err))
+		return nil, err
+	}
+	stopOrders := make([]*alex.StopOrder, len(resp.StopOrders))
+	for i, o := range resp.StopOrders {
+		stopOrders[i] = alex.NewStopOrder(o)
+	}
+	return stopOrders, nil
+}
+
+//Метод отмены стоп-заявки.
+func (ra *RealAccount) CancelStopOrder(ctx context.Context, stopOrderId string) (time.Time, error) {
+	resp, err := ra.client.GetStopOrdersServiceClient().CancelStopOrder(ctx, &proto.CancelStopOrderRequest{
+		AccountId:   ra.id,
+		StopOrderId: stopOrderId,
+	})
+	if err != nil {
+		l.Error("CancelStopOrder", zap.Error(err), zap.String("stopOrderId", stopOrderId))
+		return time.Time{}, err
+	}
+	return resp.Time.AsTime(), nil
+}
@@ -14,9 +14,11 @@ import (
 
 //статическая проверка, что тип SandboxAccount реализует интерфейс alex.Account
 var _ alex.Account = (*SandboxAccount)(nil)
+var _ Account = (*SandboxAccount)(nil)
 
 type SandboxAccount struct {
 	*AccountAbstract
+	stopOrders *stopOrdersEmulator // в песочнице нет стоп-заявок, поэтому они эмулируются
 }
 
 func NewSandboxAccount(c *Client, ad *proto.Account) alex.Account {
@@ -24,10 +26,18 @@ func NewSandboxAccount(c *Client, ad *proto.Account) alex.Account {
 		AccountAbstract: NewAccountAbstract(c, ad),
 	}
 	this.engine = this
+	this.submitter = newOrderSubmitter(c, ad.GetId(), this)
+	this.stopOrders = newStopOrdersEmulator(c, this)
 	return this
 }
 
+// выставляет заявку. orderId - ключ идемпотентности: при временных ошибках запрос повторяется с ним же
 func (sa *SandboxAccount) PostOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error) {
+	return sa.submitter.submit(ctx, instrument, quantity, price, direction, orderType, orderId)
+}
+
+// один запрос выставления заявки, без повторов
+func (sa *SandboxAccount) sendOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error) {
 	resp, err := sa.client.GetSandboxServiceClient().PostSandboxOrder(ctx, &proto.PostOrderRequest{
 		Figi:      instrument.GetFigi(),
 		Quantity:  quantity,
@@ -56,7 +66,7 @@ func (sa SandboxAccount) CancelOrder(ctx context.Context, orderId string) (time.
 		if status.Code(err) == codes.NotFound {
 			l.Debug("CancelSandboxOrder NotFound", zap.String("orderId", orderId))
 		} else {
-			l.DPanic("CancelSandboxOrder", zap.Error(err), zap.String("orderId", orderId))
+			l.Error("CancelSandboxOrder", zap.Error(err), zap.String("orderId", orderId))
 		}
 		return time.Time{}, err
 	}
@@ -79,6 +89,19 @@ func (sa *SandboxAccount) GetOrders(ctx context.Context) ([]alex.Order, error) {
 	return orders, nil
 }
 
+//Метод получения статуса заявки в песочнице.
+func (sa *SandboxAccount) GetOrderState(ctx context.Context, orderId string) (*FromGetOrder, error) {
+	resp, err := sa.client.GetSandboxServiceClient().GetSandboxOrderState(ctx, &proto.GetOrderStateRequest{
+		AccountId: sa.id,
+		OrderId:   orderId,
+	})
+	if err != nil {
+		l.Error("GetSandboxOrderState", zap.Error(err), zap.String("orderId", orderId))
+		return nil, err
+	}
+	return NewOrderFromGet(sa, resp), nil
+}
+
 //Метод получения позиций по виртуальному счёту песочницы.
 func (sa *SandboxAccount) GetPositions(ctx context.Context) (*alex.Positions, error) {
 	resp, err := sa.client.sandboxServiceClient.GetSandboxPositions(ctx, &proto.PositionsRequest{
@@ -94,3 +117,16 @@ func (sa *SandboxAccount) GetPositions(ctx context.Context) (*alex.Positions, er
 func (sa *SandboxAccount) GetEngineType() alex.EngineType {
 	return alex.EngineType_SANDBOX
 }
+
+//Стоп-заявка в песочнице. Эмулируется, пока работает программа.
+func (sa *SandboxAccount) PostStopOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error) {
+	return sa.stopOrders.post(instrument, quantity, price, stopPrice, direction, stopOrderType, expireDate)
+}
+
+func (sa *SandboxAccount) GetStopOrders(ctx context.Context) ([]*alex.StopOrder, error) {
+	return sa.stopOrders.list(), nil
+}
+
+func (sa *SandboxAccount) CancelStopOrder(ctx context.Context, stopOrderId string) (time.Time, error) {
+	return sa.stopOrders.cancel(stopOrderId)
+}
@@ -8,12 +8,9 @@ import (
 
 	"github.com/go-trading/alex"
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
-	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
 	"github.com/sdcoffey/big"
 	"go.uber.org/zap"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 var _ alex.TargetPosition = (*TargetPosition)(nil)
@@ -40,13 +37,17 @@ func (tpi *TargetPosition) GetError() error {
 }
 
 func (tpi *TargetPosition) IsLimitError() bool {
-	return IsLimitError(tpi)
+	return alex.IsLimitError(tpi.errs)
+}
+
+func (tpi *TargetPosition) Unwrap() error {
+	return tpi.errs
 }
 
 func (tp *TargetPosition) saveError(err error) {
 	tp.errs = multierror.Append(tp.errs, err)
 	if tp.IsLimitError() {
-		l.DPanic("Ошибка превышения лимитов. Останавливаю робота", zap.Error(err))
+		l.Error("Ошибка превышения лимитов. Останавливаю робота", zap.Error(err))
 		_ = tp.bot.Stop()
 	}
 }
@@ -108,6 +109,7 @@ func (a *AccountAbstract) DoPositionExtended(ctx context.Context, bot alex.Bot,
 			priceIncrement: priceIncrement,
 		}
 		a.targetPositions.target[instrument.GetFigi()] = tp
+		a.watchDataFresh(bot, instrument)
 	}
 	a.targetPositions.locker.Unlock()
 
@@ -115,6 +117,26 @@ func (a *AccountAbstract) DoPositionExtended(ctx context.Context, bot alex.Bot,
 	return tp
 }
 
+// при изменении актуальности рыночных данных пересматриваю заявки, не дожидаясь изменения балансов
+func (a *AccountAbstract) watchDataFresh(bot alex.Bot, instrument alex.Instrument) {
+	ch, err := instrument.SubscribeDataFresh()
+	if err != nil {
+		l.Error("не смог подписаться на актуальность данных", zap.String("figi", instrument.GetFigi()), zap.Error(err))
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-ch:
+				a.doTracking(bot.Context())
+			case <-bot.Context().Done():
+				instrument.UnsubscribeDataFresh(ch) //nolint:golint,errcheck
+				return
+			}
+		}
+	}()
+}
+
 func (a *AccountAbstract) doTracking(ctx context.Context) {
 	//скорее всего пришёл из invalidateCache, которую сам же и вызвал. Нечего страшного если пропущу один вызов
 	if !a.targetPositions.locker.TryLock() {
@@ -124,12 +146,14 @@ func (a *AccountAbstract) doTracking(ctx context.Context) {
 
 	positions, err := a.GetPositions(ctx)
 	if err != nil {
-		l.DPanic("не удалось получить текущие позиции", zap.Error(err))
+		l.Error("не удалось получить текущие позиции", zap.Error(err))
+		return
 	}
 
 	orders, err := a.GetOrders(ctx)
 	if err != nil {
-		l.DPanic("не удалось получить текущие позиции", zap.Error(err))
+		l.Error("не удалось получить текущие заявки", zap.Error(err))
+		return
 	}
 	lotsInOrders := CalcLotsInOrders(orders)
 	l.Debug("AccountAbstractImpl.doTracking",
@@ -148,6 +172,18 @@ func (a *AccountAbstract) doTracking(ctx context.Context) {
 		if ok && positionFromAPI != nil {
 			positionLots = (positionFromAPI.GetBalance() + positionFromAPI.GetBlocked()) / int64(instrument.GetLot())
 		}
+		if !instrument.IsDataFresh() {
+			// цены заявок рассчитаны по устаревшим данным, поэтому снимаю их, и новые не выставляю
+			for _, o := range orders {
+				if o.GetFigi() == figi && o.IsActive() {
+					targetPosition.setStabilizationTime()
+					if _, err = o.Cancel(targetPosition.bot.Context()); err != nil {
+						l.Debug("не смог отменить заявку по инструменту с устаревшими данными", zap.Error(err))
+					}
+				}
+			}
+			continue
+		}
 		if lotsInOrders[figi] == 0 &&
 			positionLots == targetPosition.quantityLots {
 			//с данным инструментом всё нормально, переходим к следующему
@@ -208,8 +244,8 @@ func (a *AccountAbstract) PostOrderWithBestPrice(ctx context.Context, instrument
 
 	ob, err := instrument.GetOrderBook(ctx, 1)
 	if err != nil {
-		l.DPanic("GetOrderBook", zap.Error(err))
-		return nil, nil
+		l.Error("GetOrderBook", zap.Error(err))
+		return nil, err
 	}
 
 	bestBid := big.NaN
@@ -240,7 +276,8 @@ func (a *AccountAbstract) PostOrderWithBestPrice(ctx context.Context, instrument
 		price,
 		direction,
 		proto.OrderType_ORDER_TYPE_LIMIT,
-		uuid.New().String(),
+		// если прошлая заявка с теми же параметрами не подтвердилась, то повторяю её с тем же ключом, чтобы не задвоить
+		a.submitter.orderIdFor(instrument.GetFigi(), direction, quantity),
 	)
 }
 
@@ -257,31 +294,3 @@ func CalcLotsInOrders(orders []alex.Order) map[string]int64 {
 	}
 	return lotsInOrders
 }
-
-//TODO надо вводить свой класс ошибок
-func IsLimitError(e error) bool {
-	switch err := e.(type) {
-	case *TargetPosition:
-		return IsLimitError(err.errs)
-	case *multierror.Error:
-		for _, subError := range err.Errors {
-			if IsLimitError(subError) {
-				return true
-			}
-		}
-		return false
-	default:
-		if se, ok := e.(interface {
-			GRPCStatus() *status.Status
-		}); ok {
-			l.Error("se.GRPCStatus()",
-				zap.Any("GRPCStatus", se.GRPCStatus()),
-				zap.String("Code", se.GRPCStatus().Code().String()),
-				zap.Any("Message", se.GRPCStatus().Message()),
-			)
-			return se.GRPCStatus().Code() == codes.InvalidArgument &&
-				se.GRPCStatus().Message() == "30042"
-		}
-		return false
-	}
-}
@@ -2,6 +2,7 @@ package tinkoff
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/go-trading/alex"
@@ -30,7 +31,7 @@ func (aa *Accounts) GetRealAccounts(ctx context.Context) (map[string]alex.Accoun
 
 	accountsResponceReal, err := aa.client.GetUsersServiceClient().GetAccounts(ctx, &proto.GetAccountsRequest{})
 	if err != nil {
-		l.DPanic("UsersService/GetAccounts", zap.Error(err))
+		l.Error("UsersService/GetAccounts", zap.Error(err))
 		return nil, err
 
 	}
@@ -45,7 +46,7 @@ func (aa *Accounts) GetRealAccounts(ctx context.Context) (map[string]alex.Accoun
 
 	err = aa.client.InitOrderStream(aa.GetRealAccountsStrings())
 	if err != nil {
-		l.DPanic("initOrderStream", zap.Error(err))
+		l.Error("initOrderStream", zap.Error(err))
 		return nil, err
 	}
 
@@ -65,7 +66,7 @@ func (aa *Accounts) GetSandboxAccounts(ctx context.Context) (map[string]alex.Acc
 
 	accountsResponceSandbox, err := aa.client.GetSandboxServiceClient().GetSandboxAccounts(ctx, &proto.GetAccountsRequest{})
 	if err != nil {
-		l.DPanic("SandboxService/GetSandboxAccounts", zap.Error(err))
+		l.Error("SandboxService/GetSandboxAccounts", zap.Error(err))
 		return nil, err
 	}
 
@@ -91,37 +92,54 @@ func (aa *Accounts) tryGet(accountId string) (alex.Account, bool) {
 	return nil, false
 }
 
-func (aa *Accounts) GetOrDie(ctx context.Context, accountId string) alex.Account {
+// ищет счёт среди счетов песочницы и боевых счетов. Если счёт не найден, возвращается ошибка alex.ErrNotFound
+func (aa *Accounts) Get(ctx context.Context, accountId string) (alex.Account, error) {
 	aa.locker.RLock()
 	a, ok := aa.tryGet(accountId)
 	aa.locker.RUnlock()
 	if ok {
-		return a
+		return a, nil
+	}
+
+	// без песочницы счёт ещё может найтись среди боевых, поэтому ошибка возвращается, только если не нашёлся нигде
+	_, sandboxErr := aa.GetSandboxAccounts(ctx)
+	if sandboxErr != nil {
+		l.Error("не смог получить счета песочницы", zap.Error(sandboxErr))
+	} else {
+		aa.locker.RLock()
+		a, ok = aa.tryGet(accountId)
+		aa.locker.RUnlock()
+		if ok {
+			return a, nil
+		}
 	}
 
-	_, err := aa.GetSandboxAccounts(ctx)
+	_, err := aa.GetRealAccounts(ctx)
 	if err != nil {
-		l.DPanic("не смог получить счета песочницы", zap.Error(err))
+		l.Error("не смог получить боевые счета", zap.Error(err))
+		if sandboxErr != nil {
+			return nil, sandboxErr
+		}
+		return nil, err
 	}
 
 	aa.locker.RLock()
 	a, ok = aa.tryGet(accountId)
 	aa.locker.RUnlock()
 	if ok {
-		return a
+		return a, nil
 	}
-
-	_, err = aa.GetRealAccounts(ctx)
-	if err != nil {
-		l.DPanic("не смог получить боевые счета", zap.Error(err))
+	if sandboxErr != nil {
+		return nil, sandboxErr
 	}
+	return nil, fmt.Errorf("счёт %s: %w", accountId, alex.ErrNotFound)
+}
 
-	aa.locker.RLock()
-	a, ok = aa.tryGet(accountId)
-	aa.locker.RUnlock()
-	if ok {
-		return a
+// то же, что Get, но завершает программу, если счёт не найден. Для утилит командной строки
+func (aa *Accounts) GetOrDie(ctx context.Context, accountId string) alex.Account {
+	a, err := aa.Get(ctx, accountId)
+	if err != nil {
+		l.Fatal("Account не найден", zap.String("accountId", accountId), zap.Error(err))
 	}
-	l.Fatal("Account не найден", zap.String("accountId", accountId))
-	return nil
+	return a
 }
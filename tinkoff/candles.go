@@ -19,7 +19,8 @@ type Candles struct {
 	client          *Client
 	Figi            string
 	Period          time.Duration
-	Series          *techan.TimeSeries
+	Series          *alex.IndexedSeries
+	seriesLock      sync.RWMutex // Series пополняется из потока и докачкой после переподключения
 	subscribersLock sync.RWMutex
 	incomingChannel alex.CandleChan
 	subscribers     []alex.CandleChan
@@ -35,9 +36,9 @@ func NewCandles(client *Client, figi string, period time.Duration) *Candles {
 			zap.String("figi", figi),
 			zap.Duration("period", period),
 		),
-		Series: techan.NewTimeSeries(),
+		Series: alex.NewIndexedSeries(),
 	}
-	cs.incomingChannel = client.SubscribeCandles(cs)
+	cs.incomingChannel = client.RegisterCandles(cs)
 	go cs.incomingCandleRecv()
 
 	return cs
@@ -45,23 +46,30 @@ func NewCandles(client *Client, figi string, period time.Duration) *Candles {
 
 func (cs *Candles) MergeApiCandles(apiCandles []*proto.HistoricCandle) {
 	for _, c := range apiCandles {
-		cs.Upsert(&techan.Candle{
-			Period:     techan.NewTimePeriod(c.Time.AsTime(), cs.Period),
-			OpenPrice:  alex.NewDecimal(c.Open),
-			ClosePrice: alex.NewDecimal(c.Close),
-			MaxPrice:   alex.NewDecimal(c.High),
-			MinPrice:   alex.NewDecimal(c.Low),
-			Volume:     big.NewFromInt(int(c.Volume)),
-		})
+		cs.Upsert(cs.newCandle(c))
+	}
+}
+
+func (cs *Candles) newCandle(c *proto.HistoricCandle) *techan.Candle {
+	return &techan.Candle{
+		Period:     techan.NewTimePeriod(c.Time.AsTime(), cs.Period),
+		OpenPrice:  alex.NewDecimal(c.Open),
+		ClosePrice: alex.NewDecimal(c.Close),
+		MaxPrice:   alex.NewDecimal(c.High),
+		MinPrice:   alex.NewDecimal(c.Low),
+		Volume:     big.NewFromInt(int(c.Volume)),
 	}
 }
 
 func (cs *Candles) LoadFromData() error {
-	series, err := alex.LoadTimeSeries(cs.client.dataDir, cs.Figi, cs.Period)
+	series, err := cs.client.GetCandleStore().Load(cs.Figi, cs.Period)
+	if err != nil {
+		return err
+	}
 	for _, candle := range series.Candles {
 		cs.Upsert(candle)
 	}
-	return err
+	return nil
 }
 
 func (cs *Candles) Upsert(newCandle *techan.Candle) {
@@ -70,18 +78,29 @@ func (cs *Candles) Upsert(newCandle *techan.Candle) {
 		return
 	}
 
-	alex.UpsertSeries(cs.Series, newCandle)
+	cs.seriesLock.Lock()
+	defer cs.seriesLock.Unlock()
+	cs.Series.Upsert(newCandle)
+}
+
+// последняя свеча серии, nil - если серия пустая
+func (cs *Candles) LastCandle() *techan.Candle {
+	cs.seriesLock.RLock()
+	defer cs.seriesLock.RUnlock()
+	return cs.Series.LastCandle()
 }
 
 func (cs *Candles) Save() error {
-	return alex.SaveTimeSeries(cs.client.dataDir, cs.Figi, cs.Period, cs.Series)
+	cs.seriesLock.RLock()
+	defer cs.seriesLock.RUnlock()
+	return cs.client.GetCandleStore().Save(cs.Figi, cs.Period, cs.Series.TimeSeries)
 }
 
 func (cs *Candles) GetPeriod() time.Duration {
 	return cs.Period
 }
 func (cs *Candles) GetSeries() *techan.TimeSeries {
-	return cs.Series
+	return cs.Series.TimeSeries
 }
 func (cs *Candles) GetFigi() string {
 	return cs.Figi
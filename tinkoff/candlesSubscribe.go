@@ -2,10 +2,20 @@ package tinkoff
 
 import "github.com/go-trading/alex"
 
+func (cs *Candles) subscriptionKey() SubscriptionKey {
+	return CandlesSubscription(cs.Figi, alex.Duration2SubscriptionInterval(cs.Period))
+}
+
+// подписаться на свечи. На сервере подписка оформляется при появлении первого подписчика
 func (cs *Candles) Subscribe() (candleChan alex.CandleChan, err error) {
 	cs.subscribersLock.Lock()
 	defer cs.subscribersLock.Unlock()
 
+	if len(cs.subscribers) == 0 {
+		if err := cs.client.GetMarketDataStream().Subscriptions.Subscribe(cs.subscriptionKey()); err != nil {
+			return nil, err
+		}
+	}
 	candleChan = make(alex.CandleChan, 50)
 	cs.subscribers = append(cs.subscribers, candleChan)
 	return candleChan, nil
@@ -16,17 +26,16 @@ func (cs *Candles) Unsubscribe(candleChan alex.CandleChan) (err error) {
 	defer cs.subscribersLock.Unlock()
 
 	//удаляю подписчика
-	if cs.RemoveSubscriber(candleChan) {
+	if !cs.RemoveSubscriber(candleChan) {
 		cs.l.DPanic("отписываюсь от свеч, хотя не подписывался на них")
+		return nil
 	}
 
 	// если подписчики ещё остались, то отписываться на сервере не надо
 	if len(cs.subscribers) > 0 {
 		return nil
 	}
-	return nil
-	// если подписчиков не осталось, то можно отписаться от потока с сервера, но тогда надо думать, как синхронизировать, если через час снова подпишится
-	//return cs.sendSubscribeRequest(proto.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE)
+	return cs.client.GetMarketDataStream().Subscriptions.Unsubscribe(cs.subscriptionKey())
 }
 
 func (cs *Candles) RemoveSubscriber(candleChan alex.CandleChan) bool {
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -13,6 +14,7 @@ import (
 	"golang.org/x/oauth2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/credentials/oauth"
 	"google.golang.org/grpc/metadata"
 
@@ -25,9 +27,12 @@ var _ alex.Client = (*Client)(nil)
 type Client struct {
 	ctx                       context.Context
 	endpoint                  string
+	token                     string
+	plaintext                 bool // соединение без TLS, например с локальным fake сервером
 	grpcOpts                  []grpc.DialOption
 	conn                      *grpc.ClientConn
 	dataDir                   string
+	candleStore               alex.CandleStore
 	marketDataServiceClient   proto.MarketDataServiceClient
 	usersServiceClient        proto.UsersServiceClient
 	sandboxServiceClient      proto.SandboxServiceClient
@@ -35,27 +40,32 @@ type Client struct {
 	operationsServiceClient   proto.OperationsServiceClient
 	ordersStreamServiceClient proto.OrdersStreamServiceClient
 	ordersServiceClient       proto.OrdersServiceClient
+	stopOrdersServiceClient   proto.StopOrdersServiceClient
 	//TODO add lock
 	dataStreamMarket *MarketDataStream
 	Instruments      *Instruments
 	Accounts         *Accounts
+	Schedules        *Schedules
 	limit            *Limits
 	orderTrades      *OrderTrades
+	trafficRecorder  *TrafficRecorder
 }
 
 func NewClient(endpoint string, token string, dataDir string) *Client {
 	client := &Client{
-		endpoint: endpoint,
-		dataDir:  dataDir,
-		limit:    &Limits{},
+		endpoint:    endpoint,
+		token:       token,
+		dataDir:     dataDir,
+		candleStore: alex.NewCSVCandleStore(dataDir),
+		limit:       &Limits{},
 	}
 	client.grpcOpts = []grpc.DialOption{
-		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
-		grpc.WithPerRPCCredentials(oauth.NewOauthAccess(&oauth2.Token{
-			AccessToken: token,
-		})),
-		grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(
+			withAPIErrorsStream,
+			grpc_prometheus.StreamClientInterceptor,
+		)),
 		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(
+			withAPIErrors,
 			client.withAppName,
 			client.limit.withLimit,
 			grpc_prometheus.UnaryClientInterceptor,
@@ -63,14 +73,63 @@ func NewClient(endpoint string, token string, dataDir string) *Client {
 	}
 	client.Instruments = NewInstruments(client)
 	client.Accounts = NewAccounts(client)
+	client.Schedules = NewSchedules(client)
 	client.orderTrades = NewOrderTrades(client)
 
 	return client
 }
 
+// соединяться без TLS, например с локальным fake сервером (alex fake-server). Вызывается до Open
+func (c *Client) SetPlaintext(plaintext bool) {
+	c.plaintext = plaintext
+}
+
+// дополнительные опции соединения, например dialer для bufconn или перехватчики запросов
+// (grpc.WithChainUnaryInterceptor, grpc.WithChainStreamInterceptor). Вызывается до Open
+func (c *Client) AddDialOptions(opts ...grpc.DialOption) {
+	c.grpcOpts = append(c.grpcOpts, opts...)
+}
+
+// записывать весь обмен с api в файл fileName, см. TrafficRecorder. Вызывается до Open
+func (c *Client) RecordTraffic(fileName string) error {
+	r, err := NewTrafficRecorder(fileName)
+	if err != nil {
+		return err
+	}
+	c.trafficRecorder = r
+	c.AddDialOptions(r.DialOptions()...)
+	return nil
+}
+
+// брать ответы api из записи fileName вместо сервера, см. TrafficReplayer. Вызывается до Open
+func (c *Client) ReplayTraffic(fileName string, realtime bool) error {
+	p, err := NewTrafficReplayer(fileName, realtime)
+	if err != nil {
+		return err
+	}
+	c.SetPlaintext(true)
+	c.AddDialOptions(p.DialOptions()...)
+	return nil
+}
+
+func (c *Client) credentials() []grpc.DialOption {
+	if c.plaintext {
+		return []grpc.DialOption{
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithPerRPCCredentials(plaintextToken(c.token)),
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})),
+		grpc.WithPerRPCCredentials(oauth.NewOauthAccess(&oauth2.Token{
+			AccessToken: c.token,
+		})),
+	}
+}
+
 func (c *Client) Open(ctx context.Context) (err error) {
 	c.ctx = ctx
-	c.conn, err = grpc.Dial(c.endpoint, c.grpcOpts...)
+	c.conn, err = grpc.Dial(c.endpoint, append(c.credentials(), c.grpcOpts...)...)
 	if err != nil {
 		return err
 	}
@@ -82,22 +141,30 @@ func (c *Client) Open(ctx context.Context) (err error) {
 	c.operationsServiceClient = proto.NewOperationsServiceClient(c.conn)
 	c.ordersStreamServiceClient = proto.NewOrdersStreamServiceClient(c.conn)
 	c.ordersServiceClient = proto.NewOrdersServiceClient(c.conn)
+	c.stopOrdersServiceClient = proto.NewStopOrdersServiceClient(c.conn)
 
 	c.dataStreamMarket = NewMarketDataStream(c)
 
 	err = c.limit.Load(ctx, c.conn)
 	if err != nil {
-		l.DPanic("limit.Load", zap.Error(err))
+		l.Error("limit.Load", zap.Error(err))
+		c.Close() //nolint:golint,errcheck
+		return err
 	}
 	err = c.Instruments.LoadNew(ctx)
 	if err != nil {
-		l.DPanic("Instruments.LoadNew", zap.Error(err))
+		l.Error("Instruments.LoadNew", zap.Error(err))
+		c.Close() //nolint:golint,errcheck
+		return err
 	}
 	err = c.dataStreamMarket.open()
 	if err != nil {
-		l.DPanic("openMarketDataStream", zap.Error(err))
+		l.Error("openMarketDataStream", zap.Error(err))
+		c.Close() //nolint:golint,errcheck
+		return err
 	}
-	return err
+	go c.watchStreams(ctx)
+	return nil
 }
 
 func (c *Client) InitOrderStream(accounts []string) (err error) {
@@ -108,9 +175,9 @@ func (c *Client) InitOrderStream(accounts []string) (err error) {
 	c.orderTrades.SetAccounts(accounts)
 	err = c.orderTrades.open()
 	if err != nil {
-		l.DPanic("c.OrderTrades.openOrderTradesStream", zap.Error(err))
+		l.Error("c.OrderTrades.openOrderTradesStream", zap.Error(err))
 	}
-	return nil
+	return err
 }
 
 func (c *Client) Close() error {
@@ -121,7 +188,14 @@ func (c *Client) Close() error {
 	c.instrumentsServiceClient = nil
 	c.ordersStreamServiceClient = nil
 	c.ordersServiceClient = nil
-	return c.conn.Close()
+	c.stopOrdersServiceClient = nil
+	err := c.conn.Close()
+	if c.trafficRecorder != nil {
+		if rerr := c.trafficRecorder.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
 }
 
 func (c *Client) Etfs(ctx context.Context, status proto.InstrumentStatus) ([]*proto.Etf, error) {
@@ -146,6 +220,39 @@ func (c *Client) Shares(ctx context.Context, status proto.InstrumentStatus) ([]*
 	return sharesResponse.Instruments, nil
 }
 
+func (c *Client) Bonds(ctx context.Context, status proto.InstrumentStatus) ([]*proto.Bond, error) {
+	l.Debug("запрашиваю все bonds")
+	bondsResponse, err := c.instrumentsServiceClient.Bonds(ctx, &proto.InstrumentsRequest{
+		InstrumentStatus: status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bondsResponse.Instruments, nil
+}
+
+func (c *Client) Futures(ctx context.Context, status proto.InstrumentStatus) ([]*proto.Future, error) {
+	l.Debug("запрашиваю все futures")
+	futuresResponse, err := c.instrumentsServiceClient.Futures(ctx, &proto.InstrumentsRequest{
+		InstrumentStatus: status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return futuresResponse.Instruments, nil
+}
+
+func (c *Client) Currencies(ctx context.Context, status proto.InstrumentStatus) ([]*proto.Currency, error) {
+	l.Debug("запрашиваю все currencies")
+	currenciesResponse, err := c.instrumentsServiceClient.Currencies(ctx, &proto.InstrumentsRequest{
+		InstrumentStatus: status,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return currenciesResponse.Instruments, nil
+}
+
 func (c *Client) withAppName(ctx context.Context,
 	method string,
 	req interface{},
@@ -158,9 +265,68 @@ func (c *Client) withAppName(ctx context.Context,
 	return invoker(appName, method, req, reply, cc, opts...)
 }
 
+// превращает ошибки api в alex.APIError. Описание ошибки сервер присылает в метаданных ответа
+func withAPIErrors(ctx context.Context,
+	method string,
+	req interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	var trailer metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Trailer(&trailer))...)
+	return alex.NewAPIError(err, firstValue(trailer, "message"))
+}
+
+func withAPIErrorsStream(ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return nil, alex.NewAPIError(err, "")
+	}
+	return &apiErrorsStream{ClientStream: stream}, nil
+}
+
+type apiErrorsStream struct {
+	grpc.ClientStream
+}
+
+func (s *apiErrorsStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return alex.NewAPIError(err, firstValue(s.Trailer(), "message"))
+}
+
+// токен для соединения без TLS. oauth.NewOauthAccess требует TLS, поэтому токен передаётся так же, но без этого требования
+type plaintextToken string
+
+func (t plaintextToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t plaintextToken) RequireTransportSecurity() bool { return false }
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
 func (c *Client) GetOrdersServiceClient() proto.OrdersServiceClient {
 	return c.ordersServiceClient
 }
+func (c *Client) GetStopOrdersServiceClient() proto.StopOrdersServiceClient {
+	return c.stopOrdersServiceClient
+}
 func (c *Client) GetSandboxServiceClient() proto.SandboxServiceClient {
 	return c.sandboxServiceClient
 }
@@ -170,20 +336,36 @@ func (c *Client) GetOperationsServiceClient() proto.OperationsServiceClient {
 func (c *Client) GetUsersServiceClient() proto.UsersServiceClient {
 	return c.usersServiceClient
 }
+// инструмент с информацией, специфичной для его типа, или nil, если инструмент не найден
 func (c *Client) GetInstrument(figi string) alex.Instrument {
-	return c.Instruments.Get(figi)
+	i := c.Instruments.Get(figi)
+	if i == nil {
+		return nil
+	}
+	return i.Typed()
 }
 func (c *Client) GetDataDir() string {
 	return c.dataDir
 }
+
+// хранилище, в которое сохраняются и из которого загружаются исторические свечи
+func (c *Client) GetCandleStore() alex.CandleStore {
+	return c.candleStore
+}
+func (c *Client) SetCandleStore(store alex.CandleStore) {
+	c.candleStore = store
+}
 func (c *Client) GetOrdersStreamServiceClient() proto.OrdersStreamServiceClient {
 	return c.ordersStreamServiceClient
 }
 func (c *Client) GetMarketDataServiceClient() proto.MarketDataServiceClient {
 	return c.marketDataServiceClient
 }
-func (c *Client) SubscribeCandles(cs *Candles) alex.CandleChan {
-	return c.dataStreamMarket.Subscribe(cs)
+func (c *Client) RegisterCandles(cs *Candles) alex.CandleChan {
+	return c.dataStreamMarket.RegisterCandles(cs)
+}
+func (c *Client) GetMarketDataStream() *MarketDataStream {
+	return c.dataStreamMarket
 }
 func (c *Client) Now() time.Time {
 	return time.Now()
@@ -15,60 +15,117 @@ import (
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 )
 
+const marketDataStreamMethod = "/tinkoff.public.invest.api.contract.v1.MarketDataStreamService/MarketDataStream"
+
+// канал, в который передаются все сообщения потока рыночных данных без обработки
+type MarketDataChan chan *proto.MarketDataResponse
+
 type MarketDataStream struct {
 	client                        *Client
 	marketDataStreamServiceClient proto.MarketDataStreamServiceClient
 	marketDataStreamClient        proto.MarketDataStreamService_MarketDataStreamClient
 	locker                        sync.RWMutex
-	subscribers                   map[alex.Candles]alex.CandleChan
+	subscribers                   map[alex.Candles]alex.CandleChan // куда передавать свечи из потока
+	rawSubscribers                []MarketDataChan
+	Subscriptions                 *Subscriptions
+	liveness                      *streamLiveness
 }
 
 func NewMarketDataStream(client *Client) *MarketDataStream {
-	return &MarketDataStream{
+	s := &MarketDataStream{
 		client:                        client,
 		marketDataStreamServiceClient: proto.NewMarketDataStreamServiceClient(client.conn),
 		subscribers:                   make(map[alex.Candles]alex.CandleChan),
+		liveness:                      newStreamLiveness("MarketDataStream"),
 	}
+	s.Subscriptions = NewSubscriptions(s)
+	return s
 }
 
 func (s *MarketDataStream) open() (err error) {
 	l.Debug("MarketDataStream.Open")
-	s.marketDataStreamClient, err = s.marketDataStreamServiceClient.MarketDataStream(s.client.ctx)
+	if s.client.limit.StreamLimit(marketDataStreamMethod) == 0 {
+		return errors.New("тариф не позволяет открыть поток рыночных данных")
+	}
+	// предыдущий поток заменяется и закрывается, его streamReader не должен переподключаться
+	s.locker.Lock()
+	s.marketDataStreamClient = nil
+	s.locker.Unlock()
+	s.dropLiveData()
+	stream, err := s.marketDataStreamServiceClient.MarketDataStream(s.liveness.newContext(s.client.ctx))
 	if err != nil {
 		l.Error("MarketDataStream", zap.Error(err))
 		return err
 	}
-	go s.streamReader()
+	s.locker.Lock()
+	s.marketDataStreamClient = stream
+	s.locker.Unlock()
+	go s.streamReader(stream)
 	return nil
 }
 
+// переподключение с восстановлением подписок и докачкой свечей, пропущенных за время разрыва
 func (s *MarketDataStream) reconnect() {
-	sleepTime := time.Second
-	for {
-		time.Sleep(sleepTime)
-		sleepTime = 2 * sleepTime
-		err := s.open()
-		if err != nil {
-			l.Error("MarketDataStream reconnect open", zap.Error(err))
-			continue
-		}
-		var instruments []*proto.CandleInstrument
-		for c := range s.subscribers {
-			instruments = append(instruments, &proto.CandleInstrument{
-				Figi:     c.GetFigi(),
-				Interval: alex.Duration2SubscriptionInterval(c.GetPeriod()),
-			})
+	s.dropLiveData()
+	err := reconnectLoop(s.client.ctx, "MarketDataStream", func() error {
+		if err := s.open(); err != nil {
+			return err
 		}
-		err = s.sendSubscribeRequest(proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, instruments...)
-		if err != nil {
-			l.Error("MarketDataStream reconnect sendSubscribeRequest", zap.Error(err))
+		return s.Subscriptions.Resubscribe()
+	})
+	if err != nil {
+		return
+	}
+	s.backfill()
+}
+
+// данные прежнего потока устарели
+func (s *MarketDataStream) dropLiveData() {
+	for _, i := range s.client.Instruments.all() {
+		i.dropLiveData()
+	}
+}
+
+// докачивает свечи по всем подпискам на свечи
+func (s *MarketDataStream) backfill() {
+	s.locker.RLock()
+	candles := make([]*Candles, 0, len(s.subscribers))
+	for c := range s.subscribers {
+		candles = append(candles, c.(*Candles))
+	}
+	s.locker.RUnlock()
+	for _, c := range candles {
+		if !s.Subscriptions.Has(c.subscriptionKey()) {
 			continue
 		}
-		break
+		if err := c.Backfill(s.client.ctx); err != nil {
+			l.Error("не удалось докачать свечи", zap.String("figi", c.Figi), zap.Duration("period", c.Period), zap.Error(err))
+		}
 	}
 }
 
-func (s *MarketDataStream) Subscribe(candles *Candles) alex.CandleChan {
+// отправить запрос в поток. Вызывается только из Subscriptions, под её блокировкой,
+// поэтому одновременных вызовов Send не бывает
+func (s *MarketDataStream) send(request *proto.MarketDataRequest) error {
+	s.locker.RLock()
+	stream := s.marketDataStreamClient
+	s.locker.RUnlock()
+	if stream == nil {
+		return errors.New("поток рыночных данных не открыт")
+	}
+	return stream.Send(request)
+}
+
+// текущий ли поток. Поток, заменённый при переподключении, закрывается, но переподключать его не надо
+func (s *MarketDataStream) isCurrent(stream proto.MarketDataStreamService_MarketDataStreamClient) bool {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	return s.marketDataStreamClient == stream
+}
+
+// зарегистрировать свечи, в которые будут передаваться свечи из потока.
+// Подписка на сервере оформляется отдельно, через Subscriptions
+func (s *MarketDataStream) RegisterCandles(candles *Candles) alex.CandleChan {
 	s.locker.Lock()
 	defer s.locker.Unlock()
 	ch, ok := s.subscribers[candles]
@@ -77,88 +134,67 @@ func (s *MarketDataStream) Subscribe(candles *Candles) alex.CandleChan {
 	}
 	ch = make(alex.CandleChan, 10)
 	s.subscribers[candles] = ch
-	err := s.sendSubscribeRequest(proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE,
-		&proto.CandleInstrument{
-			Figi:     candles.Figi,
-			Interval: alex.Duration2SubscriptionInterval(candles.Period),
-		})
-	if err != nil {
-		l.DPanic("не удалось подписаться на свечи на сервере.", zap.Error(err))
-	}
 	return ch
 }
 
-func (s *MarketDataStream) Unsubscribe(candles *Candles) error {
+// получать все сообщения потока рыночных данных. Канал не закрывается при переподключении
+func (s *MarketDataStream) SubscribeRaw() MarketDataChan {
 	s.locker.Lock()
 	defer s.locker.Unlock()
-	ch, ok := s.subscribers[candles]
-	if !ok {
-		l.DPanic("отписываюсь не подписавшись")
-		return errors.New("NO SUBSCRIPTION")
-	}
-	close(ch)
-	delete(s.subscribers, candles)
-	return s.sendSubscribeRequest(proto.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE,
-		&proto.CandleInstrument{
-			Figi:     candles.Figi,
-			Interval: alex.Duration2SubscriptionInterval(candles.Period),
-		})
+	ch := make(MarketDataChan, 100)
+	s.rawSubscribers = append(s.rawSubscribers, ch)
+	return ch
 }
 
-func (s *MarketDataStream) sendSubscribeRequest(subscriptionAction proto.SubscriptionAction, instruments ...*proto.CandleInstrument) error {
-	subscribeCandlesRequest := &proto.SubscribeCandlesRequest{
-		SubscriptionAction: subscriptionAction,
-		Instruments:        instruments,
-	}
-
-	if err := s.marketDataStreamClient.Send(&proto.MarketDataRequest{
-		Payload: &proto.MarketDataRequest_SubscribeCandlesRequest{
-			SubscribeCandlesRequest: subscribeCandlesRequest,
-		},
-	}); err != nil {
-		return err
-	}
-
-	//TODO разделить на статусы статус торгов и свечей
-	//TODO BUG если будет несколько подписок свечи на разные периоды, здесь будут дубли инструментов
-	var ii []*proto.InfoInstrument
-	for _, inst := range instruments {
-		ii = append(ii, &proto.InfoInstrument{Figi: inst.Figi})
-	}
-
-	subscribeInfoRequest := &proto.SubscribeInfoRequest{
-		SubscriptionAction: subscriptionAction,
-		Instruments:        ii,
+func (s *MarketDataStream) UnsubscribeRaw(ch MarketDataChan) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for i, c := range s.rawSubscribers {
+		if c == ch {
+			s.rawSubscribers = append(s.rawSubscribers[:i], s.rawSubscribers[i+1:]...)
+			close(ch)
+			return nil
+		}
 	}
+	l.DPanic("отписываюсь не подписавшись")
+	return errors.New("NO SUBSCRIPTION")
+}
 
-	if err := s.marketDataStreamClient.Send(&proto.MarketDataRequest{
-		Payload: &proto.MarketDataRequest_SubscribeInfoRequest{
-			SubscribeInfoRequest: subscribeInfoRequest,
-		},
-	}); err != nil {
-		return err
+func (s *MarketDataStream) sendRaw(marketdata *proto.MarketDataResponse) {
+	s.locker.RLock()
+	defer s.locker.RUnlock()
+	for _, ch := range s.rawSubscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток рыночных данных. медленная запись?")
+		} else {
+			ch <- marketdata
+		}
 	}
-
-	return nil
 }
 
 func (s *MarketDataStream) GetCandles(figi string, interval proto.SubscriptionInterval) (alex.Candles, alex.CandleChan) {
-	s.locker.RLock()
-	defer s.locker.RUnlock()
 	duration := alex.SubscriptionInterval2Duration(interval)
+	// свечи регистрируются при создании, поэтому получаю их до блокировки
 	candles := s.client.Instruments.Get(figi).GetCandles(duration)
+	s.locker.RLock()
+	defer s.locker.RUnlock()
 	return candles, s.subscribers[candles]
 }
 
-func (s *MarketDataStream) streamReader() {
+func (s *MarketDataStream) streamReader(stream proto.MarketDataStreamService_MarketDataStreamClient) {
 	for {
-		marketdata, err := s.marketDataStreamClient.Recv()
+		marketdata, err := stream.Recv()
 		if err != nil {
-			if status.Code(err) == codes.Canceled {
+			// поток, закрытый из-за зависания, переподключается
+			if status.Code(err) == codes.Canceled && s.client.ctx.Err() != nil {
 				l.Debug("marketDataStreamClient - закрыто соединения")
+			} else if !s.isCurrent(stream) {
+				l.Debug("marketDataStreamClient - поток заменён новым", zap.Error(err))
 			} else if status.Code(err) == codes.ResourceExhausted {
-				l.DPanic("Превышены доступные ресурсы подключения.")
-
+				// переподключение с нарастающей паузой, пока лимит потоков не освободится
+				l.Error("Превышены доступные ресурсы подключения.", zap.Error(err))
+				rateLimitExhaustedMetric.WithLabelValues("MarketDataStream").Inc()
+				s.reconnect()
 			} else {
 				l.Error("marketDataStreamClient получена ошибка", zap.Error(err))
 				//переподключение
@@ -166,9 +202,18 @@ func (s *MarketDataStream) streamReader() {
 			}
 			return
 		}
+		s.liveness.touch()
+		s.sendRaw(marketdata)
+		if s.Subscriptions.onResponse(marketdata) {
+			continue
+		}
+
 		apiCandle := marketdata.GetCandle()
 		if apiCandle != nil {
 			closePrice := alex.NewDecimal(apiCandle.Close)
+			if i := s.client.Instruments.Get(apiCandle.Figi); i != nil {
+				i.touchData()
+			}
 			candles, ch := s.GetCandles(apiCandle.Figi, apiCandle.Interval)
 			candle := &techan.Candle{
 				Period:     techan.NewTimePeriod(apiCandle.Time.AsTime(), candles.GetPeriod()),
@@ -187,6 +232,35 @@ func (s *MarketDataStream) streamReader() {
 			lastPriceMetric.WithLabelValues(apiCandle.Figi).Set(closePrice.Float())
 		}
 
+		apiOrderBook := marketdata.GetOrderbook()
+		if apiOrderBook != nil {
+			i := s.client.Instruments.Get(apiOrderBook.Figi)
+			if i != nil {
+				i.touchData()
+				i.onOrderBook(alex.NewStreamOrderBook(apiOrderBook), apiOrderBook.IsConsistent)
+			}
+		}
+
+		apiTrade := marketdata.GetTrade()
+		if apiTrade != nil {
+			i := s.client.Instruments.Get(apiTrade.Figi)
+			if i != nil {
+				i.touchData()
+				i.onTrade(alex.NewTrade(apiTrade))
+			}
+		}
+
+		apiLastPrice := marketdata.GetLastPrice()
+		if apiLastPrice != nil {
+			lastPrice := alex.NewLastPrice(apiLastPrice.Figi, alex.NewDecimal(apiLastPrice.Price), apiLastPrice.Time.AsTime())
+			i := s.client.Instruments.Get(apiLastPrice.Figi)
+			if i != nil {
+				i.touchData()
+				i.onLastPrice(lastPrice)
+			}
+			lastPriceMetric.WithLabelValues(apiLastPrice.Figi).Set(lastPrice.Price.Float())
+		}
+
 		tradingStatus := marketdata.GetTradingStatus()
 		if tradingStatus != nil {
 			if tradingStatus.Time.AsTime().Before(time.Now().Add(-time.Minute)) &&
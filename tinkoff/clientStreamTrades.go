@@ -2,7 +2,6 @@ package tinkoff
 
 import (
 	"sync"
-	"time"
 
 	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 	"go.uber.org/zap"
@@ -18,14 +17,30 @@ type OrderTrades struct {
 	subscribers        []OrderTradesChan
 	accounts           []string
 	ordersStreamClient proto.OrdersStreamService_TradesStreamClient
+	liveness           *streamLiveness
 }
 
 func NewOrderTrades(c *Client) *OrderTrades {
 	return &OrderTrades{
-		client: c,
+		client:   c,
+		liveness: newStreamLiveness("OrderTrades"),
 	}
 }
 
+// открыт ли поток сделок
+func (ot *OrderTrades) isOpened() bool {
+	ot.locker.RLock()
+	defer ot.locker.RUnlock()
+	return ot.ordersStreamClient != nil
+}
+
+// текущий ли поток. Поток, заменённый при переподключении, закрывается, но переподключать его не надо
+func (ot *OrderTrades) isCurrent(stream proto.OrdersStreamService_TradesStreamClient) bool {
+	ot.locker.RLock()
+	defer ot.locker.RUnlock()
+	return ot.ordersStreamClient == stream
+}
+
 func (ot *OrderTrades) SetAccounts(accounts []string) {
 	ot.locker.Lock()
 	defer ot.locker.Unlock()
@@ -39,33 +54,30 @@ func (ot *OrderTrades) open() (err error) {
 
 	l.Debug("openOrderTradesStream")
 	ot.ordersStreamClient, err = ot.client.GetOrdersStreamServiceClient().TradesStream(
-		ot.client.ctx,
+		ot.liveness.newContext(ot.client.ctx),
 		&proto.TradesStreamRequest{
 			Accounts: ot.accounts,
 		},
 	)
 	if err != nil {
-		l.DPanic("не удалось подписаться на сделки", zap.Error(err))
+		l.Error("не удалось подписаться на сделки", zap.Error(err))
 		return err
 	}
-	go ot.streamReader()
+	go ot.streamReader(ot.ordersStreamClient)
 	return nil
 }
 
+// переподключение. Поток открывается заново с теми же счетами, поэтому подписка восстанавливается.
+// Если счетов нет, то поток не нужен, и переподключаться не надо, чтобы не расходовать лимит потоков сделок
 func (ot *OrderTrades) reconnect() {
-	sleepTime := time.Second
-	for {
-		time.Sleep(sleepTime)
-		sleepTime = 2 * sleepTime
-		err := ot.open()
-		if err != nil {
-			l.Error("OrderTrades reconnect open", zap.Error(err))
-			continue
-		}
-		//TODO BUG при переподключении надо отправлсять запрос на переподписку
-		//но только если были запрошены боевые аккаунты, чтобы не словить лимит на количество потоков подписки на сделки
-		break
+	ot.locker.RLock()
+	accounts := len(ot.accounts)
+	ot.locker.RUnlock()
+	if accounts == 0 {
+		l.Info("OrderTrades нет счетов для подписки на сделки, переподключение не требуется")
+		return
 	}
+	reconnectLoop(ot.client.ctx, "OrderTrades", ot.open) //nolint:golint,errcheck
 }
 
 func (ot *OrderTrades) Subscribe() (OrderTradesChan, error) {
@@ -96,16 +108,21 @@ func (ot *OrderTrades) Unsubscribe(ch OrderTradesChan) (err error) {
 	return nil
 }
 
-func (ot *OrderTrades) streamReader() {
+func (ot *OrderTrades) streamReader(stream proto.OrdersStreamService_TradesStreamClient) {
 	for {
-		recv, err := ot.ordersStreamClient.Recv()
+		recv, err := stream.Recv()
 		l.Debug("orderTradeStreamClient.Recv()", zap.Any("orderTrades", recv))
 		if err != nil {
-			if status.Code(err) == codes.Canceled {
+			// поток, закрытый из-за зависания, переподключается
+			if status.Code(err) == codes.Canceled && ot.client.ctx.Err() != nil {
 				l.Debug("streamReader - закрыто соединения")
+			} else if !ot.isCurrent(stream) {
+				l.Debug("streamReader - поток сделок заменён новым", zap.Error(err))
 			} else if status.Code(err) == codes.ResourceExhausted {
-				l.DPanic("Превышены доступные ресурсы подключения.")
-				//TODO     ot.client.Stop()
+				// переподключение с нарастающей паузой, пока лимит потоков не освободится
+				l.Error("Превышены доступные ресурсы подключения.", zap.Error(err))
+				rateLimitExhaustedMetric.WithLabelValues("OrderTrades").Inc()
+				ot.reconnect()
 			} else {
 				l.Error("streamReader получена ошибка", zap.Error(err))
 				//переподключение
@@ -113,6 +130,7 @@ func (ot *OrderTrades) streamReader() {
 			}
 			return
 		}
+		ot.liveness.touch()
 		orderTrades := recv.GetOrderTrades()
 		if orderTrades != nil {
 			ot.locker.RLock()
@@ -0,0 +1,402 @@
+package fake
+
+// Счета fake сервера: боевые счета создаются через AddAccount, счета песочницы - запросами SandboxService.
+// Методы OrdersService и OperationsService работают только с боевыми счетами, методы SandboxService - только
+// со счетами песочницы, как и на сервере брокера
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+type account struct {
+	id                string
+	name              string
+	sandbox           bool
+	opened            time.Time
+	money             map[string]big.Decimal // валюта -> свободные деньги
+	blockedMoney      map[string]big.Decimal // валюта -> деньги, заблокированные под заявки
+	securities        map[string]int64       // figi -> свободные бумаги, в штуках
+	blockedSecurities map[string]int64       // figi -> бумаги, заблокированные под заявки
+	averagePrice      map[string]big.Decimal // figi -> средняя цена покупки
+	orders            map[string]*order      // orderId -> заявка
+	operations        []*proto.Operation
+}
+
+func newAccount(id string, name string, sandbox bool) *account {
+	return &account{
+		id:                id,
+		name:              name,
+		sandbox:           sandbox,
+		opened:            time.Now().UTC(),
+		money:             make(map[string]big.Decimal),
+		blockedMoney:      make(map[string]big.Decimal),
+		securities:        make(map[string]int64),
+		blockedSecurities: make(map[string]int64),
+		averagePrice:      make(map[string]big.Decimal),
+		orders:            make(map[string]*order),
+	}
+}
+
+func (a *account) moneyOf(currency string) big.Decimal {
+	if m, ok := a.money[currency]; ok {
+		return m
+	}
+	return big.ZERO
+}
+
+func (a *account) blockedMoneyOf(currency string) big.Decimal {
+	if m, ok := a.blockedMoney[currency]; ok {
+		return m
+	}
+	return big.ZERO
+}
+
+// активные заявки по времени выставления
+func (a *account) activeOrders() []*order {
+	var result []*order
+	for _, o := range a.orders {
+		if o.isActive() {
+			result = append(result, o)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].created.Equal(result[j].created) {
+			return result[i].id < result[j].id
+		}
+		return result[i].created.Before(result[j].created)
+	})
+	return result
+}
+
+func (a *account) toProto() *proto.Account {
+	return &proto.Account{
+		Id:          a.id,
+		Type:        proto.AccountType_ACCOUNT_TYPE_TINKOFF,
+		Name:        a.name,
+		Status:      proto.AccountStatus_ACCOUNT_STATUS_OPEN,
+		OpenedDate:  timestamppb.New(a.opened),
+		AccessLevel: proto.AccessLevel_ACCOUNT_ACCESS_LEVEL_FULL_ACCESS,
+	}
+}
+
+// добавляет боевой счёт с рублями на нём
+func (s *Server) AddAccount(id string, name string, rub big.Decimal) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a := newAccount(id, name, false)
+	s.accounts[id] = a
+	s.payIn(a, "rub", rub)
+}
+
+// пополняет счёт
+func (s *Server) PayIn(accountId string, currency string, amount big.Decimal) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, ok := s.accounts[accountId]
+	if !ok {
+		return fmt.Errorf("счёт %s: %w", accountId, alex.ErrNotFound)
+	}
+	s.payIn(a, currency, amount)
+	return nil
+}
+
+// зачисляет деньги на счёт. Вызывается под блокировкой
+func (s *Server) payIn(a *account, currency string, amount big.Decimal) {
+	if amount.IsZero() {
+		return
+	}
+	currency = strings.ToLower(currency)
+	a.money[currency] = a.moneyOf(currency).Add(amount)
+	a.operations = append(a.operations, &proto.Operation{
+		Id:            s.nextId("operation"),
+		Currency:      currency,
+		Payment:       alex.NewMoneyValue(&alex.Money{Currency: currency, Value: amount}),
+		Price:         alex.NewMoneyValue(&alex.Money{Currency: currency, Value: big.ZERO}),
+		State:         proto.OperationState_OPERATION_STATE_EXECUTED,
+		Date:          timestamppb.Now(),
+		Type:          "Пополнение брокерского счёта",
+		OperationType: proto.OperationType_OPERATION_TYPE_INPUT,
+	})
+}
+
+// счёт по идентификатору. Счёт песочницы не доступен через боевые методы и наоборот. Вызывается под блокировкой
+func (s *Server) account(ctx context.Context, accountId string, sandbox bool) (*account, error) {
+	a, ok := s.accounts[accountId]
+	if !ok || a.sandbox != sandbox {
+		return nil, apiError(ctx, codes.NotFound, "", "Account not found")
+	}
+	return a, nil
+}
+
+func (s *Server) accountList(sandbox bool) *proto.GetAccountsResponse {
+	ids := make([]string, 0, len(s.accounts))
+	for id, a := range s.accounts {
+		if a.sandbox == sandbox {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	resp := &proto.GetAccountsResponse{}
+	for _, id := range ids {
+		resp.Accounts = append(resp.Accounts, s.accounts[id].toProto())
+	}
+	return resp
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) getPositions(ctx context.Context, req *proto.PositionsRequest, sandbox bool) (*proto.PositionsResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.PositionsResponse{}
+	for _, currency := range sortedKeys(a.money) {
+		resp.Money = append(resp.Money, alex.NewMoneyValue(&alex.Money{Currency: currency, Value: a.money[currency]}))
+	}
+	for _, currency := range sortedKeys(a.blockedMoney) {
+		if !a.blockedMoney[currency].IsZero() {
+			resp.Blocked = append(resp.Blocked, alex.NewMoneyValue(&alex.Money{Currency: currency, Value: a.blockedMoney[currency]}))
+		}
+	}
+	for _, figi := range s.heldFigis(a) {
+		resp.Securities = append(resp.Securities, &proto.PositionsSecurities{
+			Figi:    figi,
+			Balance: a.securities[figi],
+			Blocked: a.blockedSecurities[figi],
+		})
+	}
+	return resp, nil
+}
+
+// figi бумаг на счёте
+func (s *Server) heldFigis(a *account) []string {
+	var result []string
+	for _, figi := range s.figis {
+		if a.securities[figi]+a.blockedSecurities[figi] > 0 {
+			result = append(result, figi)
+		}
+	}
+	return result
+}
+
+func (s *Server) getPortfolio(ctx context.Context, req *proto.PortfolioRequest, sandbox bool) (*proto.PortfolioResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	totals := map[InstrumentType]big.Decimal{
+		InstrumentType_SHARE:    big.ZERO,
+		InstrumentType_CURRENCY: a.moneyOf("rub").Add(a.blockedMoneyOf("rub")),
+	}
+	cost, value := big.ZERO, big.ZERO
+	resp := &proto.PortfolioResponse{}
+	for _, figi := range s.heldFigis(a) {
+		i := s.instruments[figi]
+		quantity := a.securities[figi] + a.blockedSecurities[figi]
+		average := a.averagePrice[figi]
+		positionCost := average.Mul(big.NewFromInt(int(quantity)))
+		positionValue := i.price.Mul(big.NewFromInt(int(quantity)))
+		cost, value = cost.Add(positionCost), value.Add(positionValue)
+		totals[i.Type] = totals[i.Type].Add(positionValue)
+		resp.Positions = append(resp.Positions, &proto.PortfolioPosition{
+			Figi:                     figi,
+			InstrumentType:           i.Type.String(),
+			Quantity:                 alex.NewQuotation(big.NewFromInt(int(quantity))),
+			AveragePositionPrice:     alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: average}),
+			AveragePositionPriceFifo: alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: average}),
+			AveragePositionPricePt:   alex.NewQuotation(average),
+			ExpectedYield:            alex.NewQuotation(percent(positionValue.Sub(positionCost), positionCost)),
+			CurrentPrice:             alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: i.price}),
+			QuantityLots:             alex.NewQuotation(big.NewFromInt(int(quantity / int64(i.Lot)))),
+		})
+	}
+	rub := func(d big.Decimal) *proto.MoneyValue {
+		return alex.NewMoneyValue(&alex.Money{Currency: "rub", Value: d})
+	}
+	resp.TotalAmountShares = rub(totals[InstrumentType_SHARE])
+	resp.TotalAmountCurrencies = rub(totals[InstrumentType_CURRENCY])
+	resp.TotalAmountBonds = rub(big.ZERO)
+	resp.TotalAmountEtf = rub(big.ZERO)
+	resp.TotalAmountFutures = rub(big.ZERO)
+	resp.ExpectedYield = alex.NewQuotation(percent(value.Sub(cost), cost))
+	return resp, nil
+}
+
+// доходность в процентах
+func percent(yield big.Decimal, cost big.Decimal) big.Decimal {
+	if cost.IsZero() {
+		return big.ZERO
+	}
+	return yield.Div(cost).Mul(big.NewFromInt(100))
+}
+
+func (s *Server) getOperations(ctx context.Context, req *proto.OperationsRequest, sandbox bool) (*proto.OperationsResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.OperationsResponse{}
+	for _, o := range a.operations {
+		date := o.Date.AsTime()
+		if req.From != nil && date.Before(req.From.AsTime()) {
+			continue
+		}
+		if req.To != nil && date.After(req.To.AsTime()) {
+			continue
+		}
+		if req.Figi != "" && req.Figi != o.Figi {
+			continue
+		}
+		if req.State != proto.OperationState_OPERATION_STATE_UNSPECIFIED && req.State != o.State {
+			continue
+		}
+		resp.Operations = append(resp.Operations, o)
+	}
+	return resp, nil
+}
+
+type usersService struct {
+	proto.UnimplementedUsersServiceServer
+	s *Server
+}
+
+func (u *usersService) GetAccounts(ctx context.Context, req *proto.GetAccountsRequest) (*proto.GetAccountsResponse, error) {
+	u.s.locker.Lock()
+	defer u.s.locker.Unlock()
+	return u.s.accountList(false), nil
+}
+
+// тариф без ограничений частоты запросов, с ограничением количества потоков
+func (u *usersService) GetUserTariff(ctx context.Context, req *proto.GetUserTariffRequest) (*proto.GetUserTariffResponse, error) {
+	return &proto.GetUserTariffResponse{
+		StreamLimits: []*proto.StreamLimit{{
+			Limit:   streamLimit,
+			Streams: []string{"tinkoff.public.invest.api.contract.v1.MarketDataStreamService/MarketDataStream"},
+		}, {
+			Limit:   streamLimit,
+			Streams: []string{"tinkoff.public.invest.api.contract.v1.OrdersStreamService/TradesStream"},
+		}},
+	}, nil
+}
+
+func (u *usersService) GetInfo(ctx context.Context, req *proto.GetInfoRequest) (*proto.GetInfoResponse, error) {
+	return &proto.GetInfoResponse{Tariff: "fake"}, nil
+}
+
+type operationsService struct {
+	proto.UnimplementedOperationsServiceServer
+	s *Server
+}
+
+func (o *operationsService) GetOperations(ctx context.Context, req *proto.OperationsRequest) (*proto.OperationsResponse, error) {
+	return o.s.getOperations(ctx, req, false)
+}
+
+func (o *operationsService) GetPortfolio(ctx context.Context, req *proto.PortfolioRequest) (*proto.PortfolioResponse, error) {
+	return o.s.getPortfolio(ctx, req, false)
+}
+
+func (o *operationsService) GetPositions(ctx context.Context, req *proto.PositionsRequest) (*proto.PositionsResponse, error) {
+	return o.s.getPositions(ctx, req, false)
+}
+
+type sandboxService struct {
+	proto.UnimplementedSandboxServiceServer
+	s *Server
+}
+
+func (sb *sandboxService) OpenSandboxAccount(ctx context.Context, req *proto.OpenSandboxAccountRequest) (*proto.OpenSandboxAccountResponse, error) {
+	sb.s.locker.Lock()
+	defer sb.s.locker.Unlock()
+	a := newAccount(uuid.NewString(), "", true)
+	sb.s.accounts[a.id] = a
+	return &proto.OpenSandboxAccountResponse{AccountId: a.id}, nil
+}
+
+func (sb *sandboxService) GetSandboxAccounts(ctx context.Context, req *proto.GetAccountsRequest) (*proto.GetAccountsResponse, error) {
+	sb.s.locker.Lock()
+	defer sb.s.locker.Unlock()
+	return sb.s.accountList(true), nil
+}
+
+func (sb *sandboxService) CloseSandboxAccount(ctx context.Context, req *proto.CloseSandboxAccountRequest) (*proto.CloseSandboxAccountResponse, error) {
+	sb.s.locker.Lock()
+	defer sb.s.locker.Unlock()
+	if _, err := sb.s.account(ctx, req.AccountId, true); err != nil {
+		return nil, err
+	}
+	delete(sb.s.accounts, req.AccountId)
+	return &proto.CloseSandboxAccountResponse{}, nil
+}
+
+func (sb *sandboxService) SandboxPayIn(ctx context.Context, req *proto.SandboxPayInRequest) (*proto.SandboxPayInResponse, error) {
+	sb.s.locker.Lock()
+	defer sb.s.locker.Unlock()
+	a, err := sb.s.account(ctx, req.AccountId, true)
+	if err != nil {
+		return nil, err
+	}
+	amount := alex.NewMoney(req.Amount)
+	if amount == nil || amount.Value.LTE(big.ZERO) {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid amount")
+	}
+	currency := strings.ToLower(amount.Currency)
+	sb.s.payIn(a, currency, amount.Value)
+	return &proto.SandboxPayInResponse{
+		Balance: alex.NewMoneyValue(&alex.Money{Currency: currency, Value: a.moneyOf(currency)}),
+	}, nil
+}
+
+func (sb *sandboxService) PostSandboxOrder(ctx context.Context, req *proto.PostOrderRequest) (*proto.PostOrderResponse, error) {
+	return sb.s.postOrder(ctx, req, true)
+}
+
+func (sb *sandboxService) GetSandboxOrders(ctx context.Context, req *proto.GetOrdersRequest) (*proto.GetOrdersResponse, error) {
+	return sb.s.getOrders(ctx, req, true)
+}
+
+func (sb *sandboxService) CancelSandboxOrder(ctx context.Context, req *proto.CancelOrderRequest) (*proto.CancelOrderResponse, error) {
+	return sb.s.cancelOrder(ctx, req, true)
+}
+
+func (sb *sandboxService) GetSandboxOrderState(ctx context.Context, req *proto.GetOrderStateRequest) (*proto.OrderState, error) {
+	return sb.s.getOrderState(ctx, req, true)
+}
+
+func (sb *sandboxService) GetSandboxPositions(ctx context.Context, req *proto.PositionsRequest) (*proto.PositionsResponse, error) {
+	return sb.s.getPositions(ctx, req, true)
+}
+
+func (sb *sandboxService) GetSandboxOperations(ctx context.Context, req *proto.OperationsRequest) (*proto.OperationsResponse, error) {
+	return sb.s.getOperations(ctx, req, true)
+}
+
+func (sb *sandboxService) GetSandboxPortfolio(ctx context.Context, req *proto.PortfolioRequest) (*proto.PortfolioResponse, error) {
+	return sb.s.getPortfolio(ctx, req, true)
+}
@@ -0,0 +1,54 @@
+package fake
+
+// Запуск fake сервера в памяти процесса, без сети. Так сервер используется в Go тестах:
+//
+//	server := fake.New()
+//	server.AddAccount("test", "тестовый счёт", big.NewFromInt(100000))
+//	server.ServeBufconn()
+//	defer server.Stop()
+//	client := server.Client(t.TempDir())
+//	err := client.Open(ctx)
+
+import (
+	"context"
+	"net"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/go-trading/alex/tinkoff"
+)
+
+const bufconnSize = 1024 * 1024
+
+// запускает сервер на соединении в памяти. Останавливается через Stop
+func (s *Server) ServeBufconn() {
+	listener := bufconn.Listen(bufconnSize)
+	s.locker.Lock()
+	s.listener = listener
+	s.locker.Unlock()
+	go func() {
+		if err := s.Serve(listener); err != nil {
+			l.Error("fake: сервер остановлен с ошибкой", zap.Error(err))
+		}
+	}()
+}
+
+// опция соединения с сервером, запущенным через ServeBufconn
+func (s *Server) Dialer() grpc.DialOption {
+	s.locker.Lock()
+	listener := s.listener
+	s.locker.Unlock()
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	})
+}
+
+// клиент, подключенный к серверу, запущенному через ServeBufconn. Соединение открывается через Open
+func (s *Server) Client(dataDir string) *tinkoff.Client {
+	client := tinkoff.NewClient("bufconn", "fake-token", dataDir)
+	client.SetPlaintext(true)
+	client.AddDialOptions(s.Dialer())
+	return client
+}
@@ -0,0 +1,194 @@
+package fake
+
+// Справочник инструментов и расписание торгов fake сервера. Торги идут круглосуточно, каждый день торговый
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const exchange = "FAKE"
+
+func (i *instrument) share() *proto.Share {
+	return &proto.Share{
+		Figi:                  i.Figi,
+		Ticker:                i.Ticker,
+		ClassCode:             i.ClassCode,
+		Lot:                   i.Lot,
+		Currency:              i.Currency,
+		Name:                  i.Name,
+		Exchange:              exchange,
+		TradingStatus:         i.status,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		MinPriceIncrement:     alex.NewQuotation(i.MinPriceIncrement),
+		ApiTradeAvailableFlag: true,
+	}
+}
+
+func (i *instrument) currency() *proto.Currency {
+	return &proto.Currency{
+		Figi:                  i.Figi,
+		Ticker:                i.Ticker,
+		ClassCode:             i.ClassCode,
+		Lot:                   i.Lot,
+		Currency:              i.Currency,
+		Name:                  i.Name,
+		Exchange:              exchange,
+		TradingStatus:         i.status,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		MinPriceIncrement:     alex.NewQuotation(i.MinPriceIncrement),
+		ApiTradeAvailableFlag: true,
+	}
+}
+
+func (i *instrument) toProto() *proto.Instrument {
+	return &proto.Instrument{
+		Figi:                  i.Figi,
+		Ticker:                i.Ticker,
+		ClassCode:             i.ClassCode,
+		Lot:                   i.Lot,
+		Currency:              i.Currency,
+		Name:                  i.Name,
+		Exchange:              exchange,
+		InstrumentType:        i.Type.String(),
+		TradingStatus:         i.status,
+		BuyAvailableFlag:      true,
+		SellAvailableFlag:     true,
+		MinPriceIncrement:     alex.NewQuotation(i.MinPriceIncrement),
+		ApiTradeAvailableFlag: true,
+	}
+}
+
+// инструменты типа t в порядке добавления. Вызывается под блокировкой
+func (s *Server) instrumentsOf(t InstrumentType) []*instrument {
+	var result []*instrument
+	for _, figi := range s.figis {
+		if i := s.instruments[figi]; i.Type == t {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// поиск инструмента по запросу *By. Вызывается под блокировкой
+func (s *Server) findInstrument(ctx context.Context, req *proto.InstrumentRequest) (*instrument, error) {
+	switch req.IdType {
+	case proto.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI:
+		return s.instrument(ctx, req.Id)
+	case proto.InstrumentIdType_INSTRUMENT_ID_TYPE_TICKER:
+		for _, figi := range s.figis {
+			i := s.instruments[figi]
+			if strings.EqualFold(i.Ticker, req.Id) && (req.ClassCode == "" || i.ClassCode == req.ClassCode) {
+				return i, nil
+			}
+		}
+		return nil, apiError(ctx, codes.NotFound, codeInstrumentNotFound, "Instrument not found")
+	default:
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Unsupported id type")
+	}
+}
+
+type instrumentsService struct {
+	proto.UnimplementedInstrumentsServiceServer
+	s *Server
+}
+
+func (is *instrumentsService) Shares(ctx context.Context, req *proto.InstrumentsRequest) (*proto.SharesResponse, error) {
+	is.s.locker.Lock()
+	defer is.s.locker.Unlock()
+	resp := &proto.SharesResponse{}
+	for _, i := range is.s.instrumentsOf(InstrumentType_SHARE) {
+		resp.Instruments = append(resp.Instruments, i.share())
+	}
+	return resp, nil
+}
+
+func (is *instrumentsService) Currencies(ctx context.Context, req *proto.InstrumentsRequest) (*proto.CurrenciesResponse, error) {
+	is.s.locker.Lock()
+	defer is.s.locker.Unlock()
+	resp := &proto.CurrenciesResponse{}
+	for _, i := range is.s.instrumentsOf(InstrumentType_CURRENCY) {
+		resp.Instruments = append(resp.Instruments, i.currency())
+	}
+	return resp, nil
+}
+
+func (is *instrumentsService) Etfs(ctx context.Context, req *proto.InstrumentsRequest) (*proto.EtfsResponse, error) {
+	return &proto.EtfsResponse{}, nil
+}
+
+func (is *instrumentsService) Bonds(ctx context.Context, req *proto.InstrumentsRequest) (*proto.BondsResponse, error) {
+	return &proto.BondsResponse{}, nil
+}
+
+func (is *instrumentsService) Futures(ctx context.Context, req *proto.InstrumentsRequest) (*proto.FuturesResponse, error) {
+	return &proto.FuturesResponse{}, nil
+}
+
+func (is *instrumentsService) ShareBy(ctx context.Context, req *proto.InstrumentRequest) (*proto.ShareResponse, error) {
+	is.s.locker.Lock()
+	defer is.s.locker.Unlock()
+	i, err := is.s.findInstrument(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if i.Type != InstrumentType_SHARE {
+		return nil, apiError(ctx, codes.NotFound, codeInstrumentNotFound, "Instrument not found")
+	}
+	return &proto.ShareResponse{Instrument: i.share()}, nil
+}
+
+func (is *instrumentsService) CurrencyBy(ctx context.Context, req *proto.InstrumentRequest) (*proto.CurrencyResponse, error) {
+	is.s.locker.Lock()
+	defer is.s.locker.Unlock()
+	i, err := is.s.findInstrument(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if i.Type != InstrumentType_CURRENCY {
+		return nil, apiError(ctx, codes.NotFound, codeInstrumentNotFound, "Instrument not found")
+	}
+	return &proto.CurrencyResponse{Instrument: i.currency()}, nil
+}
+
+func (is *instrumentsService) GetInstrumentBy(ctx context.Context, req *proto.InstrumentRequest) (*proto.InstrumentResponse, error) {
+	is.s.locker.Lock()
+	defer is.s.locker.Unlock()
+	i, err := is.s.findInstrument(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &proto.InstrumentResponse{Instrument: i.toProto()}, nil
+}
+
+func (is *instrumentsService) TradingSchedules(ctx context.Context, req *proto.TradingSchedulesRequest) (*proto.TradingSchedulesResponse, error) {
+	if req.Exchange != "" && req.Exchange != exchange {
+		return &proto.TradingSchedulesResponse{}, nil
+	}
+	from, to := time.Now().UTC(), time.Now().UTC()
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+	schedule := &proto.TradingSchedule{Exchange: exchange}
+	for day := from.Truncate(24 * time.Hour); !day.After(to); day = day.Add(24 * time.Hour) {
+		schedule.Days = append(schedule.Days, &proto.TradingDay{
+			Date:         timestamppb.New(day),
+			IsTradingDay: true,
+			StartTime:    timestamppb.New(day),
+			EndTime:      timestamppb.New(day.Add(24 * time.Hour)),
+		})
+	}
+	return &proto.TradingSchedulesResponse{Exchanges: []*proto.TradingSchedule{schedule}}, nil
+}
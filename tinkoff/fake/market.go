@@ -0,0 +1,683 @@
+package fake
+
+// Рыночные данные fake сервера.
+// По каждому инструменту хранится последняя цена, минутные свечи и последние обезличенные сделки. Каждое изменение
+// цены считается сделкой объёмом в 1 лот. Свечи других интервалов собираются из минутных. Стакан не хранится, а
+// строится вокруг последней цены: заявки на покупку на шаг цены ниже, заявки на продажу на шаг цены выше
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const (
+	maxTrades          = 1000             // сколько обезличенных сделок хранить по инструменту
+	maxOrderBookDepth  = 50               // максимальная глубина стакана
+	orderBookQuantity  = 1000             // количество лотов на каждом уровне стакана
+	pingInterval       = 30 * time.Second // как часто сервер отправляет ping в потоки
+	streamLimit        = 16               // максимальное количество открытых потоков каждого вида
+	streamSendCapacity = 1000             // размер очереди сообщений потока
+)
+
+type InstrumentType int32
+
+const (
+	InstrumentType_SHARE    InstrumentType = iota // акция
+	InstrumentType_CURRENCY InstrumentType = iota // валюта
+)
+
+var InstrumentType2string = map[InstrumentType]string{
+	InstrumentType_SHARE:    "share",
+	InstrumentType_CURRENCY: "currency",
+}
+
+func (t InstrumentType) String() string {
+	return InstrumentType2string[t]
+}
+
+// описание инструмента
+type Instrument struct {
+	Figi              string
+	Ticker            string
+	ClassCode         string
+	Name              string
+	Type              InstrumentType
+	Currency          string // валюта расчётов
+	Lot               int32
+	MinPriceIncrement big.Decimal
+	Price             big.Decimal // начальная цена за 1 инструмент
+}
+
+type candle struct {
+	time   time.Time
+	open   big.Decimal
+	high   big.Decimal
+	low    big.Decimal
+	close  big.Decimal
+	volume int64
+}
+
+type instrument struct {
+	Instrument
+	price   big.Decimal
+	updated time.Time
+	status  proto.SecurityTradingStatus
+	candles []*candle      // минутные свечи по возрастанию времени
+	trades  []*proto.Trade // последние обезличенные сделки
+}
+
+// добавляет инструмент. Инструмент с тем же figi заменяется
+func (s *Server) AddInstrument(i Instrument) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if _, ok := s.instruments[i.Figi]; !ok {
+		s.figis = append(s.figis, i.Figi)
+	}
+	s.instruments[i.Figi] = &instrument{
+		Instrument: i,
+		price:      i.Price,
+		updated:    time.Now().UTC(),
+		status:     proto.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING,
+	}
+}
+
+// текущая цена инструмента
+func (s *Server) Price(figi string) (big.Decimal, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	i, ok := s.instruments[figi]
+	if !ok {
+		return big.NaN, fmt.Errorf("инструмент %s: %w", figi, alex.ErrNotFound)
+	}
+	return i.price, nil
+}
+
+// меняет цену инструмента: обновляет свечи, рассылает данные в потоки и исполняет заявки, цена которых достигнута
+func (s *Server) SetPrice(figi string, price big.Decimal) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	i, ok := s.instruments[figi]
+	if !ok {
+		return fmt.Errorf("инструмент %s: %w", figi, alex.ErrNotFound)
+	}
+	now := time.Now().UTC()
+	direction := proto.TradeDirection_TRADE_DIRECTION_BUY
+	if price.LT(i.price) {
+		direction = proto.TradeDirection_TRADE_DIRECTION_SELL
+	}
+	i.price = price
+	i.updated = now
+	i.addCandle(price, now)
+	trade := &proto.Trade{
+		Figi:      figi,
+		Direction: direction,
+		Price:     alex.NewQuotation(price),
+		Quantity:  1,
+		Time:      timestamppb.New(now),
+	}
+	i.trades = append(i.trades, trade)
+	if len(i.trades) > maxTrades {
+		i.trades = i.trades[len(i.trades)-maxTrades:]
+	}
+	s.broadcastPrice(i, trade, now)
+	s.matchOrders(i, now)
+	return nil
+}
+
+// меняет статус торгов инструмента. Пока статус отличается от NORMAL_TRADING, заявки отклоняются
+func (s *Server) SetTradingStatus(figi string, tradingStatus proto.SecurityTradingStatus) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	i, ok := s.instruments[figi]
+	if !ok {
+		return fmt.Errorf("инструмент %s: %w", figi, alex.ErrNotFound)
+	}
+	i.status = tradingStatus
+	now := time.Now().UTC()
+	for ms := range s.marketStreams {
+		if ms.infos[figi] {
+			ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_TradingStatus{TradingStatus: i.tradingStatus(now)}})
+		}
+	}
+	return nil
+}
+
+// шаг сценария цен
+type PriceStep struct {
+	Figi  string
+	Price big.Decimal
+}
+
+// проигрывает сценарий цен: шаги выполняются по порядку, с паузой interval между шагами
+func (s *Server) PlayPrices(ctx context.Context, steps []PriceStep, interval time.Duration) error {
+	for n, step := range steps {
+		if n > 0 {
+			if err := sleep(ctx, interval); err != nil {
+				return ctx.Err()
+			}
+		}
+		if err := s.SetPrice(step.Figi, step.Price); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// случайное блуждание цен всех инструментов: раз в interval цена каждого инструмента сдвигается на шаг цены
+// вверх, вниз или остаётся прежней. Одинаковый seed даёт одинаковую последовательность цен
+func (s *Server) RandomWalk(ctx context.Context, interval time.Duration, seed int64) error {
+	random := rand.New(rand.NewSource(seed))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		s.locker.Lock()
+		steps := make([]PriceStep, 0, len(s.figis))
+		for _, figi := range s.figis {
+			i := s.instruments[figi]
+			price := i.price.Add(i.MinPriceIncrement.Mul(big.NewFromInt(random.Intn(3) - 1)))
+			if price.GT(big.ZERO) {
+				steps = append(steps, PriceStep{Figi: figi, Price: price})
+			}
+		}
+		s.locker.Unlock()
+		for _, step := range steps {
+			if err := s.SetPrice(step.Figi, step.Price); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// сделка по цене price обновляет последнюю минутную свечу или открывает новую
+func (i *instrument) addCandle(price big.Decimal, now time.Time) {
+	minute := now.Truncate(time.Minute)
+	if n := len(i.candles); n > 0 && i.candles[n-1].time.Equal(minute) {
+		c := i.candles[n-1]
+		c.high = big.MaxSlice(c.high, price)
+		c.low = big.MinSlice(c.low, price)
+		c.close = price
+		c.volume++
+		return
+	}
+	i.candles = append(i.candles, &candle{time: minute, open: price, high: price, low: price, close: price, volume: 1})
+}
+
+// свечи периода period, собранные из минутных свечей в интервале [from, to)
+func (i *instrument) aggregate(from time.Time, to time.Time, period time.Duration) []*candle {
+	var result []*candle
+	start := sort.Search(len(i.candles), func(n int) bool { return !i.candles[n].time.Before(from.Truncate(period)) })
+	for _, m := range i.candles[start:] {
+		if !m.time.Before(to) {
+			break
+		}
+		bucket := m.time.Truncate(period)
+		if bucket.Before(from) {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].time.Equal(bucket) {
+			c := result[n-1]
+			c.high = big.MaxSlice(c.high, m.high)
+			c.low = big.MinSlice(c.low, m.low)
+			c.close = m.close
+			c.volume += m.volume
+			continue
+		}
+		c := *m
+		c.time = bucket
+		result = append(result, &c)
+	}
+	return result
+}
+
+func (i *instrument) bestBid() big.Decimal { return i.price.Sub(i.MinPriceIncrement) }
+func (i *instrument) bestAsk() big.Decimal { return i.price.Add(i.MinPriceIncrement) }
+
+func (i *instrument) orderBook(depth int32) (bids []*proto.Order, asks []*proto.Order) {
+	for k := 1; k <= int(depth); k++ {
+		step := i.MinPriceIncrement.Mul(big.NewFromInt(k))
+		if bid := i.price.Sub(step); bid.GT(big.ZERO) {
+			bids = append(bids, &proto.Order{Price: alex.NewQuotation(bid), Quantity: orderBookQuantity})
+		}
+		asks = append(asks, &proto.Order{Price: alex.NewQuotation(i.price.Add(step)), Quantity: orderBookQuantity})
+	}
+	return bids, asks
+}
+
+func (i *instrument) tradingStatus(now time.Time) *proto.TradingStatus {
+	normal := i.status == proto.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING
+	return &proto.TradingStatus{
+		Figi:                     i.Figi,
+		TradingStatus:            i.status,
+		Time:                     timestamppb.New(now),
+		LimitOrderAvailableFlag:  normal,
+		MarketOrderAvailableFlag: normal,
+	}
+}
+
+func (i *instrument) lastPrice() *proto.LastPrice {
+	return &proto.LastPrice{Figi: i.Figi, Price: alex.NewQuotation(i.price), Time: timestamppb.New(i.updated)}
+}
+
+// текущая свеча интервала подписки
+func (i *instrument) streamCandle(interval proto.SubscriptionInterval, now time.Time) *proto.Candle {
+	period := alex.SubscriptionInterval2Duration(interval)
+	candles := i.aggregate(now.Truncate(period), now.Add(time.Minute), period)
+	if len(candles) == 0 {
+		return nil
+	}
+	c := candles[len(candles)-1]
+	return &proto.Candle{
+		Figi:        i.Figi,
+		Interval:    interval,
+		Open:        alex.NewQuotation(c.open),
+		High:        alex.NewQuotation(c.high),
+		Low:         alex.NewQuotation(c.low),
+		Close:       alex.NewQuotation(c.close),
+		Volume:      c.volume,
+		Time:        timestamppb.New(c.time),
+		LastTradeTs: timestamppb.New(i.updated),
+	}
+}
+
+var candleInterval2Duration = map[proto.CandleInterval]time.Duration{
+	proto.CandleInterval_CANDLE_INTERVAL_1_MIN:  time.Minute,
+	proto.CandleInterval_CANDLE_INTERVAL_5_MIN:  5 * time.Minute,
+	proto.CandleInterval_CANDLE_INTERVAL_15_MIN: 15 * time.Minute,
+	proto.CandleInterval_CANDLE_INTERVAL_HOUR:   time.Hour,
+	proto.CandleInterval_CANDLE_INTERVAL_DAY:    24 * time.Hour,
+}
+
+// инструмент по figi или ошибка NotFound. Вызывается под блокировкой
+func (s *Server) instrument(ctx context.Context, figi string) (*instrument, error) {
+	i, ok := s.instruments[figi]
+	if !ok {
+		return nil, apiError(ctx, codes.NotFound, codeInstrumentNotFound, "Instrument not found")
+	}
+	return i, nil
+}
+
+type marketDataService struct {
+	proto.UnimplementedMarketDataServiceServer
+	s *Server
+}
+
+func (m *marketDataService) GetCandles(ctx context.Context, req *proto.GetCandlesRequest) (*proto.GetCandlesResponse, error) {
+	m.s.locker.Lock()
+	defer m.s.locker.Unlock()
+	i, err := m.s.instrument(ctx, req.Figi)
+	if err != nil {
+		return nil, err
+	}
+	period, ok := candleInterval2Duration[req.Interval]
+	if !ok {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid candle interval")
+	}
+	now := time.Now()
+	resp := &proto.GetCandlesResponse{}
+	for _, c := range i.aggregate(req.From.AsTime(), req.To.AsTime(), period) {
+		resp.Candles = append(resp.Candles, &proto.HistoricCandle{
+			Open:       alex.NewQuotation(c.open),
+			High:       alex.NewQuotation(c.high),
+			Low:        alex.NewQuotation(c.low),
+			Close:      alex.NewQuotation(c.close),
+			Volume:     c.volume,
+			Time:       timestamppb.New(c.time),
+			IsComplete: !c.time.Add(period).After(now),
+		})
+	}
+	return resp, nil
+}
+
+func (m *marketDataService) GetLastPrices(ctx context.Context, req *proto.GetLastPricesRequest) (*proto.GetLastPricesResponse, error) {
+	m.s.locker.Lock()
+	defer m.s.locker.Unlock()
+	figis := req.Figi
+	if len(figis) == 0 {
+		figis = m.s.figis
+	}
+	resp := &proto.GetLastPricesResponse{}
+	for _, figi := range figis {
+		i, err := m.s.instrument(ctx, figi)
+		if err != nil {
+			return nil, err
+		}
+		resp.LastPrices = append(resp.LastPrices, i.lastPrice())
+	}
+	return resp, nil
+}
+
+func (m *marketDataService) GetOrderBook(ctx context.Context, req *proto.GetOrderBookRequest) (*proto.GetOrderBookResponse, error) {
+	m.s.locker.Lock()
+	defer m.s.locker.Unlock()
+	i, err := m.s.instrument(ctx, req.Figi)
+	if err != nil {
+		return nil, err
+	}
+	if req.Depth <= 0 || req.Depth > maxOrderBookDepth {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid order book depth")
+	}
+	bids, asks := i.orderBook(req.Depth)
+	return &proto.GetOrderBookResponse{
+		Figi:       i.Figi,
+		Depth:      req.Depth,
+		Bids:       bids,
+		Asks:       asks,
+		LastPrice:  alex.NewQuotation(i.price),
+		ClosePrice: alex.NewQuotation(i.price),
+	}, nil
+}
+
+func (m *marketDataService) GetTradingStatus(ctx context.Context, req *proto.GetTradingStatusRequest) (*proto.GetTradingStatusResponse, error) {
+	m.s.locker.Lock()
+	defer m.s.locker.Unlock()
+	i, err := m.s.instrument(ctx, req.Figi)
+	if err != nil {
+		return nil, err
+	}
+	s := i.tradingStatus(time.Now())
+	return &proto.GetTradingStatusResponse{
+		Figi:                     s.Figi,
+		TradingStatus:            s.TradingStatus,
+		LimitOrderAvailableFlag:  s.LimitOrderAvailableFlag,
+		MarketOrderAvailableFlag: s.MarketOrderAvailableFlag,
+		ApiTradeAvailableFlag:    true,
+	}, nil
+}
+
+func (m *marketDataService) GetLastTrades(ctx context.Context, req *proto.GetLastTradesRequest) (*proto.GetLastTradesResponse, error) {
+	m.s.locker.Lock()
+	defer m.s.locker.Unlock()
+	i, err := m.s.instrument(ctx, req.Figi)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.GetLastTradesResponse{}
+	for _, t := range i.trades {
+		tm := t.Time.AsTime()
+		if (req.From == nil || !tm.Before(req.From.AsTime())) && (req.To == nil || tm.Before(req.To.AsTime())) {
+			resp.Trades = append(resp.Trades, t)
+		}
+	}
+	return resp, nil
+}
+
+type candleKey struct {
+	figi     string
+	interval proto.SubscriptionInterval
+}
+
+type orderBookKey struct {
+	figi  string
+	depth int32
+}
+
+// открытый поток рыночных данных и его подписки. Подписки меняются под блокировкой сервера
+type marketStream struct {
+	send       chan *proto.MarketDataResponse
+	done       chan struct{}
+	closeOnce  sync.Once
+	candles    map[candleKey]bool
+	orderBooks map[orderBookKey]bool
+	trades     map[string]bool
+	lastPrices map[string]bool
+	infos      map[string]bool
+}
+
+func newMarketStream() *marketStream {
+	return &marketStream{
+		send:       make(chan *proto.MarketDataResponse, streamSendCapacity),
+		done:       make(chan struct{}),
+		candles:    make(map[candleKey]bool),
+		orderBooks: make(map[orderBookKey]bool),
+		trades:     make(map[string]bool),
+		lastPrices: make(map[string]bool),
+		infos:      make(map[string]bool),
+	}
+}
+
+// разрыв потока
+func (ms *marketStream) close() {
+	ms.closeOnce.Do(func() { close(ms.done) })
+}
+
+// ставит сообщение в очередь потока. Если клиент не успевает читать, сообщение пропускается
+func (ms *marketStream) push(resp *proto.MarketDataResponse) {
+	select {
+	case ms.send <- resp:
+	default:
+		l.Warn("fake: переполнена очередь потока рыночных данных, сообщение пропущено")
+	}
+}
+
+type marketDataStreamService struct {
+	proto.UnimplementedMarketDataStreamServiceServer
+	s *Server
+}
+
+func (m *marketDataStreamService) MarketDataStream(stream proto.MarketDataStreamService_MarketDataStreamServer) error {
+	s := m.s
+	ms := newMarketStream()
+	s.locker.Lock()
+	if len(s.marketStreams) >= streamLimit {
+		s.locker.Unlock()
+		stream.SetTrailer(metadata.Pairs("message", "Stream limit exceeded"))
+		return status.Error(codes.ResourceExhausted, "80001")
+	}
+	s.marketStreams[ms] = true
+	s.locker.Unlock()
+	defer func() {
+		s.locker.Lock()
+		delete(s.marketStreams, ms)
+		s.locker.Unlock()
+	}()
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			s.onMarketDataRequest(ms, req)
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case resp := <-ms.send:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-ping.C:
+			err := stream.Send(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_Ping{
+				Ping: &proto.Ping{Time: timestamppb.Now()},
+			}})
+			if err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-ms.done:
+			stream.SetTrailer(metadata.Pairs("message", "Stream is broken"))
+			return status.Error(codes.Unavailable, "70002")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// обрабатывает запрос подписки: меняет подписки потока и отправляет ответ со статусами
+func (s *Server) onMarketDataRequest(ms *marketStream, req *proto.MarketDataRequest) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	now := time.Now().UTC()
+	switch {
+	case req.GetSubscribeCandlesRequest() != nil:
+		r := req.GetSubscribeCandlesRequest()
+		resp := &proto.SubscribeCandlesResponse{TrackingId: s.nextId("tracking")}
+		for _, sub := range r.Instruments {
+			key := candleKey{figi: sub.Figi, interval: sub.Interval}
+			st := s.subscriptionStatus(sub.Figi, r.SubscriptionAction)
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS && alex.SubscriptionInterval2Duration(sub.Interval) == 0 {
+				st = proto.SubscriptionStatus_SUBSCRIPTION_STATUS_INTERVAL_IS_INVALID
+			}
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				setSubscription(ms.candles, key, r.SubscriptionAction)
+			}
+			resp.CandlesSubscriptions = append(resp.CandlesSubscriptions, &proto.CandleSubscription{
+				Figi: sub.Figi, Interval: sub.Interval, SubscriptionStatus: st,
+			})
+		}
+		ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_SubscribeCandlesResponse{SubscribeCandlesResponse: resp}})
+	case req.GetSubscribeOrderBookRequest() != nil:
+		r := req.GetSubscribeOrderBookRequest()
+		resp := &proto.SubscribeOrderBookResponse{TrackingId: s.nextId("tracking")}
+		var snapshots []*proto.MarketDataResponse
+		for _, sub := range r.Instruments {
+			key := orderBookKey{figi: sub.Figi, depth: sub.Depth}
+			st := s.subscriptionStatus(sub.Figi, r.SubscriptionAction)
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS && (sub.Depth <= 0 || sub.Depth > maxOrderBookDepth) {
+				st = proto.SubscriptionStatus_SUBSCRIPTION_STATUS_DEPTH_IS_INVALID
+			}
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				setSubscription(ms.orderBooks, key, r.SubscriptionAction)
+				if r.SubscriptionAction == proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+					snapshots = append(snapshots, orderBookResponse(s.instruments[sub.Figi], sub.Depth, now))
+				}
+			}
+			resp.OrderBookSubscriptions = append(resp.OrderBookSubscriptions, &proto.OrderBookSubscription{
+				Figi: sub.Figi, Depth: sub.Depth, SubscriptionStatus: st,
+			})
+		}
+		ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_SubscribeOrderBookResponse{SubscribeOrderBookResponse: resp}})
+		for _, snapshot := range snapshots {
+			ms.push(snapshot)
+		}
+	case req.GetSubscribeTradesRequest() != nil:
+		r := req.GetSubscribeTradesRequest()
+		resp := &proto.SubscribeTradesResponse{TrackingId: s.nextId("tracking")}
+		for _, sub := range r.Instruments {
+			st := s.subscriptionStatus(sub.Figi, r.SubscriptionAction)
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				setSubscription(ms.trades, sub.Figi, r.SubscriptionAction)
+			}
+			resp.TradeSubscriptions = append(resp.TradeSubscriptions, &proto.TradeSubscription{Figi: sub.Figi, SubscriptionStatus: st})
+		}
+		ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_SubscribeTradesResponse{SubscribeTradesResponse: resp}})
+	case req.GetSubscribeLastPriceRequest() != nil:
+		r := req.GetSubscribeLastPriceRequest()
+		resp := &proto.SubscribeLastPriceResponse{TrackingId: s.nextId("tracking")}
+		for _, sub := range r.Instruments {
+			st := s.subscriptionStatus(sub.Figi, r.SubscriptionAction)
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				setSubscription(ms.lastPrices, sub.Figi, r.SubscriptionAction)
+			}
+			resp.LastPriceSubscriptions = append(resp.LastPriceSubscriptions, &proto.LastPriceSubscription{Figi: sub.Figi, SubscriptionStatus: st})
+		}
+		ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_SubscribeLastPriceResponse{SubscribeLastPriceResponse: resp}})
+	case req.GetSubscribeInfoRequest() != nil:
+		r := req.GetSubscribeInfoRequest()
+		resp := &proto.SubscribeInfoResponse{TrackingId: s.nextId("tracking")}
+		var snapshots []*proto.MarketDataResponse
+		for _, sub := range r.Instruments {
+			st := s.subscriptionStatus(sub.Figi, r.SubscriptionAction)
+			if st == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+				setSubscription(ms.infos, sub.Figi, r.SubscriptionAction)
+				if r.SubscriptionAction == proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+					snapshots = append(snapshots, &proto.MarketDataResponse{Payload: &proto.MarketDataResponse_TradingStatus{
+						TradingStatus: s.instruments[sub.Figi].tradingStatus(now),
+					}})
+				}
+			}
+			resp.InfoSubscriptions = append(resp.InfoSubscriptions, &proto.InfoSubscription{Figi: sub.Figi, SubscriptionStatus: st})
+		}
+		ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_SubscribeInfoResponse{SubscribeInfoResponse: resp}})
+		for _, snapshot := range snapshots {
+			ms.push(snapshot)
+		}
+	default:
+		l.Warn("fake: неизвестный запрос в потоке рыночных данных", zap.Any("request", req))
+	}
+}
+
+// статус подписки на инструмент. Вызывается под блокировкой
+func (s *Server) subscriptionStatus(figi string, action proto.SubscriptionAction) proto.SubscriptionStatus {
+	if _, ok := s.instruments[figi]; !ok {
+		return proto.SubscriptionStatus_SUBSCRIPTION_STATUS_INSTRUMENT_NOT_FOUND
+	}
+	if action != proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE && action != proto.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE {
+		return proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUBSCRIPTION_ACTION_IS_INVALID
+	}
+	return proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS
+}
+
+func setSubscription[K comparable](subscriptions map[K]bool, key K, action proto.SubscriptionAction) {
+	if action == proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE {
+		subscriptions[key] = true
+	} else {
+		delete(subscriptions, key)
+	}
+}
+
+func orderBookResponse(i *instrument, depth int32, now time.Time) *proto.MarketDataResponse {
+	bids, asks := i.orderBook(depth)
+	return &proto.MarketDataResponse{Payload: &proto.MarketDataResponse_Orderbook{Orderbook: &proto.OrderBook{
+		Figi:         i.Figi,
+		Depth:        depth,
+		IsConsistent: true,
+		Bids:         bids,
+		Asks:         asks,
+		Time:         timestamppb.New(now),
+	}}}
+}
+
+// рассылает изменение цены подписчикам. Вызывается под блокировкой
+func (s *Server) broadcastPrice(i *instrument, trade *proto.Trade, now time.Time) {
+	for ms := range s.marketStreams {
+		for key := range ms.candles {
+			if key.figi != i.Figi {
+				continue
+			}
+			if c := i.streamCandle(key.interval, now); c != nil {
+				ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_Candle{Candle: c}})
+			}
+		}
+		if ms.trades[i.Figi] {
+			ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_Trade{Trade: trade}})
+		}
+		if ms.lastPrices[i.Figi] {
+			ms.push(&proto.MarketDataResponse{Payload: &proto.MarketDataResponse_LastPrice{LastPrice: i.lastPrice()}})
+		}
+		for key := range ms.orderBooks {
+			if key.figi == i.Figi {
+				ms.push(orderBookResponse(i, key.depth, now))
+			}
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package fake
+
+// Движок исполнения заявок fake сервера.
+// Рыночная заявка исполняется сразу по лучшей цене стакана. Лимитная заявка исполняется сразу, если её цена
+// не хуже лучшей цены стакана, иначе остаётся активной и исполняется по своей цене, когда цена инструмента её
+// достигнет. Заявки исполняются целиком, комиссия не взимается. Под активную заявку на покупку блокируются
+// деньги, под заявку на продажу - бумаги. Продажа бумаг, которых нет на счёте, отклоняется.
+// Заявка с уже известным идентификатором не создаётся повторно: возвращается её текущее состояние
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+type order struct {
+	id            string
+	figi          string
+	direction     proto.OrderDirection
+	orderType     proto.OrderType
+	lots          int64
+	price         big.Decimal // цена лимитной заявки за 1 инструмент
+	blocked       big.Decimal // деньги, заблокированные под заявку на покупку
+	status        proto.OrderExecutionReportStatus
+	executedPrice big.Decimal // цена исполнения за 1 инструмент
+	tradeId       string
+	created       time.Time
+}
+
+func (o *order) isActive() bool {
+	return o.status == proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW
+}
+
+func (o *order) money(i *instrument, price big.Decimal) *proto.MoneyValue {
+	if price.NaN() {
+		price = big.ZERO
+	}
+	return alex.NewMoneyValue(&alex.Money{
+		Currency: i.Currency,
+		Value:    price.Mul(big.NewFromInt(int(o.lots * int64(i.Lot)))),
+	})
+}
+
+func (o *order) initialPrice() big.Decimal {
+	if o.orderType == proto.OrderType_ORDER_TYPE_MARKET {
+		return o.executedPrice
+	}
+	return o.price
+}
+
+func (o *order) lotsExecuted() int64 {
+	if o.status == proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL {
+		return o.lots
+	}
+	return 0
+}
+
+func (o *order) postResponse(i *instrument) *proto.PostOrderResponse {
+	return &proto.PostOrderResponse{
+		OrderId:               o.id,
+		ExecutionReportStatus: o.status,
+		LotsRequested:         o.lots,
+		LotsExecuted:          o.lotsExecuted(),
+		InitialOrderPrice:     o.money(i, o.initialPrice()),
+		ExecutedOrderPrice:    o.money(i, o.executedPrice),
+		TotalOrderAmount:      o.money(i, o.initialPrice()),
+		InitialCommission:     o.money(i, big.ZERO),
+		ExecutedCommission:    o.money(i, big.ZERO),
+		Figi:                  o.figi,
+		Direction:             o.direction,
+		InitialSecurityPrice:  alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: o.initialPrice()}),
+		OrderType:             o.orderType,
+		InitialOrderPricePt:   alex.NewQuotation(o.initialPrice()),
+	}
+}
+
+func (o *order) state(i *instrument) *proto.OrderState {
+	state := &proto.OrderState{
+		OrderId:               o.id,
+		ExecutionReportStatus: o.status,
+		LotsRequested:         o.lots,
+		LotsExecuted:          o.lotsExecuted(),
+		InitialOrderPrice:     o.money(i, o.initialPrice()),
+		ExecutedOrderPrice:    o.money(i, o.executedPrice),
+		TotalOrderAmount:      o.money(i, o.initialPrice()),
+		InitialCommission:     o.money(i, big.ZERO),
+		ExecutedCommission:    o.money(i, big.ZERO),
+		ServiceCommission:     o.money(i, big.ZERO),
+		Figi:                  o.figi,
+		Direction:             o.direction,
+		InitialSecurityPrice:  alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: o.initialPrice()}),
+		Currency:              i.Currency,
+		OrderType:             o.orderType,
+		OrderDate:             timestamppb.New(o.created),
+	}
+	if o.tradeId != "" {
+		state.AveragePositionPrice = alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: o.executedPrice})
+		state.Stages = []*proto.OrderStage{{
+			Price:    alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: o.executedPrice}),
+			Quantity: o.lots,
+			TradeId:  o.tradeId,
+		}}
+	}
+	return state
+}
+
+// выставляет заявку. Вызывается из OrdersService и SandboxService
+func (s *Server) postOrder(ctx context.Context, req *proto.PostOrderRequest, sandbox bool) (*proto.PostOrderResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	if o, ok := a.orders[req.OrderId]; ok {
+		l.Debug("fake: повторная заявка", zap.String("orderId", req.OrderId))
+		return o.postResponse(s.instruments[o.figi]), nil
+	}
+	i, err := s.instrument(ctx, req.Figi)
+	if err != nil {
+		return nil, err
+	}
+	if req.Quantity <= 0 {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Quantity must be positive")
+	}
+	if i.status != proto.SecurityTradingStatus_SECURITY_TRADING_STATUS_NORMAL_TRADING {
+		return nil, apiError(ctx, codes.FailedPrecondition, "", "Instrument is not available for trading")
+	}
+	now := time.Now().UTC()
+	o := &order{
+		id:            req.OrderId,
+		figi:          i.Figi,
+		direction:     req.Direction,
+		orderType:     req.OrderType,
+		lots:          req.Quantity,
+		price:         alex.NewDecimal(req.Price),
+		blocked:       big.ZERO,
+		status:        proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_NEW,
+		executedPrice: big.NaN,
+		created:       now,
+	}
+	if o.id == "" {
+		o.id = s.nextId("order")
+	}
+	pieces := o.lots * int64(i.Lot)
+	buy := o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY
+	if !buy && o.direction != proto.OrderDirection_ORDER_DIRECTION_SELL {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid order direction")
+	}
+
+	// цена, по которой заявка исполнится сразу, или NaN, если заявка останется активной
+	fillPrice := i.bestBid()
+	if buy {
+		fillPrice = i.bestAsk()
+	}
+	switch o.orderType {
+	case proto.OrderType_ORDER_TYPE_MARKET:
+		o.price = big.NaN
+	case proto.OrderType_ORDER_TYPE_LIMIT:
+		if o.price.NaN() || o.price.LTE(big.ZERO) {
+			return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid order price")
+		}
+		if (buy && o.price.LT(fillPrice)) || (!buy && o.price.GT(fillPrice)) {
+			fillPrice = big.NaN
+		}
+	default:
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Invalid order type")
+	}
+
+	if buy {
+		reserve := o.price
+		if reserve.NaN() {
+			reserve = fillPrice
+		}
+		amount := reserve.Mul(big.NewFromInt(int(pieces)))
+		if a.moneyOf(i.Currency).LT(amount) {
+			return nil, apiError(ctx, codes.InvalidArgument, codeNotEnoughBalance, "Not enough balance")
+		}
+		a.money[i.Currency] = a.moneyOf(i.Currency).Sub(amount)
+		a.blockedMoney[i.Currency] = a.blockedMoneyOf(i.Currency).Add(amount)
+		o.blocked = amount
+	} else {
+		if a.securities[i.Figi] < pieces {
+			return nil, apiError(ctx, codes.InvalidArgument, codeNotEnoughAssets, "Not enough assets")
+		}
+		a.securities[i.Figi] -= pieces
+		a.blockedSecurities[i.Figi] += pieces
+	}
+	a.orders[o.id] = o
+	l.Info("fake: заявка", zap.String("account", a.id), zap.String("orderId", o.id), zap.String("figi", o.figi), zap.Stringer("direction", o.direction), zap.Int64("lots", o.lots))
+	if !fillPrice.NaN() {
+		s.fill(a, o, i, fillPrice, now)
+	}
+	return o.postResponse(i), nil
+}
+
+// исполняет заявку по цене price. Вызывается под блокировкой
+func (s *Server) fill(a *account, o *order, i *instrument, price big.Decimal, now time.Time) {
+	pieces := o.lots * int64(i.Lot)
+	amount := price.Mul(big.NewFromInt(int(pieces)))
+	operation := &proto.Operation{
+		Id:             s.nextId("operation"),
+		Currency:       i.Currency,
+		Price:          alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: price}),
+		State:          proto.OperationState_OPERATION_STATE_EXECUTED,
+		Quantity:       pieces,
+		Figi:           i.Figi,
+		InstrumentType: i.Type.String(),
+		Date:           timestamppb.New(now),
+	}
+	if o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY {
+		a.blockedMoney[i.Currency] = a.blockedMoneyOf(i.Currency).Sub(o.blocked)
+		a.money[i.Currency] = a.moneyOf(i.Currency).Add(o.blocked).Sub(amount)
+		held := a.securities[i.Figi] + a.blockedSecurities[i.Figi]
+		average, ok := a.averagePrice[i.Figi]
+		if !ok || held == 0 {
+			average = big.ZERO
+		}
+		a.averagePrice[i.Figi] = average.Mul(big.NewFromInt(int(held))).Add(amount).Div(big.NewFromInt(int(held + pieces)))
+		a.securities[i.Figi] += pieces
+		operation.Payment = alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: amount.Neg()})
+		operation.Type = "Покупка ценных бумаг"
+		operation.OperationType = proto.OperationType_OPERATION_TYPE_BUY
+	} else {
+		a.blockedSecurities[i.Figi] -= pieces
+		a.money[i.Currency] = a.moneyOf(i.Currency).Add(amount)
+		if a.securities[i.Figi]+a.blockedSecurities[i.Figi] == 0 {
+			delete(a.averagePrice, i.Figi)
+		}
+		operation.Payment = alex.NewMoneyValue(&alex.Money{Currency: i.Currency, Value: amount})
+		operation.Type = "Продажа ценных бумаг"
+		operation.OperationType = proto.OperationType_OPERATION_TYPE_SELL
+	}
+	o.blocked = big.ZERO
+	o.status = proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL
+	o.executedPrice = price
+	o.tradeId = s.nextId("trade")
+	operation.Trades = []*proto.OperationTrade{{
+		TradeId:  o.tradeId,
+		DateTime: timestamppb.New(now),
+		Quantity: pieces,
+		Price:    operation.Price,
+	}}
+	a.operations = append(a.operations, operation)
+	l.Info("fake: заявка исполнена", zap.String("account", a.id), zap.String("orderId", o.id), zap.String("price", price.String()))
+
+	orderTrades := &proto.OrderTrades{
+		OrderId:   o.id,
+		CreatedAt: timestamppb.New(now),
+		Direction: o.direction,
+		Figi:      o.figi,
+		Trades: []*proto.OrderTrade{{
+			DateTime: timestamppb.New(now),
+			Price:    alex.NewQuotation(price),
+			Quantity: o.lots,
+		}},
+		AccountId: a.id,
+	}
+	for ts := range s.tradeStreams {
+		if ts.accounts[a.id] {
+			ts.push(&proto.TradesStreamResponse{Payload: &proto.TradesStreamResponse_OrderTrades{OrderTrades: orderTrades}})
+		}
+	}
+}
+
+// исполняет активные заявки, цена которых достигнута. Вызывается под блокировкой
+func (s *Server) matchOrders(i *instrument, now time.Time) {
+	ids := make([]string, 0, len(s.accounts))
+	for id := range s.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		a := s.accounts[id]
+		for _, o := range a.activeOrders() {
+			if o.figi != i.Figi {
+				continue
+			}
+			if (o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY && i.bestAsk().LTE(o.price)) ||
+				(o.direction == proto.OrderDirection_ORDER_DIRECTION_SELL && i.bestBid().GTE(o.price)) {
+				s.fill(a, o, i, o.price, now)
+			}
+		}
+	}
+}
+
+func (s *Server) cancelOrder(ctx context.Context, req *proto.CancelOrderRequest, sandbox bool) (*proto.CancelOrderResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := a.orders[req.OrderId]
+	if !ok {
+		return nil, apiError(ctx, codes.NotFound, "", "Order not found")
+	}
+	if !o.isActive() {
+		return nil, apiError(ctx, codes.InvalidArgument, "", "Order is not active")
+	}
+	i := s.instruments[o.figi]
+	if o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY {
+		a.blockedMoney[i.Currency] = a.blockedMoneyOf(i.Currency).Sub(o.blocked)
+		a.money[i.Currency] = a.moneyOf(i.Currency).Add(o.blocked)
+		o.blocked = big.ZERO
+	} else {
+		pieces := o.lots * int64(i.Lot)
+		a.blockedSecurities[i.Figi] -= pieces
+		a.securities[i.Figi] += pieces
+	}
+	o.status = proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_CANCELLED
+	return &proto.CancelOrderResponse{Time: timestamppb.Now()}, nil
+}
+
+func (s *Server) getOrderState(ctx context.Context, req *proto.GetOrderStateRequest, sandbox bool) (*proto.OrderState, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	o, ok := a.orders[req.OrderId]
+	if !ok {
+		return nil, apiError(ctx, codes.NotFound, "", "Order not found")
+	}
+	return o.state(s.instruments[o.figi]), nil
+}
+
+func (s *Server) getOrders(ctx context.Context, req *proto.GetOrdersRequest, sandbox bool) (*proto.GetOrdersResponse, error) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	a, err := s.account(ctx, req.AccountId, sandbox)
+	if err != nil {
+		return nil, err
+	}
+	resp := &proto.GetOrdersResponse{}
+	for _, o := range a.activeOrders() {
+		resp.Orders = append(resp.Orders, o.state(s.instruments[o.figi]))
+	}
+	return resp, nil
+}
+
+type ordersService struct {
+	proto.UnimplementedOrdersServiceServer
+	s *Server
+}
+
+func (o *ordersService) PostOrder(ctx context.Context, req *proto.PostOrderRequest) (*proto.PostOrderResponse, error) {
+	return o.s.postOrder(ctx, req, false)
+}
+
+func (o *ordersService) CancelOrder(ctx context.Context, req *proto.CancelOrderRequest) (*proto.CancelOrderResponse, error) {
+	return o.s.cancelOrder(ctx, req, false)
+}
+
+func (o *ordersService) GetOrderState(ctx context.Context, req *proto.GetOrderStateRequest) (*proto.OrderState, error) {
+	return o.s.getOrderState(ctx, req, false)
+}
+
+func (o *ordersService) GetOrders(ctx context.Context, req *proto.GetOrdersRequest) (*proto.GetOrdersResponse, error) {
+	return o.s.getOrders(ctx, req, false)
+}
+
+// открытый поток сделок по заявкам
+type tradeStream struct {
+	accounts  map[string]bool
+	send      chan *proto.TradesStreamResponse
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (ts *tradeStream) close() {
+	ts.closeOnce.Do(func() { close(ts.done) })
+}
+
+func (ts *tradeStream) push(resp *proto.TradesStreamResponse) {
+	select {
+	case ts.send <- resp:
+	default:
+		l.Warn("fake: переполнена очередь потока сделок, сообщение пропущено")
+	}
+}
+
+type ordersStreamService struct {
+	proto.UnimplementedOrdersStreamServiceServer
+	s *Server
+}
+
+func (o *ordersStreamService) TradesStream(req *proto.TradesStreamRequest, stream proto.OrdersStreamService_TradesStreamServer) error {
+	s := o.s
+	ts := &tradeStream{
+		accounts: make(map[string]bool),
+		send:     make(chan *proto.TradesStreamResponse, streamSendCapacity),
+		done:     make(chan struct{}),
+	}
+	for _, id := range req.Accounts {
+		ts.accounts[id] = true
+	}
+	s.locker.Lock()
+	if len(s.tradeStreams) >= streamLimit {
+		s.locker.Unlock()
+		stream.SetTrailer(metadata.Pairs("message", "Stream limit exceeded"))
+		return status.Error(codes.ResourceExhausted, "80001")
+	}
+	s.tradeStreams[ts] = true
+	s.locker.Unlock()
+	defer func() {
+		s.locker.Lock()
+		delete(s.tradeStreams, ts)
+		s.locker.Unlock()
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case resp := <-ts.send:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-ping.C:
+			err := stream.Send(&proto.TradesStreamResponse{Payload: &proto.TradesStreamResponse_Ping{
+				Ping: &proto.Ping{Time: timestamppb.Now()},
+			}})
+			if err != nil {
+				return err
+			}
+		case <-ts.done:
+			stream.SetTrailer(metadata.Pairs("message", "Stream is broken"))
+			return status.Error(codes.Unavailable, "70002")
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
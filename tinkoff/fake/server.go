@@ -0,0 +1,259 @@
+package fake
+
+// Локальный fake сервер Tinkoff Invest API для интеграционных тестов.
+// Сервер реализует сервисы users, instruments, market data, market data stream, orders, orders stream, operations
+// и sandbox, и хранит всё состояние в памяти. Цены инструментов задаются вызовами SetPrice или сценарием
+// (PlayPrices, RandomWalk), стакан строится вокруг последней цены, заявки исполняются простым движком (см. orders.go).
+// Ошибки сервера и задержки ответов задаются через InjectFault, разрыв потоков - через BreakStreams.
+// Сервер запускается командой alex fake-server или в тестах через ServeBufconn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const methodPrefix = "/tinkoff.public.invest.api.contract.v1."
+
+// коды ошибок tinkoff, которые возвращает сервер
+const (
+	codeInstrumentNotFound = "50001"
+	codeNotEnoughBalance   = "30034"
+	codeNotEnoughAssets    = "30042"
+)
+
+// ошибка, которую сервер вернёт на запросы метода
+type Fault struct {
+	Method      string        // метод без пакета, например "OrdersService/PostOrder". Пусто - любой метод
+	Code        codes.Code    // gRPC код ошибки. codes.OK - только задержка ответа
+	TinkoffCode string        // код ошибки tinkoff, передаётся в сообщении статуса, например "80002"
+	Message     string        // описание ошибки, передаётся в метаданных message
+	Count       int           // сколько запросов завершить ошибкой, 0 - пока ошибка не будет убрана
+	Lost        bool          // запрос выполняется, но ответ теряется и клиент получает ошибку
+	Delay       time.Duration // задержка перед обработкой запроса
+}
+
+type fault struct {
+	Fault
+	remaining int
+}
+
+type Server struct {
+	locker        sync.Mutex
+	instruments   map[string]*instrument // figi -> инструмент
+	figis         []string               // figi в порядке добавления
+	accounts      map[string]*account
+	faults        []*fault
+	marketStreams map[*marketStream]bool
+	tradeStreams  map[*tradeStream]bool
+	lastId        int64
+	grpcServer    *grpc.Server
+	listener      *bufconn.Listener
+}
+
+// сервер с инструментами по умолчанию: акция SBER и валюта USD, обе торгуются в рублях
+func New() *Server {
+	s := NewEmpty()
+	s.AddInstrument(Instrument{
+		Figi:              "BBG004730N88",
+		Ticker:            "SBER",
+		ClassCode:         "TQBR",
+		Name:              "Сбер Банк",
+		Type:              InstrumentType_SHARE,
+		Currency:          "rub",
+		Lot:               10,
+		MinPriceIncrement: big.NewFromString("0.01"),
+		Price:             big.NewFromString("130"),
+	})
+	s.AddInstrument(Instrument{
+		Figi:              "BBG0013HGFT4",
+		Ticker:            "USD000UTSTOM",
+		ClassCode:         "CETS",
+		Name:              "Доллар США",
+		Type:              InstrumentType_CURRENCY,
+		Currency:          "rub",
+		Lot:               1000,
+		MinPriceIncrement: big.NewFromString("0.0025"),
+		Price:             big.NewFromString("60"),
+	})
+	return s
+}
+
+// сервер без инструментов и счетов
+func NewEmpty() *Server {
+	return &Server{
+		instruments:   make(map[string]*instrument),
+		accounts:      make(map[string]*account),
+		marketStreams: make(map[*marketStream]bool),
+		tradeStreams:  make(map[*tradeStream]bool),
+	}
+}
+
+// регистрирует сервисы в grpc сервере
+func (s *Server) Register(grpcServer *grpc.Server) {
+	proto.RegisterUsersServiceServer(grpcServer, &usersService{s: s})
+	proto.RegisterInstrumentsServiceServer(grpcServer, &instrumentsService{s: s})
+	proto.RegisterMarketDataServiceServer(grpcServer, &marketDataService{s: s})
+	proto.RegisterMarketDataStreamServiceServer(grpcServer, &marketDataStreamService{s: s})
+	proto.RegisterOrdersServiceServer(grpcServer, &ordersService{s: s})
+	proto.RegisterOrdersStreamServiceServer(grpcServer, &ordersStreamService{s: s})
+	proto.RegisterOperationsServiceServer(grpcServer, &operationsService{s: s})
+	proto.RegisterSandboxServiceServer(grpcServer, &sandboxService{s: s})
+}
+
+// grpc сервер с сервисами и перехватчиками для внедрения ошибок
+func (s *Server) NewGRPCServer() *grpc.Server {
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	)
+	s.Register(grpcServer)
+	return grpcServer
+}
+
+// обслуживает соединения, пока сервер не будет остановлен
+func (s *Server) Serve(listener net.Listener) error {
+	s.locker.Lock()
+	if s.grpcServer == nil {
+		s.grpcServer = s.NewGRPCServer()
+	}
+	grpcServer := s.grpcServer
+	s.locker.Unlock()
+	return grpcServer.Serve(listener)
+}
+
+func (s *Server) Stop() {
+	s.locker.Lock()
+	grpcServer := s.grpcServer
+	s.grpcServer = nil
+	s.locker.Unlock()
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+}
+
+// задаёт ошибку, которую сервер будет возвращать
+func (s *Server) InjectFault(f Fault) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.faults = append(s.faults, &fault{Fault: f, remaining: f.Count})
+}
+
+// убирает все заданные ошибки
+func (s *Server) ClearFaults() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	s.faults = nil
+}
+
+// разрывает все открытые потоки рыночных данных и сделок, клиент должен переподключиться
+func (s *Server) BreakStreams() {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for ms := range s.marketStreams {
+		ms.close()
+	}
+	for ts := range s.tradeStreams {
+		ts.close()
+	}
+	s.marketStreams = make(map[*marketStream]bool)
+	s.tradeStreams = make(map[*tradeStream]bool)
+}
+
+// ошибка для запроса метода method, если она задана
+func (s *Server) takeFault(method string) *Fault {
+	method = strings.TrimPrefix(method, methodPrefix)
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for i, f := range s.faults {
+		if f.Method != "" && f.Method != method {
+			continue
+		}
+		if f.Count > 0 {
+			f.remaining--
+			if f.remaining <= 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		result := f.Fault
+		return &result
+	}
+	return nil
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	f := s.takeFault(info.FullMethod)
+	if f == nil {
+		return handler(ctx, req)
+	}
+	if err := sleep(ctx, f.Delay); err != nil {
+		return nil, err
+	}
+	if f.Code == codes.OK {
+		return handler(ctx, req)
+	}
+	if f.Lost {
+		if _, err := handler(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	l.Info("fake: ошибка по сценарию", zap.String("method", info.FullMethod), zap.Stringer("code", f.Code))
+	grpc.SetTrailer(ctx, metadata.Pairs("message", f.Message)) //nolint:golint,errcheck
+	return nil, status.Error(f.Code, f.TinkoffCode)
+}
+
+func (s *Server) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	f := s.takeFault(info.FullMethod)
+	if f == nil {
+		return handler(srv, ss)
+	}
+	if err := sleep(ss.Context(), f.Delay); err != nil {
+		return err
+	}
+	if f.Code == codes.OK {
+		return handler(srv, ss)
+	}
+	l.Info("fake: ошибка по сценарию", zap.String("method", info.FullMethod), zap.Stringer("code", f.Code))
+	ss.SetTrailer(metadata.Pairs("message", f.Message))
+	return status.Error(f.Code, f.TinkoffCode)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// ошибка api, как её возвращает сервер брокера: код tinkoff в сообщении статуса, описание в метаданных message
+func apiError(ctx context.Context, code codes.Code, tinkoffCode string, message string) error {
+	grpc.SetTrailer(ctx, metadata.Pairs("message", message)) //nolint:golint,errcheck
+	if tinkoffCode == "" {
+		tinkoffCode = message
+	}
+	return status.Error(code, tinkoffCode)
+}
+
+// новый идентификатор сделки или операции. Вызывается под блокировкой
+func (s *Server) nextId(prefix string) string {
+	s.lastId++
+	return fmt.Sprintf("%s-%d", prefix, s.lastId)
+}
@@ -0,0 +1,145 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+	"google.golang.org/grpc/codes"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const (
+	testAccount = "test"
+	testFigi    = "BBG004730N88" // SBER, лот 10, цена 130
+)
+
+// сервер со счётом testAccount и открытый клиент к нему
+func openTestClient(t *testing.T) (*Server, *tinkoff.Client, context.Context) {
+	t.Helper()
+	server := New()
+	server.AddAccount(testAccount, "тестовый счёт", big.NewFromInt(100000))
+	server.ServeBufconn()
+	t.Cleanup(server.Stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	client := server.Client(t.TempDir())
+	if err := client.Open(ctx); err != nil {
+		t.Fatal("Open:", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return server, client, ctx
+}
+
+func postMarketBuy(ctx context.Context, t *testing.T, client *tinkoff.Client, lots int64) (alex.Order, error) {
+	t.Helper()
+	account, err := client.Accounts.Get(ctx, testAccount)
+	if err != nil {
+		t.Fatal("Accounts.Get:", err)
+	}
+	return account.PostOrder(ctx,
+		client.GetInstrument(testFigi),
+		lots,
+		big.NewFromInt(130),
+		proto.OrderDirection_ORDER_DIRECTION_BUY,
+		proto.OrderType_ORDER_TYPE_MARKET,
+		uuid.NewString(),
+	)
+}
+
+func TestPostOrderAndPositions(t *testing.T) {
+	_, client, ctx := openTestClient(t)
+
+	order, err := postMarketBuy(ctx, t, client, 2)
+	if err != nil {
+		t.Fatal("PostOrder:", err)
+	}
+	if order.GetExecutionReportStatus() != proto.OrderExecutionReportStatus_EXECUTION_REPORT_STATUS_FILL {
+		t.Fatalf("заявка не исполнена: %v", order.GetExecutionReportStatus())
+	}
+	if order.GetLotsExecuted() != 2 {
+		t.Fatalf("исполнено %d лотов, ожидалось 2", order.GetLotsExecuted())
+	}
+
+	account, err := client.Accounts.Get(ctx, testAccount)
+	if err != nil {
+		t.Fatal("Accounts.Get:", err)
+	}
+	positions, err := account.GetPositions(ctx)
+	if err != nil {
+		t.Fatal("GetPositions:", err)
+	}
+	position, ok := positions.Positions[testFigi]
+	if !ok {
+		t.Fatalf("нет позиции по %s: %+v", testFigi, positions.Positions)
+	}
+	if position.GetBalance() != 20 {
+		t.Fatalf("баланс %d, ожидалось 20 бумаг", position.GetBalance())
+	}
+}
+
+// ответ на заявку теряется, клиент узнаёт о ней через GetOrderState и не выставляет её второй раз
+func TestPostOrderLostResponse(t *testing.T) {
+	server, client, ctx := openTestClient(t)
+	server.InjectFault(Fault{
+		Method:      "OrdersService/PostOrder",
+		Code:        codes.Unavailable,
+		TinkoffCode: "80002",
+		Message:     "ответ потерян",
+		Count:       1,
+		Lost:        true,
+	})
+
+	order, err := postMarketBuy(ctx, t, client, 1)
+	if err != nil {
+		t.Fatal("PostOrder:", err)
+	}
+	if order.GetLotsExecuted() != 1 {
+		t.Fatalf("исполнено %d лотов, ожидался 1", order.GetLotsExecuted())
+	}
+
+	account, err := client.Accounts.Get(ctx, testAccount)
+	if err != nil {
+		t.Fatal("Accounts.Get:", err)
+	}
+	positions, err := account.GetPositions(ctx)
+	if err != nil {
+		t.Fatal("GetPositions:", err)
+	}
+	if balance := positions.Positions[testFigi].GetBalance(); balance != 10 {
+		t.Fatalf("баланс %d, ожидалось 10 бумаг: заявка выставлена дважды?", balance)
+	}
+}
+
+// ошибка сервера доходит до клиента как типизированная ошибка api
+func TestFaultInjectionAPIError(t *testing.T) {
+	server, client, ctx := openTestClient(t)
+	server.InjectFault(Fault{
+		Method:      "OrdersService/PostOrder",
+		Code:        codes.InvalidArgument,
+		TinkoffCode: codeNotEnoughBalance,
+		Message:     "Not enough balance",
+		Count:       1,
+	})
+
+	_, err := postMarketBuy(ctx, t, client, 1)
+	var apiErr *alex.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("ожидалась alex.APIError, получено %v", err)
+	}
+	if apiErr.Code != codeNotEnoughBalance || apiErr.IsRetryable() {
+		t.Fatalf("неверная ошибка: %v", apiErr)
+	}
+
+	// ошибка была одна, следующая заявка проходит
+	if _, err := postMarketBuy(ctx, t, client, 1); err != nil {
+		t.Fatal("PostOrder после ошибки:", err)
+	}
+}
@@ -0,0 +1,136 @@
+package fake
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sdcoffey/big"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+// ждёт ответа сервера на подписку
+func waitSubscribed(ctx context.Context, t *testing.T, client *tinkoff.Client, key tinkoff.SubscriptionKey) {
+	t.Helper()
+	for client.GetMarketDataStream().Subscriptions.Status(key) != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+		select {
+		case <-ctx.Done():
+			t.Fatal("нет ответа на подписку:", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// после разрыва потока последняя цена берётся из api, а не из кеша потока
+func TestLastPriceAfterStreamBreak(t *testing.T) {
+	server, client, ctx := openTestClient(t)
+	instrument := client.GetInstrument(testFigi)
+	ch, err := instrument.SubscribeLastPrice()
+	if err != nil {
+		t.Fatal("SubscribeLastPrice:", err)
+	}
+	waitSubscribed(ctx, t, client, tinkoff.LastPriceSubscription(testFigi))
+	if err := server.SetPrice(testFigi, big.NewFromInt(131)); err != nil {
+		t.Fatal("SetPrice:", err)
+	}
+	select {
+	case <-ch:
+	case <-ctx.Done():
+		t.Fatal("нет цены из потока")
+	}
+
+	server.BreakStreams()
+	if err := server.SetPrice(testFigi, big.NewFromInt(140)); err != nil {
+		t.Fatal("SetPrice:", err)
+	}
+	for {
+		prices, err := instrument.GetLastPrices(ctx)
+		if err != nil {
+			t.Fatal("GetLastPrices:", err)
+		}
+		if len(prices) == 1 && prices[0].Price.EQ(big.NewFromInt(140)) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("после разрыва потока цена %s из кеша", prices[0].Price)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// после разрыва потока стакан запрашивается у api
+func TestOrderBookAfterStreamBreak(t *testing.T) {
+	server, client, ctx := openTestClient(t)
+	instrument := client.GetInstrument(testFigi)
+	if _, err := instrument.SubscribeOrderBook(10); err != nil {
+		t.Fatal("SubscribeOrderBook:", err)
+	}
+	waitSubscribed(ctx, t, client, tinkoff.OrderBookSubscription(testFigi, 10))
+	if err := server.SetPrice(testFigi, big.NewFromInt(131)); err != nil {
+		t.Fatal("SetPrice:", err)
+	}
+	// у стакана из потока нет цены последней сделки, у стакана из api она есть
+	isStreamBook := func(ob *alex.OrderBook) bool { return ob.LastPrice.NaN() }
+	for {
+		ob, err := instrument.GetOrderBook(ctx, 10)
+		if err != nil {
+			t.Fatal("GetOrderBook:", err)
+		}
+		if isStreamBook(ob) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("стакан из потока не пришёл")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	server.BreakStreams()
+	for {
+		ob, err := instrument.GetOrderBook(ctx, 10)
+		if err != nil {
+			t.Fatal("GetOrderBook:", err)
+		}
+		if !isStreamBook(ob) {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("после разрыва потока стакан из кеша")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// отклонённая сервером подписка не занимает место в лимите подписок
+func TestRejectedSubscriptionNotCounted(t *testing.T) {
+	_, client, ctx := openTestClient(t)
+	subscriptions := client.GetMarketDataStream().Subscriptions
+	before := subscriptions.Count()
+
+	key := tinkoff.LastPriceSubscription("UNKNOWN")
+	if err := subscriptions.Subscribe(key); err != nil {
+		t.Fatal("Subscribe:", err)
+	}
+	for subscriptions.Status(key) == proto.SubscriptionStatus_SUBSCRIPTION_STATUS_UNSPECIFIED {
+		select {
+		case <-ctx.Done():
+			t.Fatal("нет ответа на подписку:", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if status := subscriptions.Status(key); status != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_INSTRUMENT_NOT_FOUND {
+		t.Fatalf("статус подписки %v", status)
+	}
+	if count := subscriptions.Count(); count != before {
+		t.Fatalf("подписок %d, ожидалось %d", count, before)
+	}
+	if err := subscriptions.Unsubscribe(key); err != nil {
+		t.Fatal("Unsubscribe:", err)
+	}
+}
@@ -0,0 +1,132 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"google.golang.org/grpc/codes"
+
+	"github.com/go-trading/alex"
+	"github.com/go-trading/alex/tinkoff"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+// что клиент увидел за сессию
+type trafficSession struct {
+	portfolio string
+	orderErr  string
+	prices    []string
+}
+
+// сессия: портфель, заявка с ошибкой api и последние цены из потока. setPrices вызывается после подписки
+func runTrafficSession(ctx context.Context, t *testing.T, client *tinkoff.Client, setPrices func()) trafficSession {
+	t.Helper()
+	var session trafficSession
+
+	portfolio, err := client.GetPortfolio(ctx, testAccount)
+	if err != nil {
+		t.Fatal("GetPortfolio:", err)
+	}
+	session.portfolio = portfolio.TotalAmountCurrencies.Value.String() + " " + portfolio.TotalAmountCurrencies.Currency
+
+	_, err = postMarketBuy(ctx, t, client, 1)
+	var apiErr *alex.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("ожидалась alex.APIError, получено %v", err)
+	}
+	session.orderErr = apiErr.Error()
+
+	instrument := client.GetInstrument(testFigi)
+	ch, err := instrument.SubscribeLastPrice()
+	if err != nil {
+		t.Fatal("SubscribeLastPrice:", err)
+	}
+	key := tinkoff.LastPriceSubscription(testFigi)
+	for client.GetMarketDataStream().Subscriptions.Status(key) != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+		select {
+		case <-ctx.Done():
+			t.Fatal("нет ответа на подписку:", ctx.Err())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	setPrices()
+	for len(session.prices) == 0 || session.prices[len(session.prices)-1] != "133" {
+		select {
+		case <-ctx.Done():
+			t.Fatalf("получены не все цены: %v", session.prices)
+		case lastPrice := <-ch:
+			session.prices = append(session.prices, lastPrice.Price.String())
+		}
+	}
+	if err := instrument.UnsubscribeLastPrice(ch); err != nil {
+		t.Fatal("UnsubscribeLastPrice:", err)
+	}
+	return session
+}
+
+// сессия, записанная с фейковым сервером, воспроизводится без сервера с теми же результатами
+func TestTrafficRecordReplay(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "traffic.jsonl")
+
+	server := New()
+	server.AddAccount(testAccount, "тестовый счёт", big.NewFromInt(100000))
+	server.ServeBufconn()
+	defer server.Stop()
+	server.InjectFault(Fault{
+		Method:      "OrdersService/PostOrder",
+		Code:        codes.InvalidArgument,
+		TinkoffCode: codeNotEnoughBalance,
+		Message:     "Not enough balance",
+		Count:       1,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	recording := server.Client(t.TempDir())
+	if err := recording.RecordTraffic(fileName); err != nil {
+		t.Fatal("RecordTraffic:", err)
+	}
+	if err := recording.Open(ctx); err != nil {
+		t.Fatal("Open:", err)
+	}
+	recorded := runTrafficSession(ctx, t, recording, func() {
+		for _, price := range []int{131, 132, 133} {
+			if err := server.SetPrice(testFigi, big.NewFromInt(price)); err != nil {
+				t.Fatal("SetPrice:", err)
+			}
+		}
+	})
+	if err := recording.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	server.Stop()
+
+	replaying := tinkoff.NewClient("nowhere:1", "fake-token", t.TempDir())
+	if err := replaying.ReplayTraffic(fileName, false); err != nil {
+		t.Fatal("ReplayTraffic:", err)
+	}
+	if err := replaying.Open(ctx); err != nil {
+		t.Fatal("Open при воспроизведении:", err)
+	}
+	defer replaying.Close()
+	replayed := runTrafficSession(ctx, t, replaying, func() {})
+
+	if replayed.portfolio != recorded.portfolio {
+		t.Errorf("портфель %s, записан %s", replayed.portfolio, recorded.portfolio)
+	}
+	if replayed.orderErr != recorded.orderErr {
+		t.Errorf("ошибка заявки %q, записана %q", replayed.orderErr, recorded.orderErr)
+	}
+	if len(replayed.prices) != len(recorded.prices) {
+		t.Fatalf("цены %v, записаны %v", replayed.prices, recorded.prices)
+	}
+	for i := range recorded.prices {
+		if replayed.prices[i] != recorded.prices[i] {
+			t.Fatalf("цены %v, записаны %v", replayed.prices, recorded.prices)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tinkoff
+
+// Подписки инструмента на последние цены и обезличенные сделки из потока рыночных данных.
+// Сервер подписывается при появлении первого подписчика и отписывается, когда уходит последний
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-trading/alex"
+)
+
+type lastPriceStream struct {
+	locker      sync.RWMutex
+	last        *alex.LastPrice // последняя цена из потока, пока есть подписчики и поток не прерывался
+	subscribers []alex.LastPriceChan
+}
+
+type tradeStream struct {
+	locker      sync.RWMutex
+	subscribers []alex.TradeChan
+}
+
+func (i *Instrument) SubscribeLastPrice() (alex.LastPriceChan, error) {
+	i.lastPriceStream.locker.Lock()
+	defer i.lastPriceStream.locker.Unlock()
+
+	if len(i.lastPriceStream.subscribers) == 0 {
+		if err := i.client.GetMarketDataStream().Subscriptions.Subscribe(LastPriceSubscription(i.GetFigi())); err != nil {
+			return nil, err
+		}
+	}
+	ch := make(alex.LastPriceChan, 50)
+	i.lastPriceStream.subscribers = append(i.lastPriceStream.subscribers, ch)
+	return ch, nil
+}
+
+func (i *Instrument) UnsubscribeLastPrice(lastPriceChan alex.LastPriceChan) error {
+	i.lastPriceStream.locker.Lock()
+	defer i.lastPriceStream.locker.Unlock()
+
+	for idx, ch := range i.lastPriceStream.subscribers {
+		if ch != lastPriceChan {
+			continue
+		}
+		close(ch)
+		i.lastPriceStream.subscribers = append(i.lastPriceStream.subscribers[:idx], i.lastPriceStream.subscribers[idx+1:]...)
+		if len(i.lastPriceStream.subscribers) > 0 {
+			return nil
+		}
+		// без подписки цена в кеше перестанет обновляться
+		i.lastPriceStream.last = nil
+		return i.client.GetMarketDataStream().Subscriptions.Unsubscribe(LastPriceSubscription(i.GetFigi()))
+	}
+	l.DPanic("отписываюсь от последних цен, хотя не подписывался на них")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+func (i *Instrument) onLastPrice(lastPrice *alex.LastPrice) {
+	i.lastPriceStream.locker.Lock()
+	defer i.lastPriceStream.locker.Unlock()
+
+	if len(i.lastPriceStream.subscribers) == 0 {
+		return
+	}
+	i.lastPriceStream.last = lastPrice
+	for _, ch := range i.lastPriceStream.subscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток обработки последних цен. медленная работа робота? deadlock?")
+		} else {
+			ch <- lastPrice
+		}
+	}
+}
+
+func (i *Instrument) SubscribeTrades() (alex.TradeChan, error) {
+	i.tradeStream.locker.Lock()
+	defer i.tradeStream.locker.Unlock()
+
+	if len(i.tradeStream.subscribers) == 0 {
+		if err := i.client.GetMarketDataStream().Subscriptions.Subscribe(TradesSubscription(i.GetFigi())); err != nil {
+			return nil, err
+		}
+	}
+	ch := make(alex.TradeChan, 100)
+	i.tradeStream.subscribers = append(i.tradeStream.subscribers, ch)
+	return ch, nil
+}
+
+func (i *Instrument) UnsubscribeTrades(tradeChan alex.TradeChan) error {
+	i.tradeStream.locker.Lock()
+	defer i.tradeStream.locker.Unlock()
+
+	for idx, ch := range i.tradeStream.subscribers {
+		if ch != tradeChan {
+			continue
+		}
+		close(ch)
+		i.tradeStream.subscribers = append(i.tradeStream.subscribers[:idx], i.tradeStream.subscribers[idx+1:]...)
+		if len(i.tradeStream.subscribers) > 0 {
+			return nil
+		}
+		return i.client.GetMarketDataStream().Subscriptions.Unsubscribe(TradesSubscription(i.GetFigi()))
+	}
+	l.DPanic("отписываюсь от сделок, хотя не подписывался на них")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+func (i *Instrument) onTrade(trade *alex.Trade) {
+	i.tradeStream.locker.RLock()
+	defer i.tradeStream.locker.RUnlock()
+
+	for _, ch := range i.tradeStream.subscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток обработки сделок. медленная работа робота? deadlock?")
+		} else {
+			ch <- trade
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package tinkoff
+
+// Облигации, фьючерсы и валюты. Это те же инструменты, дополненные информацией, специфичной для их типа.
+// Client.GetInstrument возвращает инструмент уже нужного типа, и его можно привести к alex.BondInstrument,
+// alex.FuturesInstrument или alex.CurrencyInstrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+var _ alex.BondInstrument = (*Bond)(nil)
+var _ alex.FuturesInstrument = (*Future)(nil)
+var _ alex.CurrencyInstrument = (*Currency)(nil)
+
+// у фьючерса в api нет isin, а описание инструмента должно его возвращать
+type futureDescription struct {
+	*proto.Future
+}
+
+func (f futureDescription) GetIsin() string { return "" }
+
+// инструмент нужного типа по описанию из api
+func newTypedInstrument(i *Instrument) alex.Instrument {
+	switch i.InstrumentDescriptionLink.(type) {
+	case *proto.Bond:
+		return &Bond{Instrument: i}
+	case futureDescription:
+		return &Future{Instrument: i}
+	case *proto.Currency:
+		return &Currency{Instrument: i}
+	}
+	return i
+}
+
+type Bond struct {
+	*Instrument
+}
+
+func (b *Bond) description() *proto.Bond {
+	return b.InstrumentDescriptionLink.(*proto.Bond)
+}
+
+func (b *Bond) GetNominal() *alex.Money         { return alex.NewMoney(b.description().Nominal) }
+func (b *Bond) GetAciValue() *alex.Money        { return alex.NewMoney(b.description().AciValue) }
+func (b *Bond) GetCouponQuantityPerYear() int32 { return b.description().CouponQuantityPerYear }
+func (b *Bond) IsFloatingCoupon() bool          { return b.description().FloatingCouponFlag }
+func (b *Bond) IsAmortization() bool            { return b.description().AmortizationFlag }
+func (b *Bond) GetMaturityDate() time.Time      { return asTime(b.description().MaturityDate) }
+
+func (b *Bond) GetCoupons(ctx context.Context, from, to time.Time) ([]*alex.Coupon, error) {
+	resp, err := b.client.instrumentsServiceClient.GetBondCoupons(ctx, &proto.GetBondCouponsRequest{
+		Figi: b.GetFigi(),
+		From: timestamppb.New(from),
+		To:   timestamppb.New(to),
+	})
+	if err != nil {
+		l.Error("GetBondCoupons", zap.String("figi", b.GetFigi()), zap.Error(err))
+		return nil, err
+	}
+	result := make([]*alex.Coupon, len(resp.Events))
+	for i, c := range resp.Events {
+		result[i] = alex.NewCoupon(c)
+	}
+	return result, nil
+}
+
+type Future struct {
+	*Instrument
+}
+
+func (f *Future) description() *proto.Future {
+	return f.InstrumentDescriptionLink.(futureDescription).Future
+}
+
+func (f *Future) GetFirstTradeDate() time.Time { return asTime(f.description().FirstTradeDate) }
+func (f *Future) GetLastTradeDate() time.Time  { return asTime(f.description().LastTradeDate) }
+func (f *Future) GetExpirationDate() time.Time { return asTime(f.description().ExpirationDate) }
+func (f *Future) GetFuturesType() string       { return f.description().FuturesType }
+func (f *Future) GetAssetType() string         { return f.description().AssetType }
+func (f *Future) GetBasicAsset() string        { return f.description().BasicAsset }
+func (f *Future) GetBasicAssetSize() big.Decimal {
+	return alex.NewDecimal(f.description().BasicAssetSize)
+}
+
+func (f *Future) getFuturesMargin(ctx context.Context) (*proto.GetFuturesMarginResponse, error) {
+	resp, err := f.client.instrumentsServiceClient.GetFuturesMargin(ctx, &proto.GetFuturesMarginRequest{
+		Figi: f.GetFigi(),
+	})
+	if err != nil {
+		l.Error("GetFuturesMargin", zap.String("figi", f.GetFigi()), zap.Error(err))
+		return nil, err
+	}
+	return resp, nil
+}
+
+// стоимость пункта - стоимость шага цены, делённая на шаг цены
+func (f *Future) GetPointValue(ctx context.Context) (*alex.Money, error) {
+	margin, err := f.getFuturesMargin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &alex.Money{
+		Currency: f.GetCurrency(),
+		Value:    alex.NewDecimal(margin.MinPriceIncrementAmount).Div(alex.NewDecimal(margin.MinPriceIncrement)),
+	}, nil
+}
+
+func (f *Future) GetInitialMargin(ctx context.Context) (*alex.Margin, error) {
+	margin, err := f.getFuturesMargin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &alex.Margin{
+		OnBuy:  alex.NewMoney(margin.InitialMarginOnBuy),
+		OnSell: alex.NewMoney(margin.InitialMarginOnSell),
+	}, nil
+}
+
+type Currency struct {
+	*Instrument
+}
+
+func (c *Currency) description() *proto.Currency {
+	return c.InstrumentDescriptionLink.(*proto.Currency)
+}
+
+func (c *Currency) GetIsoCurrencyName() string { return c.description().IsoCurrencyName }
+func (c *Currency) GetNominal() *alex.Money    { return alex.NewMoney(c.description().Nominal) }
+
+// время из api, или нулевое время, если оно не задано
+func asTime(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.AsTime()
+}
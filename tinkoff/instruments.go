@@ -2,6 +2,7 @@ package tinkoff
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,23 +37,38 @@ type Instrument struct {
 	allCandlesOfInstrumentLock sync.RWMutex
 	InstrumentDescriptionLink  InstrumentAdditionDescriptionInAPI
 	OrderBookCache             OrderBookCache
+	orderBookStream            orderBookStream
+	lastPriceStream            lastPriceStream
+	tradeStream                tradeStream
+	freshness                  dataFreshness
+	typed                      alex.Instrument // этот же инструмент с информацией, специфичной для типа (облигация, фьючерс, валюта)
 	tradingStatus              proto.SecurityTradingStatus
 	limitOrderAvailable        bool
 	marketOrderAvailable       bool
 }
 
 func NewInstrument(client *Client, instDesc InstrumentAdditionDescriptionInAPI) *Instrument {
-	return &Instrument{
+	i := &Instrument{
 		client:                 client,
 		allCandlesOfInstrument: make(map[time.Duration]*Candles),
 		OrderBookCache: OrderBookCache{
 			LiveTime:   5 * time.Second,
 			OrderBooks: make(map[int32]OrderBookCacheItem),
 		},
+		orderBookStream: orderBookStream{
+			live:        make(map[int32]*alex.OrderBook),
+			subscribers: make(map[int32][]alex.OrderBookChan),
+		},
 		InstrumentDescriptionLink: instDesc,
 	}
+	i.typed = newTypedInstrument(i)
+	return i
 }
 
+// инструмент с информацией, специфичной для его типа. Можно привести к alex.BondInstrument,
+// alex.FuturesInstrument или alex.CurrencyInstrument
+func (i *Instrument) Typed() alex.Instrument { return i.typed }
+
 func (i *Instrument) GetClient() *Client   { return i.client }
 func (i *Instrument) GetFigi() string      { return i.InstrumentDescriptionLink.GetFigi() }
 func (i *Instrument) GetExchange() string  { return i.InstrumentDescriptionLink.GetExchange() }
@@ -132,11 +148,15 @@ type Instruments struct {
 	client      *Client
 	locker      sync.RWMutex
 	instruments map[string]*Instrument
+	byTicker    map[string][]*Instrument // тикер в верхнем регистре -> инструменты с этим тикером во всех классах
+	byIsin      map[string][]*Instrument
 }
 
 func NewInstruments(client *Client) *Instruments {
 	return &Instruments{
 		instruments: make(map[string]*Instrument),
+		byTicker:    make(map[string][]*Instrument),
+		byIsin:      make(map[string][]*Instrument),
 		client:      client,
 	}
 }
@@ -147,28 +167,77 @@ func (ii *Instruments) LoadNew(ctx context.Context) error {
 
 	etfs, err := ii.client.Etfs(ctx, proto.InstrumentStatus_INSTRUMENT_STATUS_ALL)
 	if err != nil {
-		l.DPanic("InitLimits", zap.Error(err))
+		l.Error("Etfs", zap.Error(err))
+		return err
 	}
 	for _, etf := range etfs {
-		_, ok := ii.instruments[etf.Figi]
-		if !ok {
-			ii.instruments[etf.Figi] = NewInstrument(ii.client, etf)
-		}
+		ii.add(etf)
 	}
 
 	shares, err := ii.client.Shares(ctx, proto.InstrumentStatus_INSTRUMENT_STATUS_ALL)
 	if err != nil {
-		l.DPanic("InitLimits", zap.Error(err))
+		l.Error("Shares", zap.Error(err))
+		return err
 	}
 	for _, s := range shares {
-		_, ok := ii.instruments[s.Figi]
-		if !ok {
-			ii.instruments[s.Figi] = NewInstrument(ii.client, s)
-		}
+		ii.add(s)
+	}
+
+	bonds, err := ii.client.Bonds(ctx, proto.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		l.Error("Bonds", zap.Error(err))
+		return err
+	}
+	for _, b := range bonds {
+		ii.add(b)
+	}
+
+	futures, err := ii.client.Futures(ctx, proto.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		l.Error("Futures", zap.Error(err))
+		return err
+	}
+	for _, f := range futures {
+		ii.add(futureDescription{f})
+	}
+
+	currencies, err := ii.client.Currencies(ctx, proto.InstrumentStatus_INSTRUMENT_STATUS_ALL)
+	if err != nil {
+		l.Error("Currencies", zap.Error(err))
+		return err
+	}
+	for _, c := range currencies {
+		ii.add(c)
 	}
 	return nil
 }
 
+// добавить инструмент, если его ещё нет
+func (ii *Instruments) add(desc InstrumentAdditionDescriptionInAPI) *Instrument {
+	if i, ok := ii.instruments[desc.GetFigi()]; ok {
+		return i
+	}
+	i := NewInstrument(ii.client, desc)
+	ii.instruments[desc.GetFigi()] = i
+	ticker := strings.ToUpper(desc.GetTicker())
+	ii.byTicker[ticker] = append(ii.byTicker[ticker], i)
+	if desc.GetIsin() != "" {
+		isin := strings.ToUpper(desc.GetIsin())
+		ii.byIsin[isin] = append(ii.byIsin[isin], i)
+	}
+	return i
+}
+
+func (ii *Instruments) all() []*Instrument {
+	ii.locker.RLock()
+	defer ii.locker.RUnlock()
+	result := make([]*Instrument, 0, len(ii.instruments))
+	for _, i := range ii.instruments {
+		result = append(result, i)
+	}
+	return result
+}
+
 func (ii *Instruments) Get(figi string) *Instrument {
 	ii.locker.RLock()
 	defer ii.locker.RUnlock()
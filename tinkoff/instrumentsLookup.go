@@ -0,0 +1,131 @@
+package tinkoff
+
+// Поиск инструментов по тикеру, классу и isin.
+// Тикер может указываться вместе с классом через @, например SBER@TQBR. Если тикер без класса торгуется
+// в нескольких классах, то возвращается ошибка AmbiguousInstrumentError со списком вариантов.
+// Если инструмента нет в загруженном справочнике, то он запрашивается через GetInstrumentBy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+var ErrInstrumentNotFound = errors.New("инструмент не найден")
+
+// по запросу найдено несколько инструментов
+type AmbiguousInstrumentError struct {
+	Query       string
+	Instruments []*Instrument
+}
+
+func (e *AmbiguousInstrumentError) Error() string {
+	variants := make([]string, len(e.Instruments))
+	for i, instrument := range e.Instruments {
+		variants[i] = fmt.Sprintf("%s@%s (%s, %s)",
+			instrument.GetTicker(), instrument.GetClassCode(), instrument.GetFigi(), instrument.GetName())
+	}
+	return fmt.Sprintf("%s соответствует несколько инструментов, укажите класс или figi: %s", e.Query, strings.Join(variants, ", "))
+}
+
+// разбирает запрос вида ТИКЕР@КЛАСС
+func splitTicker(query string) (ticker string, classCode string) {
+	ticker, classCode, _ = strings.Cut(query, "@")
+	return ticker, classCode
+}
+
+// один инструмент из найденных, или ошибка
+func single(query string, found []*Instrument) (*Instrument, error) {
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%s: %w", query, ErrInstrumentNotFound)
+	case 1:
+		return found[0], nil
+	}
+	return nil, &AmbiguousInstrumentError{Query: query, Instruments: found}
+}
+
+// инструмент по тикеру. Если classCode пустой, то тикер должен быть однозначным
+func (ii *Instruments) GetByTicker(ticker string, classCode string) (*Instrument, error) {
+	ii.locker.RLock()
+	defer ii.locker.RUnlock()
+	var found []*Instrument
+	for _, i := range ii.byTicker[strings.ToUpper(ticker)] {
+		if classCode == "" || strings.EqualFold(i.GetClassCode(), classCode) {
+			found = append(found, i)
+		}
+	}
+	query := ticker
+	if classCode != "" {
+		query += "@" + classCode
+	}
+	return single(query, found)
+}
+
+func (ii *Instruments) GetByIsin(isin string) (*Instrument, error) {
+	ii.locker.RLock()
+	defer ii.locker.RUnlock()
+	return single(isin, ii.byIsin[strings.ToUpper(isin)])
+}
+
+// инструмент по figi, тикеру (можно с классом через @) или isin
+func (ii *Instruments) Find(ctx context.Context, query string) (*Instrument, error) {
+	ii.locker.RLock()
+	i, ok := ii.instruments[query]
+	ii.locker.RUnlock()
+	if ok {
+		return i, nil
+	}
+
+	i, err := ii.GetByTicker(splitTicker(query))
+	if !errors.Is(err, ErrInstrumentNotFound) {
+		return i, err
+	}
+	i, err = ii.GetByIsin(query)
+	if !errors.Is(err, ErrInstrumentNotFound) {
+		return i, err
+	}
+	return ii.getInstrumentBy(ctx, query)
+}
+
+// запрос инструмента, которого нет в справочнике. Найденный инструмент добавляется в справочник
+func (ii *Instruments) getInstrumentBy(ctx context.Context, query string) (*Instrument, error) {
+	request := &proto.InstrumentRequest{
+		IdType: proto.InstrumentIdType_INSTRUMENT_ID_TYPE_FIGI,
+		Id:     query,
+	}
+	if ticker, classCode := splitTicker(query); classCode != "" {
+		request = &proto.InstrumentRequest{
+			IdType:    proto.InstrumentIdType_INSTRUMENT_ID_TYPE_TICKER,
+			ClassCode: classCode,
+			Id:        ticker,
+		}
+	}
+	resp, err := ii.client.instrumentsServiceClient.GetInstrumentBy(ctx, request)
+	if err != nil {
+		l.Debug("GetInstrumentBy", zap.String("query", query), zap.Error(err))
+		if errors.Is(err, alex.ErrNotFound) {
+			return nil, fmt.Errorf("%s: %w", query, ErrInstrumentNotFound)
+		}
+		// сетевые ошибки и исчерпание лимита не означают, что инструмента нет
+		return nil, err
+	}
+	ii.locker.Lock()
+	defer ii.locker.Unlock()
+	return ii.add(resp.Instrument), nil
+}
+
+// инструмент по figi, тикеру (можно с классом через @) или isin, с информацией, специфичной для его типа
+func (c *Client) FindInstrument(ctx context.Context, query string) (alex.Instrument, error) {
+	i, err := c.Instruments.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return i.Typed(), nil
+}
@@ -1,39 +1,168 @@
 package tinkoff
 
+// Ограничение частоты запросов к api.
+// Лимиты запросов в минуту берутся из тарифа (GetUserTariff), методы с общим лимитом делят одну группу.
+// Сервер в заголовках ответа сообщает, сколько запросов осталось (x-ratelimit-remaining) и через сколько секунд
+// лимит восстановится (x-ratelimit-reset), по ним уточняется остаток группы. Если лимит исчерпан, или сервер
+// ответил ResourceExhausted, запросы группы приостанавливаются до восстановления лимита.
+// Выставление и отмена заявок идут с высоким приоритетом: пока они ждут лимит, обычные запросы (позиции, стаканы,
+// список заявок) не отправляются, и обычным запросам недоступна часть лимита, зарезервированная под заявки
+
 import (
 	"context"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
 	"go.uber.org/zap"
-	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+type Priority int32
+
+const (
+	Priority_NORMAL Priority = iota // запросы данных
+	Priority_HIGH   Priority = iota // выставление и отмена заявок
+)
+
+var Priority2string = map[Priority]string{
+	Priority_NORMAL: "normal",
+	Priority_HIGH:   "high",
+}
+
+func (p Priority) String() string {
+	return Priority2string[p]
+}
+
+const (
+	minRateLimitPause  = time.Second
+	maxRateLimitPause  = time.Minute
+	highPriorityPoll   = 50 * time.Millisecond // как часто обычный запрос проверяет, что приоритетные запросы ушли
+	rateLimitReserveOf = 10                    // обычным запросам недоступна 1/rateLimitReserveOf лимита
 )
 
+// методы, которые по умолчанию выполняются с высоким приоритетом
+var highPriorityMethods = map[string]bool{
+	"/tinkoff.public.invest.api.contract.v1.OrdersService/PostOrder":           true,
+	"/tinkoff.public.invest.api.contract.v1.OrdersService/CancelOrder":         true,
+	"/tinkoff.public.invest.api.contract.v1.OrdersService/ReplaceOrder":        true,
+	"/tinkoff.public.invest.api.contract.v1.StopOrdersService/PostStopOrder":   true,
+	"/tinkoff.public.invest.api.contract.v1.StopOrdersService/CancelStopOrder": true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/PostSandboxOrder":   true,
+	"/tinkoff.public.invest.api.contract.v1.SandboxService/CancelSandboxOrder": true,
+}
+
+type priorityKey struct{}
+
+// задаёт приоритет запросов, выполняемых с контекстом ctx
+func WithPriority(ctx context.Context, priority Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+func priorityOf(ctx context.Context, method string) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	if highPriorityMethods[method] {
+		return Priority_HIGH
+	}
+	return Priority_NORMAL
+}
+
+// Максимальное количество подписок в рамках одного потока рыночных данных по лимитной политике api.
+// Тариф версии 1.0.7 не передаёт лимит подписок, поэтому он действует, пока не задан SetSubscriptionLimit
+const MaxMarketDataSubscriptions = 300
+
 type Limits struct {
-	limits map[string]*rate.Limiter
+	locker        sync.Mutex
+	limits        map[string]*limitGroup
+	streams       map[string]int32 // максимальное количество stream-соединений по методам
+	subscriptions int              // максимальное количество подписок в потоке рыночных данных, 0 - по умолчанию
 }
 
 func (limits *Limits) Load(ctx context.Context, conn grpc.ClientConnInterface) error {
 	usersServiceClient := proto.NewUsersServiceClient(conn)
 	userTariff, err := usersServiceClient.GetUserTariff(ctx, &proto.GetUserTariffRequest{})
 	if err != nil {
-		l.DPanic("GetUserTariff", zap.Error(err))
+		l.Error("GetUserTariff", zap.Error(err))
 		return err
 	}
 
-	limits.limits = make(map[string]*rate.Limiter)
+	groups := make(map[string]*limitGroup)
 	for _, limit := range userTariff.UnaryLimits {
-		rateLimit := rate.NewLimiter(rate.Every(time.Minute/time.Duration(limit.LimitPerMinute)), int(limit.LimitPerMinute))
+		group := newLimitGroup(int(limit.LimitPerMinute))
 		for _, metod := range limit.Methods {
-			limits.limits["/"+metod] = rateLimit
+			groups["/"+metod] = group
+		}
+	}
+	streams := make(map[string]int32)
+	for _, limit := range userTariff.StreamLimits {
+		for _, stream := range limit.Streams {
+			streams["/"+stream] = limit.Limit
 		}
 	}
 
+	limits.locker.Lock()
+	defer limits.locker.Unlock()
+	limits.limits = groups
+	limits.streams = streams
 	return nil
 }
 
+// максимальное количество stream-соединений метода по тарифу. -1, если тариф не ограничивает метод
+func (limits *Limits) StreamLimit(method string) int32 {
+	limits.locker.Lock()
+	defer limits.locker.Unlock()
+	limit, ok := limits.streams[method]
+	if !ok {
+		return -1
+	}
+	return limit
+}
+
+// максимальное количество подписок в потоке рыночных данных. 0, если тариф не даёт открыть поток
+func (limits *Limits) SubscriptionLimit() int {
+	limits.locker.Lock()
+	defer limits.locker.Unlock()
+	if limit, ok := limits.streams[marketDataStreamMethod]; ok && limit == 0 {
+		return 0
+	}
+	if limits.subscriptions > 0 {
+		return limits.subscriptions
+	}
+	return MaxMarketDataSubscriptions
+}
+
+// задаёт лимит подписок, если он отличается от лимитной политики api
+func (limits *Limits) SetSubscriptionLimit(limit int) {
+	limits.locker.Lock()
+	defer limits.locker.Unlock()
+	limits.subscriptions = limit
+}
+
+// группа лимита метода. Для методов, которых нет в тарифе, создаётся группа без ограничения частоты,
+// которая всё равно учитывает ответы сервера
+func (limits *Limits) group(method string) *limitGroup {
+	limits.locker.Lock()
+	defer limits.locker.Unlock()
+	if limits.limits == nil {
+		limits.limits = make(map[string]*limitGroup)
+	}
+	group, ok := limits.limits[method]
+	if !ok {
+		l.Debug("лимит для метода не найден в тарифе", zap.String("metod", method))
+		group = newLimitGroup(0)
+		limits.limits[method] = group
+	}
+	return group
+}
+
 func (limits *Limits) withLimit(ctx context.Context,
 	method string,
 	req interface{},
@@ -42,17 +171,133 @@ func (limits *Limits) withLimit(ctx context.Context,
 	invoker grpc.UnaryInvoker,
 	opts ...grpc.CallOption,
 ) error {
-	l.Debug("call api", zap.String("method", strings.Replace(method, "/tinkoff.public.invest.api.contract.v1.", "", -1)))
-	limit := limits.limits[method]
-	if limit != nil {
-		err := limit.Wait(ctx)
-		if err != nil {
-			l.Debug("Не смог дождаться ratelimit", zap.String("metod", method), zap.Error(err))
+	shortMethod := strings.Replace(method, "/tinkoff.public.invest.api.contract.v1.", "", -1)
+	priority := priorityOf(ctx, method)
+	l.Debug("call api", zap.String("method", shortMethod), zap.Stringer("priority", priority))
+	group := limits.group(method)
+	if err := group.wait(ctx, priority); err != nil {
+		l.Debug("Не смог дождаться ratelimit", zap.String("metod", method), zap.Error(err))
+		return err
+	}
+	var header metadata.MD
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header))...)
+	group.feedback(shortMethod, header, err)
+	return err
+}
+
+// методы с общим лимитом запросов в минуту
+type limitGroup struct {
+	locker      sync.Mutex
+	perMinute   int       // лимит по тарифу, 0 - частота не ограничена
+	tokens      float64   // сколько запросов можно отправить сейчас
+	updated     time.Time // когда пересчитывался tokens
+	pausedUntil time.Time // до этого времени запросы не отправляются, т.к. сервер сообщил об исчерпании лимита
+	highWaiting int       // сколько приоритетных запросов ждут лимит
+	backoff     *Backoff
+}
+
+func newLimitGroup(perMinute int) *limitGroup {
+	return &limitGroup{
+		perMinute: perMinute,
+		tokens:    float64(perMinute),
+		updated:   time.Now(),
+		backoff:   &Backoff{Min: minRateLimitPause, Max: maxRateLimitPause},
+	}
+}
+
+// ждёт, пока запрос с приоритетом priority можно будет отправить
+func (g *limitGroup) wait(ctx context.Context, priority Priority) error {
+	if priority == Priority_HIGH {
+		g.locker.Lock()
+		g.highWaiting++
+		g.locker.Unlock()
+		defer func() {
+			g.locker.Lock()
+			g.highWaiting--
+			g.locker.Unlock()
+		}()
+	}
+	for {
+		delay := g.take(priority)
+		if delay == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// забирает запрос из лимита и возвращает 0, или возвращает, сколько ещё ждать
+func (g *limitGroup) take(priority Priority) time.Duration {
+	g.locker.Lock()
+	defer g.locker.Unlock()
+
+	now := time.Now()
+	if now.Before(g.pausedUntil) {
+		return g.pausedUntil.Sub(now)
+	}
+	if g.perMinute == 0 {
+		return 0
+	}
+	g.tokens += now.Sub(g.updated).Minutes() * float64(g.perMinute)
+	if g.tokens > float64(g.perMinute) {
+		g.tokens = float64(g.perMinute)
+	}
+	g.updated = now
+
+	need := 1.0
+	if priority != Priority_HIGH {
+		if g.highWaiting > 0 {
+			return highPriorityPoll
 		}
-	} else {
-		if method != "/tinkoff.public.invest.api.contract.v1.UsersService/GetUserTariff" {
-			l.DPanic("Лимит для метода не найден", zap.String("metod", method))
+		need += float64(g.perMinute / rateLimitReserveOf)
+	}
+	if g.tokens >= need {
+		g.tokens--
+		return 0
+	}
+	return time.Duration((need - g.tokens) / float64(g.perMinute) * float64(time.Minute))
+}
+
+// учитывает ответ сервера: остаток лимита из заголовков и ошибку исчерпания лимита
+func (g *limitGroup) feedback(method string, header metadata.MD, err error) {
+	remaining, remainingErr := strconv.Atoi(firstValue(header, "x-ratelimit-remaining"))
+	reset, resetErr := strconv.Atoi(firstValue(header, "x-ratelimit-reset"))
+
+	g.locker.Lock()
+	defer g.locker.Unlock()
+	now := time.Now()
+	if remainingErr == nil {
+		rateLimitRemainingMetric.WithLabelValues(method).Set(float64(remaining))
+		// лимит общий для всех клиентов с этим токеном, поэтому сервер знает остаток точнее
+		if g.perMinute > 0 && float64(remaining) < g.tokens {
+			g.tokens = float64(remaining)
+			g.updated = now
+		}
+		if remaining == 0 && resetErr == nil && reset > 0 {
+			g.pause(now, time.Duration(reset)*time.Second)
+		}
+	}
+	if status.Code(err) == codes.ResourceExhausted {
+		rateLimitExhaustedMetric.WithLabelValues(method).Inc()
+		g.tokens = 0
+		g.updated = now
+		pause := g.backoff.Next()
+		if resetErr == nil && reset > 0 {
+			pause = time.Duration(reset) * time.Second
 		}
+		l.Warn("исчерпан лимит запросов", zap.String("method", method), zap.Duration("pause", pause))
+		g.pause(now, pause)
+	} else if err == nil {
+		g.backoff.Reset()
+	}
+}
+
+func (g *limitGroup) pause(now time.Time, d time.Duration) {
+	if until := now.Add(d); until.After(g.pausedUntil) {
+		g.pausedUntil = until
 	}
-	return invoker(ctx, method, req, reply, cc, opts...)
 }
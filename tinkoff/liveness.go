@@ -0,0 +1,207 @@
+package tinkoff
+
+// Контроль живости потоков и актуальности рыночных данных.
+// Сервер периодически присылает в потоки ping, поэтому если из потока долго ничего не приходит, то поток завис:
+// такой поток закрывается и переподключается. Рыночные данные инструмента считаются устаревшими, если завис
+// поток рыночных данных, или если по инструменту дольше порога (SetStaleThreshold) не приходило данных из потока.
+// При изменении актуальности данных подписчикам SubscribeDataFresh отправляется событие
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+)
+
+const (
+	DefaultStreamTimeout  = 3 * time.Minute // сколько поток может молчать (включая ping), прежде чем считается зависшим
+	livenessCheckInterval = 5 * time.Second
+)
+
+// время последнего сообщения потока
+type streamLiveness struct {
+	name    string
+	timeout time.Duration
+	last    int64 // UnixNano, доступ через atomic
+	locker  sync.Mutex
+	cancel  context.CancelFunc
+}
+
+func newStreamLiveness(name string) *streamLiveness {
+	return &streamLiveness{name: name, timeout: DefaultStreamTimeout}
+}
+
+// контекст для нового подключения потока. Предыдущее подключение закрывается
+func (sl *streamLiveness) newContext(parent context.Context) context.Context {
+	sl.locker.Lock()
+	defer sl.locker.Unlock()
+	if sl.cancel != nil {
+		sl.cancel()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	sl.cancel = cancel
+	sl.touch()
+	return ctx
+}
+
+func (sl *streamLiveness) touch() {
+	atomic.StoreInt64(&sl.last, time.Now().UnixNano())
+}
+
+// сколько времени из потока ничего не приходило
+func (sl *streamLiveness) Age() time.Duration {
+	last := atomic.LoadInt64(&sl.last)
+	if last == 0 {
+		return sl.timeout
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+func (sl *streamLiveness) IsAlive() bool {
+	return sl.Age() < sl.timeout
+}
+
+// закрывает поток, если он завис, после чего streamReader переподключит его. Возвращает true, если поток закрыт
+func (sl *streamLiveness) check() bool {
+	age := sl.Age()
+	streamMessageAgeMetric.WithLabelValues(sl.name).Set(age.Seconds())
+	if age < sl.timeout {
+		return false
+	}
+	sl.locker.Lock()
+	defer sl.locker.Unlock()
+	if sl.cancel == nil {
+		return false
+	}
+	l.Error("поток завис, переподключаюсь", zap.String("stream", sl.name), zap.Duration("age", age))
+	sl.cancel()
+	sl.cancel = nil
+	return true
+}
+
+// актуальность рыночных данных инструмента
+type dataFreshness struct {
+	locker      sync.Mutex
+	lastData    time.Time     // когда по инструменту последний раз приходили данные из потока
+	threshold   time.Duration // через сколько без данных они считаются устаревшими. 0 - не проверять
+	stale       bool          // последнее отправленное подписчикам состояние
+	subscribers []alex.DataFreshChan
+}
+
+func (i *Instrument) touchData() {
+	i.freshness.locker.Lock()
+	i.freshness.lastData = time.Now()
+	i.freshness.locker.Unlock()
+}
+
+// сбрасывает стаканы и последнюю цену из потока. Вызывается при разрыве потока: пока из нового потока
+// не придут свежие данные, GetOrderBook и GetLastPrices обращаются к api
+func (i *Instrument) dropLiveData() {
+	i.orderBookStream.locker.Lock()
+	i.orderBookStream.live = make(map[int32]*alex.OrderBook)
+	i.orderBookStream.locker.Unlock()
+	i.lastPriceStream.locker.Lock()
+	i.lastPriceStream.last = nil
+	i.lastPriceStream.locker.Unlock()
+}
+
+func (i *Instrument) SetStaleThreshold(threshold time.Duration) {
+	i.freshness.locker.Lock()
+	defer i.freshness.locker.Unlock()
+	i.freshness.threshold = threshold
+	if i.freshness.lastData.IsZero() {
+		// отсчёт начинается с момента, когда инструмент начали проверять
+		i.freshness.lastData = time.Now()
+	}
+}
+
+func (i *Instrument) IsDataFresh() bool {
+	if !i.client.GetMarketDataStream().liveness.IsAlive() {
+		return false
+	}
+	i.freshness.locker.Lock()
+	defer i.freshness.locker.Unlock()
+	return i.isDataFresh()
+}
+
+func (i *Instrument) isDataFresh() bool {
+	return i.freshness.threshold == 0 || time.Since(i.freshness.lastData) < i.freshness.threshold
+}
+
+func (i *Instrument) SubscribeDataFresh() (alex.DataFreshChan, error) {
+	i.freshness.locker.Lock()
+	defer i.freshness.locker.Unlock()
+	ch := make(alex.DataFreshChan, 10)
+	i.freshness.subscribers = append(i.freshness.subscribers, ch)
+	return ch, nil
+}
+
+func (i *Instrument) UnsubscribeDataFresh(dataFreshChan alex.DataFreshChan) error {
+	i.freshness.locker.Lock()
+	defer i.freshness.locker.Unlock()
+	for idx, ch := range i.freshness.subscribers {
+		if ch == dataFreshChan {
+			i.freshness.subscribers = append(i.freshness.subscribers[:idx], i.freshness.subscribers[idx+1:]...)
+			close(ch)
+			return nil
+		}
+	}
+	l.DPanic("отписываюсь от актуальности данных, хотя не подписывался на неё")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+// проверяет актуальность данных, и при её изменении уведомляет подписчиков
+func (i *Instrument) checkFreshness(streamAlive bool) {
+	i.freshness.locker.Lock()
+	defer i.freshness.locker.Unlock()
+	if i.freshness.lastData.IsZero() && len(i.freshness.subscribers) == 0 {
+		// инструмент не используется
+		return
+	}
+	if !i.freshness.lastData.IsZero() {
+		marketDataAgeMetric.WithLabelValues(i.GetFigi()).Set(time.Since(i.freshness.lastData).Seconds())
+	}
+	stale := !streamAlive || !i.isDataFresh()
+	if stale == i.freshness.stale {
+		return
+	}
+	i.freshness.stale = stale
+	if stale {
+		l.Warn("рыночные данные устарели", zap.String("figi", i.GetFigi()), zap.Time("lastData", i.freshness.lastData))
+	} else {
+		l.Info("рыночные данные снова актуальны", zap.String("figi", i.GetFigi()))
+	}
+	for _, ch := range i.freshness.subscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток событий актуальности данных")
+		} else {
+			ch <- !stale
+		}
+	}
+}
+
+// периодически проверяет живость потоков и актуальность данных инструментов
+func (c *Client) watchStreams(ctx context.Context) {
+	ticker := time.NewTicker(livenessCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.dataStreamMarket.liveness.check()
+		if c.orderTrades.isOpened() {
+			c.orderTrades.liveness.check()
+		}
+		streamAlive := c.dataStreamMarket.liveness.IsAlive()
+		for _, i := range c.Instruments.all() {
+			i.checkFreshness(streamAlive)
+		}
+	}
+}
@@ -12,14 +12,19 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func (cs *Candles) Load(ctx context.Context, from time.Time, to time.Time) error {
-	maxGetCandles := time.Hour * 24
+// максимальный период, за который можно запросить свечи одним запросом GetCandles
+func (cs *Candles) maxGetCandles() time.Duration {
 	switch {
 	case cs.Period >= time.Hour*24:
-		maxGetCandles = time.Hour * 24 * 365
+		return time.Hour * 24 * 365
 	case cs.Period >= time.Hour:
-		maxGetCandles = time.Hour * 24 * 7
+		return time.Hour * 24 * 7
 	}
+	return time.Hour * 24
+}
+
+func (cs *Candles) Load(ctx context.Context, from time.Time, to time.Time) error {
+	maxGetCandles := cs.maxGetCandles()
 
 	sleepDuration := time.Duration(0)
 	sleepTime := time.Now()
@@ -71,13 +76,57 @@ func (cs *Candles) Load(ctx context.Context, from time.Time, to time.Time) error
 	return nil
 }
 
+// Докачивает свечи с начала последней свечи в серии до текущего момента, например после разрыва потока.
+// Свечи передаются так же, как из потока, поэтому их получат и подписчики.
+// Если серия пустая, то докачивать нечего
+func (cs *Candles) Backfill(ctx context.Context) error {
+	last := cs.LastCandle()
+	if last == nil {
+		return nil
+	}
+	from, to := last.Period.Start, time.Now()
+	for from.Before(to) {
+		requestTo := from.Add(cs.maxGetCandles())
+		if requestTo.After(to) {
+			requestTo = to
+		}
+		candles, err := cs.client.GetMarketDataServiceClient().GetCandles(
+			ctx,
+			&proto.GetCandlesRequest{
+				Figi:     cs.Figi,
+				From:     timestamppb.New(from),
+				To:       timestamppb.New(requestTo),
+				Interval: alex.Duration2CandleInterval(cs.Period),
+			})
+		if err != nil {
+			return err
+		}
+		cs.l.Info("докачаны свечи после переподключения", zap.Int("count", len(candles.Candles)),
+			zap.Time("from", from), zap.Time("to", requestTo))
+		for _, c := range candles.Candles {
+			cs.incomingChannel <- cs.newCandle(c)
+		}
+		from = requestTo
+	}
+	return nil
+}
+
 //Метод запроса последних цен по инструментам.
+//Если есть подписка на последние цены, и цена из потока уже пришла, то она возвращается без запроса к api.
+//Если данные потока устарели, цена запрашивается у api
 func (i *Instrument) GetLastPrices(ctx context.Context) ([]*alex.LastPrice, error) {
+	i.lastPriceStream.locker.RLock()
+	last := i.lastPriceStream.last
+	i.lastPriceStream.locker.RUnlock()
+	if last != nil && i.IsDataFresh() {
+		return []*alex.LastPrice{last}, nil
+	}
+
 	resp, err := i.client.marketDataServiceClient.GetLastPrices(ctx, &proto.GetLastPricesRequest{
 		Figi: []string{i.GetFigi()},
 	})
 	if err != nil {
-		l.DPanic("GetLastPrices", zap.Error(err))
+		l.Error("GetLastPrices", zap.Error(err))
 		return nil, err
 	}
 	result := make([]*alex.LastPrice, len(resp.LastPrices))
@@ -27,4 +27,34 @@ var (
 	},
 		[]string{"figi", "name"},
 	)
+	reconnectsMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tinkoff_stream_reconnects",
+		Help: "Количество переподключений потоков",
+	},
+		[]string{"stream"},
+	)
+	streamMessageAgeMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinkoff_stream_last_message_age_seconds",
+		Help: "Сколько секунд назад из потока пришло последнее сообщение (включая ping)",
+	},
+		[]string{"stream"},
+	)
+	marketDataAgeMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinkoff_market_data_age_seconds",
+		Help: "Сколько секунд назад по инструменту пришли последние рыночные данные из потока",
+	},
+		[]string{"figi"},
+	)
+	rateLimitRemainingMetric = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "tinkoff_rate_limit_remaining",
+		Help: "Сколько запросов осталось в лимите метода по данным сервера",
+	},
+		[]string{"method"},
+	)
+	rateLimitExhaustedMetric = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tinkoff_rate_limit_exhausted",
+		Help: "Количество ответов ResourceExhausted по методам",
+	},
+		[]string{"method"},
+	)
 )
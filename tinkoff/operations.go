@@ -0,0 +1,325 @@
+package tinkoff
+
+// Операции по счёту и брокерский отчёт.
+// Операции запрашиваются окнами по operationsWindow, чтобы ответ на длинный период не упирался в ограничения
+// сервера. Брокерский отчёт сервер сначала формирует, а потом отдаёт по страницам: отчёт запрашивается,
+// пока не будет готов, после чего загружаются все страницы
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const (
+	operationsWindow     = 30 * 24 * time.Hour // на какой период запрашивать операции за раз
+	brokerReportWindow   = 30 * 24 * time.Hour // брокерский отчёт формируется за период не больше месяца
+	brokerReportAttempts = 10                  // сколько раз запрашивать ещё не сформированный отчёт
+)
+
+// вид операции, по которому удобно сверять движение средств
+type OperationCategory int32
+
+const (
+	OperationCategory_OTHER      OperationCategory = iota // прочие операции
+	OperationCategory_TRADE      OperationCategory = iota // покупка и продажа
+	OperationCategory_COMMISSION OperationCategory = iota // комиссии брокера
+	OperationCategory_DIVIDEND   OperationCategory = iota // дивиденды
+	OperationCategory_COUPON     OperationCategory = iota // купоны и погашение облигаций
+	OperationCategory_TAX        OperationCategory = iota // налоги и их корректировки
+	OperationCategory_DEPOSIT    OperationCategory = iota // пополнение счёта деньгами или бумагами
+	OperationCategory_WITHDRAWAL OperationCategory = iota // вывод денег или бумаг
+	OperationCategory_MARGIN     OperationCategory = iota // вариационная маржа
+)
+
+var OperationCategory2string = map[OperationCategory]string{
+	OperationCategory_OTHER:      "other",
+	OperationCategory_TRADE:      "trade",
+	OperationCategory_COMMISSION: "commission",
+	OperationCategory_DIVIDEND:   "dividend",
+	OperationCategory_COUPON:     "coupon",
+	OperationCategory_TAX:        "tax",
+	OperationCategory_DEPOSIT:    "deposit",
+	OperationCategory_WITHDRAWAL: "withdrawal",
+	OperationCategory_MARGIN:     "margin",
+}
+
+func (c OperationCategory) String() string {
+	return OperationCategory2string[c]
+}
+
+var operationType2category = map[proto.OperationType]OperationCategory{
+	proto.OperationType_OPERATION_TYPE_BUY:                         OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_BUY_CARD:                    OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_BUY_MARGIN:                  OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_SELL:                        OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_SELL_CARD:                   OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_SELL_MARGIN:                 OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_DELIVERY_BUY:                OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_DELIVERY_SELL:               OperationCategory_TRADE,
+	proto.OperationType_OPERATION_TYPE_BROKER_FEE:                  OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_SERVICE_FEE:                 OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_MARGIN_FEE:                  OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_SUCCESS_FEE:                 OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_TRACK_MFEE:                  OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_TRACK_PFEE:                  OperationCategory_COMMISSION,
+	proto.OperationType_OPERATION_TYPE_DIVIDEND:                    OperationCategory_DIVIDEND,
+	proto.OperationType_OPERATION_TYPE_DIVIDEND_TRANSFER:           OperationCategory_DIVIDEND,
+	proto.OperationType_OPERATION_TYPE_DIV_EXT:                     OperationCategory_DIVIDEND,
+	proto.OperationType_OPERATION_TYPE_COUPON:                      OperationCategory_COUPON,
+	proto.OperationType_OPERATION_TYPE_BOND_REPAYMENT:              OperationCategory_COUPON,
+	proto.OperationType_OPERATION_TYPE_BOND_REPAYMENT_FULL:         OperationCategory_COUPON,
+	proto.OperationType_OPERATION_TYPE_TAX:                         OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_BOND_TAX:                    OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_DIVIDEND_TAX:                OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_BENEFIT_TAX:                 OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_CORRECTION:              OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_PROGRESSIVE:             OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_BOND_TAX_PROGRESSIVE:        OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_DIVIDEND_TAX_PROGRESSIVE:    OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_BENEFIT_TAX_PROGRESSIVE:     OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_CORRECTION_PROGRESSIVE:  OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO_PROGRESSIVE:        OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO:                    OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO_HOLD:               OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO_REFUND:             OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO_HOLD_PROGRESSIVE:   OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_REPO_REFUND_PROGRESSIVE: OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_TAX_CORRECTION_COUPON:       OperationCategory_TAX,
+	proto.OperationType_OPERATION_TYPE_INPUT:                       OperationCategory_DEPOSIT,
+	proto.OperationType_OPERATION_TYPE_INPUT_SECURITIES:            OperationCategory_DEPOSIT,
+	proto.OperationType_OPERATION_TYPE_OUTPUT:                      OperationCategory_WITHDRAWAL,
+	proto.OperationType_OPERATION_TYPE_OUTPUT_SECURITIES:           OperationCategory_WITHDRAWAL,
+	proto.OperationType_OPERATION_TYPE_ACCRUING_VARMARGIN:          OperationCategory_MARGIN,
+	proto.OperationType_OPERATION_TYPE_WRITING_OFF_VARMARGIN:       OperationCategory_MARGIN,
+}
+
+//Операция по счёту.
+type Operation struct {
+	Id                string               //Идентификатор операции.
+	ParentOperationId string               //Идентификатор родительской операции.
+	Currency          string               //Валюта операции.
+	Payment           *alex.Money          //Сумма операции.
+	Price             *alex.Money          //Цена операции за 1 инструмент.
+	State             proto.OperationState //Статус операции.
+	Quantity          int64                //Количество единиц инструмента.
+	QuantityRest      int64                //Неисполненный остаток по сделке.
+	Figi              string               //Figi-идентификатор инструмента, связанного с операцией.
+	InstrumentType    string               //Тип инструмента.
+	Date              time.Time            //Дата и время операции в часовом поясе UTC.
+	Type              string               //Текстовое описание типа операции.
+	OperationType     proto.OperationType  //Тип операции.
+	Category          OperationCategory    //Вид операции.
+	Trades            []*OperationTrade    //Массив сделок.
+}
+
+//Сделка по операции.
+type OperationTrade struct {
+	TradeId  string      //Идентификатор сделки.
+	DateTime time.Time   //Дата и время сделки в часовом поясе UTC.
+	Quantity int64       //Количество инструментов.
+	Price    *alex.Money //Цена за 1 инструмент.
+}
+
+func NewOperation(o *proto.Operation) *Operation {
+	operation := &Operation{
+		Id:                o.Id,
+		ParentOperationId: o.ParentOperationId,
+		Currency:          o.Currency,
+		Payment:           alex.NewMoney(o.Payment),
+		Price:             alex.NewMoney(o.Price),
+		State:             o.State,
+		Quantity:          o.Quantity,
+		QuantityRest:      o.QuantityRest,
+		Figi:              o.Figi,
+		InstrumentType:    o.InstrumentType,
+		Date:              asTime(o.Date),
+		Type:              o.Type,
+		OperationType:     o.OperationType,
+		Category:          operationType2category[o.OperationType],
+	}
+	for _, t := range o.Trades {
+		operation.Trades = append(operation.Trades, &OperationTrade{
+			TradeId:  t.TradeId,
+			DateTime: asTime(t.DateTime),
+			Quantity: t.Quantity,
+			Price:    alex.NewMoney(t.Price),
+		})
+	}
+	return operation
+}
+
+// загружает операции окнами по operationsWindow, и возвращает их отсортированными по времени
+func getOperations(ctx context.Context, from time.Time, to time.Time, get func(ctx context.Context, req *proto.OperationsRequest) (*proto.OperationsResponse, error), req *proto.OperationsRequest) ([]*Operation, error) {
+	var result []*Operation
+	seen := make(map[string]bool)
+	for start := from; start.Before(to); start = start.Add(operationsWindow) {
+		end := start.Add(operationsWindow)
+		if end.After(to) {
+			end = to
+		}
+		req.From = timestamppb.New(start)
+		req.To = timestamppb.New(end)
+		resp, err := get(ctx, req)
+		if err != nil {
+			l.Error("GetOperations", zap.Error(err), zap.Time("from", start), zap.Time("to", end))
+			return nil, err
+		}
+		for _, o := range resp.Operations {
+			// операция на границе окон может прийти дважды
+			if seen[o.Id] {
+				continue
+			}
+			seen[o.Id] = true
+			result = append(result, NewOperation(o))
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Date.Before(result[j].Date) })
+	return result, nil
+}
+
+//Операции по счёту за период.
+func (ra *RealAccount) GetOperations(ctx context.Context, from time.Time, to time.Time) ([]*Operation, error) {
+	return getOperations(ctx, from, to, func(ctx context.Context, req *proto.OperationsRequest) (*proto.OperationsResponse, error) {
+		return ra.client.GetOperationsServiceClient().GetOperations(ctx, req)
+	}, &proto.OperationsRequest{AccountId: ra.id})
+}
+
+//Операции по счёту песочницы за период.
+func (sa *SandboxAccount) GetOperations(ctx context.Context, from time.Time, to time.Time) ([]*Operation, error) {
+	return getOperations(ctx, from, to, func(ctx context.Context, req *proto.OperationsRequest) (*proto.OperationsResponse, error) {
+		return sa.client.GetSandboxServiceClient().GetSandboxOperations(ctx, req)
+	}, &proto.OperationsRequest{AccountId: sa.id})
+}
+
+//Строка брокерского отчёта.
+type BrokerReportItem struct {
+	TradeId                    string      //Номер сделки.
+	OrderId                    string      //Номер поручения.
+	Figi                       string      //Figi-идентификатор инструмента.
+	ExecuteSign                string      //Признак исполнения.
+	TradeDatetime              time.Time   //Дата и время заключения в часовом поясе UTC.
+	Exchange                   string      //Торговая площадка.
+	ClassCode                  string      //Режим торгов.
+	Direction                  string      //Вид сделки.
+	Name                       string      //Сокращённое наименование актива.
+	Ticker                     string      //Код актива.
+	Price                      *alex.Money //Цена за единицу.
+	Quantity                   int64       //Количество.
+	OrderAmount                *alex.Money //Сумма (без НКД).
+	TotalOrderAmount           *alex.Money //Сумма сделки.
+	BrokerCommission           *alex.Money //Комиссия брокера.
+	ExchangeCommission         *alex.Money //Комиссия биржи.
+	ExchangeClearingCommission *alex.Money //Комиссия клир. центра.
+	Party                      string      //Контрагент/Брокер.
+	ClearValueDate             time.Time   //Дата расчётов в часовом поясе UTC.
+	SecValueDate               time.Time   //Дата поставки в часовом поясе UTC.
+	BrokerStatus               string      //Статус брокера.
+}
+
+func NewBrokerReportItem(r *proto.BrokerReport) *BrokerReportItem {
+	return &BrokerReportItem{
+		TradeId:                    r.TradeId,
+		OrderId:                    r.OrderId,
+		Figi:                       r.Figi,
+		ExecuteSign:                r.ExecuteSign,
+		TradeDatetime:              asTime(r.TradeDatetime),
+		Exchange:                   r.Exchange,
+		ClassCode:                  r.ClassCode,
+		Direction:                  r.Direction,
+		Name:                       r.Name,
+		Ticker:                     r.Ticker,
+		Price:                      alex.NewMoney(r.Price),
+		Quantity:                   r.Quantity,
+		OrderAmount:                alex.NewMoney(r.OrderAmount),
+		TotalOrderAmount:           alex.NewMoney(r.TotalOrderAmount),
+		BrokerCommission:           alex.NewMoney(r.BrokerCommission),
+		ExchangeCommission:         alex.NewMoney(r.ExchangeCommission),
+		ExchangeClearingCommission: alex.NewMoney(r.ExchangeClearingCommission),
+		Party:                      r.Party,
+		ClearValueDate:             asTime(r.ClearValueDate),
+		SecValueDate:               asTime(r.SecValueDate),
+		BrokerStatus:               r.BrokerStatus,
+	}
+}
+
+//Брокерский отчёт за период. В песочнице брокерского отчёта нет.
+func (ra *RealAccount) GetBrokerReport(ctx context.Context, from time.Time, to time.Time) ([]*BrokerReportItem, error) {
+	var result []*BrokerReportItem
+	for start := from; start.Before(to); start = start.Add(brokerReportWindow) {
+		end := start.Add(brokerReportWindow)
+		if end.After(to) {
+			end = to
+		}
+		items, err := ra.getBrokerReport(ctx, start, end)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, items...)
+	}
+	return result, nil
+}
+
+func (ra *RealAccount) getBrokerReport(ctx context.Context, from time.Time, to time.Time) ([]*BrokerReportItem, error) {
+	resp, err := ra.client.GetOperationsServiceClient().GetBrokerReport(ctx, &proto.BrokerReportRequest{
+		Payload: &proto.BrokerReportRequest_GenerateBrokerReportRequest{
+			GenerateBrokerReportRequest: &proto.GenerateBrokerReportRequest{
+				AccountId: ra.id,
+				From:      timestamppb.New(from),
+				To:        timestamppb.New(to),
+			},
+		},
+	})
+	if err != nil {
+		l.Error("GenerateBrokerReport", zap.Error(err), zap.Time("from", from), zap.Time("to", to))
+		return nil, err
+	}
+	taskId := resp.GetGenerateBrokerReportResponse().GetTaskId()
+
+	var result []*BrokerReportItem
+	for page, pages := int32(0), int32(1); page < pages; page++ {
+		report, err := ra.getBrokerReportPage(ctx, taskId, page)
+		if err != nil {
+			return nil, err
+		}
+		pages = report.PagesCount
+		for _, r := range report.BrokerReport {
+			result = append(result, NewBrokerReportItem(r))
+		}
+	}
+	return result, nil
+}
+
+// страница брокерского отчёта. Пока отчёт формируется, сервер отвечает ошибкой, поэтому запрос повторяется
+func (ra *RealAccount) getBrokerReportPage(ctx context.Context, taskId string, page int32) (*proto.GetBrokerReportResponse, error) {
+	backoff := NewBackoff()
+	for attempt := 1; ; attempt++ {
+		resp, err := ra.client.GetOperationsServiceClient().GetBrokerReport(ctx, &proto.BrokerReportRequest{
+			Payload: &proto.BrokerReportRequest_GetBrokerReportRequest{
+				GetBrokerReportRequest: &proto.GetBrokerReportRequest{
+					TaskId: taskId,
+					Page:   page,
+				},
+			},
+		})
+		if err == nil {
+			return resp.GetGetBrokerReportResponse(), nil
+		}
+		if attempt == brokerReportAttempts {
+			l.Error("GetBrokerReport", zap.Error(err), zap.String("taskId", taskId), zap.Int32("page", page))
+			return nil, err
+		}
+		l.Debug("брокерский отчёт ещё не готов", zap.String("taskId", taskId), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff.Next()):
+		}
+	}
+}
@@ -70,6 +70,18 @@ func (o *BaseOrder) GetOrderDate() time.Time {
 func (o *BaseOrder) GetInitialOrderPrice() *alex.Money {
 	return o.initialOrderPrice
 }
+func (o *BaseOrder) GetExecutedOrderPrice() *alex.Money {
+	return o.executedOrderPrice
+}
+func (o *BaseOrder) GetExecutedCommission() *alex.Money {
+	return o.executedCommission
+}
+func (o *BaseOrder) GetInitialSecurityPrice() *alex.Money {
+	return o.initialSecurityPrice
+}
+func (o *BaseOrder) GetOrderType() proto.OrderType {
+	return o.orderType
+}
 
 func NewBaseOrder(o OrderFromAPI, a Account, orderDate time.Time) BaseOrder {
 	return BaseOrder{
@@ -104,10 +116,11 @@ func (o *BaseOrder) IsActive() bool {
 }
 
 func (o *BaseOrder) IsBestInOrderBook(ctx context.Context) bool {
-	//TODO данный метод может вызываться очень часто. Сейчас, ценой замедления спаает ratelimit, но по хорошему надо реализовывать кеширование или подписку на стаканы
+	// метод может вызываться очень часто. Если по инструменту есть подписка на стакан (SubscribeOrderBook),
+	// то стакан берётся из потока, иначе запрашивается не чаще, чем раз в OrderBookCache.LiveTime
 	ob, err := o.instrument.GetOrderBook(ctx, 1)
 	if err != nil {
-		l.DPanic("GetOrderBook", zap.Error(err))
+		l.Error("GetOrderBook", zap.Error(err))
 		return false
 	}
 	return (o.direction == proto.OrderDirection_ORDER_DIRECTION_BUY &&
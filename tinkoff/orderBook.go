@@ -2,6 +2,7 @@ package tinkoff
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
@@ -17,7 +18,7 @@ func (i *Instrument) getOrderBookWithoutCache(ctx context.Context, depth int32)
 		Depth: depth,
 	})
 	if err != nil {
-		l.DPanic("GetOrderBook", zap.Error(err))
+		l.Error("GetOrderBook", zap.Error(err))
 		return nil, err
 	}
 	return alex.NewOrderBook(resp), nil
@@ -34,8 +35,22 @@ type OrderBookCache struct {
 	OrderBooks map[int32]OrderBookCacheItem
 }
 
+// стаканы, получаемые из потока рыночных данных, по глубине
+type orderBookStream struct {
+	locker      sync.RWMutex
+	live        map[int32]*alex.OrderBook
+	subscribers map[int32][]alex.OrderBookChan
+}
+
 //Метод получения стакана по инструменту.
+//Если есть подписка на стакан такой же или большей глубины, то стакан берётся из потока без запроса к api.
+//Если данные потока устарели, стакан запрашивается у api
 func (i *Instrument) GetOrderBook(ctx context.Context, depth int32) (*alex.OrderBook, error) {
+	if i.IsDataFresh() {
+		if ob := i.getLiveOrderBook(depth); ob != nil {
+			return ob, nil
+		}
+	}
 	if i.OrderBookCache.LiveTime == 0 {
 		return i.getOrderBookWithoutCache(ctx, depth)
 	}
@@ -60,3 +75,82 @@ func (i *Instrument) GetOrderBook(ctx context.Context, depth int32) (*alex.Order
 	}
 	return cob.OrderBook, nil
 }
+
+// стакан из потока наименьшей глубины, не меньшей depth
+func (i *Instrument) getLiveOrderBook(depth int32) *alex.OrderBook {
+	i.orderBookStream.locker.RLock()
+	defer i.orderBookStream.locker.RUnlock()
+	var result *alex.OrderBook
+	for liveDepth, ob := range i.orderBookStream.live {
+		if liveDepth >= depth && (result == nil || liveDepth < result.Depth) {
+			result = ob
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	return result.Truncate(depth)
+}
+
+// Подписаться на изменения стакана. Пока есть подписчики, GetOrderBook отдаёт стакан из потока
+func (i *Instrument) SubscribeOrderBook(depth int32) (alex.OrderBookChan, error) {
+	i.orderBookStream.locker.Lock()
+	defer i.orderBookStream.locker.Unlock()
+
+	if len(i.orderBookStream.subscribers[depth]) == 0 {
+		if err := i.client.GetMarketDataStream().Subscriptions.Subscribe(OrderBookSubscription(i.GetFigi(), depth)); err != nil {
+			return nil, err
+		}
+	}
+	ch := make(alex.OrderBookChan, 10)
+	i.orderBookStream.subscribers[depth] = append(i.orderBookStream.subscribers[depth], ch)
+	return ch, nil
+}
+
+func (i *Instrument) UnsubscribeOrderBook(orderBookChan alex.OrderBookChan) error {
+	i.orderBookStream.locker.Lock()
+	defer i.orderBookStream.locker.Unlock()
+
+	for depth, subscribers := range i.orderBookStream.subscribers {
+		for idx, ch := range subscribers {
+			if ch != orderBookChan {
+				continue
+			}
+			close(ch)
+			i.orderBookStream.subscribers[depth] = append(subscribers[:idx], subscribers[idx+1:]...)
+			if len(i.orderBookStream.subscribers[depth]) > 0 {
+				return nil
+			}
+			// подписчиков не осталось, стакан из потока больше не актуален
+			delete(i.orderBookStream.subscribers, depth)
+			delete(i.orderBookStream.live, depth)
+			return i.client.GetMarketDataStream().Subscriptions.Unsubscribe(OrderBookSubscription(i.GetFigi(), depth))
+		}
+	}
+	l.DPanic("отписываюсь от стакана, хотя не подписывался на него")
+	return errors.New("NO SUBSCRIPTION")
+}
+
+// новый стакан из потока. Несогласованный стакан передаётся подписчикам, но GetOrderBook его не отдаёт
+func (i *Instrument) onOrderBook(ob *alex.OrderBook, consistent bool) {
+	i.orderBookStream.locker.Lock()
+	defer i.orderBookStream.locker.Unlock()
+
+	subscribers, ok := i.orderBookStream.subscribers[ob.Depth]
+	if !ok {
+		// подписка есть только в записи потока, без подписчиков в инструменте
+		return
+	}
+	if consistent {
+		i.orderBookStream.live[ob.Depth] = ob
+	} else {
+		delete(i.orderBookStream.live, ob.Depth)
+	}
+	for _, ch := range subscribers {
+		if len(ch) == cap(ch) {
+			l.Error("переполнен поток обработки стаканов. медленная работа робота? deadlock?")
+		} else {
+			ch <- ob
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package tinkoff
+
+// Идемпотентное выставление заявок.
+// Идентификатор заявки (orderId) служит ключом идемпотентности: брокер не создаст вторую заявку с тем же ключом.
+// Перед отправкой заявка записывается в файл pending-orders-<счёт>.json в каталоге данных. При временной ошибке
+// (сеть, лимит запросов, внутренняя ошибка сервера) исход неизвестен, поэтому состояние заявки уточняется через
+// GetOrderState, и, если заявки нет, запрос повторяется с тем же ключом. Из файла заявка удаляется, когда исход
+// известен. Заявки, исход которых так и не удалось узнать (попытки кончились, запрос прерван, или заявка осталась
+// в файле после перезапуска), уточняются перед каждой новой заявкой. Пока исход не известен, заявка с теми же
+// параметрами выставляется с прежним ключом, а заявка по тому же инструменту с другими параметрами не выставляется
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const (
+	orderSubmitAttempts   = 5
+	minOrderRetryDelay    = 500 * time.Millisecond
+	maxOrderRetryDelay    = 10 * time.Second
+	pendingOrdersFileMode = 0644
+)
+
+// счёт, умеющий отправить заявку одним запросом и узнать её состояние
+type orderSender interface {
+	sendOrder(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error)
+	GetOrderState(ctx context.Context, orderId string) (*FromGetOrder, error)
+}
+
+// заявка, исход отправки которой ещё не известен
+type pendingOrder struct {
+	OrderId   string
+	Figi      string
+	Quantity  int64
+	Price     string
+	Direction proto.OrderDirection
+	OrderType proto.OrderType
+	Created   time.Time
+}
+
+type orderSubmitter struct {
+	sender     orderSender
+	fileName   string
+	locker     sync.Mutex
+	pending    map[string]*pendingOrder // orderId -> заявка
+	unresolved map[string]bool          // orderId заявок, которые сейчас не отправляются, а исход ещё не уточнён
+}
+
+func newOrderSubmitter(client *Client, accountId string, sender orderSender) *orderSubmitter {
+	s := &orderSubmitter{
+		sender:     sender,
+		fileName:   path.Join(client.GetDataDir(), "pending-orders-"+accountId+".json"),
+		pending:    make(map[string]*pendingOrder),
+		unresolved: make(map[string]bool),
+	}
+	if err := s.load(); err != nil {
+		l.Error("не смог прочитать неподтверждённые заявки", zap.String("fileName", s.fileName), zap.Error(err))
+	}
+	return s
+}
+
+// выставляет заявку, повторяя запрос с тем же orderId при временных ошибках
+func (s *orderSubmitter) submit(ctx context.Context, instrument alex.Instrument, quantity int64, price big.Decimal, direction proto.OrderDirection, orderType proto.OrderType, orderId string) (alex.Order, error) {
+	if err := s.resolvePending(ctx); err != nil {
+		l.Warn("не все неподтверждённые заявки уточнены", zap.Error(err))
+	}
+	if id := s.unresolvedFor(instrument.GetFigi(), orderId); id != "" {
+		// прошлая заявка могла дойти до брокера, новая заявка её задвоит
+		return nil, fmt.Errorf("исход заявки %s по %s неизвестен, новая заявка не выставляется", id, instrument.GetFigi())
+	}
+	err := s.add(&pendingOrder{
+		OrderId:   orderId,
+		Figi:      instrument.GetFigi(),
+		Quantity:  quantity,
+		Price:     price.String(),
+		Direction: direction,
+		OrderType: orderType,
+		Created:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := &Backoff{Min: minOrderRetryDelay, Max: maxOrderRetryDelay}
+	for attempt := 1; ; attempt++ {
+		o, err := s.sender.sendOrder(ctx, instrument, quantity, price, direction, orderType, orderId)
+		if err == nil {
+			s.remove(orderId)
+			return o, nil
+		}
+		if ctx.Err() != nil {
+			// запрос прерван, исход неизвестен. Заявка остаётся в файле до уточнения
+			s.markUnresolved(orderId)
+			return nil, err
+		}
+		if !alex.IsRetryable(err) {
+			// брокер отклонил заявку, повторять бесполезно
+			s.remove(orderId)
+			return nil, err
+		}
+		// заявка могла дойти до брокера, а ответ потеряться
+		state, stateErr := s.sender.GetOrderState(ctx, orderId)
+		if stateErr == nil {
+			l.Info("заявка выставлена, несмотря на ошибку", zap.String("orderId", orderId), zap.Error(err))
+			s.remove(orderId)
+			return state, nil
+		}
+		if attempt == orderSubmitAttempts {
+			l.Error("исход выставления заявки неизвестен", zap.String("orderId", orderId), zap.Error(err))
+			s.markUnresolved(orderId)
+			return nil, err
+		}
+		delay := backoff.Next()
+		l.Warn("повторяю выставление заявки",
+			zap.String("orderId", orderId),
+			zap.Int("attempt", attempt),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+		select {
+		case <-ctx.Done():
+			s.markUnresolved(orderId)
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// уточняет у брокера исход заявок, которые не отправляются сейчас: если заявка есть у брокера или её точно нет,
+// она удаляется из файла. Возвращает ошибку, если исход некоторых заявок уточнить не удалось
+func (s *orderSubmitter) resolvePending(ctx context.Context) (result error) {
+	s.locker.Lock()
+	ids := make([]string, 0, len(s.unresolved))
+	for id := range s.unresolved {
+		ids = append(ids, id)
+	}
+	s.locker.Unlock()
+
+	for _, id := range ids {
+		_, err := s.sender.GetOrderState(ctx, id)
+		switch {
+		case err == nil:
+			l.Info("неподтверждённая заявка выставлена", zap.String("orderId", id))
+		case errors.Is(err, alex.ErrNotFound):
+			l.Info("неподтверждённая заявка не дошла до брокера", zap.String("orderId", id))
+		default:
+			result = err
+			continue
+		}
+		s.remove(id)
+	}
+	return result
+}
+
+// ключ идемпотентности для заявки: идентификатор неподтверждённой заявки с теми же параметрами, или новый.
+// Цена не сравнивается: повтор заявки обычно выставляется по новой лучшей цене, и с прежним ключом брокер вернёт
+// уже выставленную заявку по старой цене, а не создаст вторую
+func (s *orderSubmitter) orderIdFor(figi string, direction proto.OrderDirection, quantity int64) string {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for _, o := range s.pending {
+		if o.Figi == figi && o.Direction == direction && o.Quantity == quantity {
+			return o.OrderId
+		}
+	}
+	return uuid.NewString()
+}
+
+// неуточнённая заявка по инструменту, кроме orderId. Пустая строка - таких нет
+func (s *orderSubmitter) unresolvedFor(figi string, orderId string) string {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for id := range s.unresolved {
+		if id != orderId && s.pending[id].Figi == figi {
+			return id
+		}
+	}
+	return ""
+}
+
+func (s *orderSubmitter) markUnresolved(orderId string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if _, ok := s.pending[orderId]; ok {
+		s.unresolved[orderId] = true
+	}
+}
+
+func (s *orderSubmitter) add(o *pendingOrder) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if _, ok := s.pending[o.OrderId]; ok {
+		// повторная отправка заявки с прежним ключом
+		delete(s.unresolved, o.OrderId)
+		return nil
+	}
+	s.pending[o.OrderId] = o
+	return s.save()
+}
+
+func (s *orderSubmitter) remove(orderId string) {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if _, ok := s.pending[orderId]; !ok {
+		return
+	}
+	delete(s.pending, orderId)
+	delete(s.unresolved, orderId)
+	if err := s.save(); err != nil {
+		l.Error("не смог сохранить неподтверждённые заявки", zap.String("fileName", s.fileName), zap.Error(err))
+	}
+}
+
+func (s *orderSubmitter) load() error {
+	data, err := os.ReadFile(s.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var orders []*pendingOrder
+	if err := json.Unmarshal(data, &orders); err != nil {
+		return err
+	}
+	for _, o := range orders {
+		s.pending[o.OrderId] = o
+		s.unresolved[o.OrderId] = true
+	}
+	if len(orders) > 0 {
+		l.Info("есть неподтверждённые заявки из прошлого запуска", zap.String("fileName", s.fileName), zap.Int("count", len(orders)))
+	}
+	return nil
+}
+
+// сохраняет неподтверждённые заявки. Вызывается под блокировкой. Файл перезаписывается через временный,
+// чтобы при падении программы не остался обрезанный файл
+func (s *orderSubmitter) save() error {
+	if len(s.pending) == 0 {
+		if err := os.Remove(s.fileName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	orders := make([]*pendingOrder, 0, len(s.pending))
+	for _, o := range s.pending {
+		orders = append(orders, o)
+	}
+	data, err := json.MarshalIndent(orders, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.fileName), os.ModePerm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	tmp := s.fileName + ".tmp"
+	if err := os.WriteFile(tmp, data, pendingOrdersFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.fileName)
+}
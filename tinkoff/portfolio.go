@@ -69,7 +69,29 @@ func (c *Client) GetPortfolio(ctx context.Context, accountId string) (*Portfolio
 		AccountId: accountId,
 	})
 	if err != nil {
-		l.DPanic("GetPortfolio", zap.Error(err))
+		l.Error("GetPortfolio", zap.Error(err))
+		return nil, err
 	}
-	return NewPortfolio(resp), err
+	return NewPortfolio(resp), nil
+}
+
+func (c *Client) GetSandboxPortfolio(ctx context.Context, accountId string) (*Portfolio, error) {
+	resp, err := c.GetSandboxServiceClient().GetSandboxPortfolio(ctx, &proto.PortfolioRequest{
+		AccountId: accountId,
+	})
+	if err != nil {
+		l.Error("GetSandboxPortfolio", zap.Error(err))
+		return nil, err
+	}
+	return NewPortfolio(resp), nil
+}
+
+//Портфель по счёту.
+func (ra *RealAccount) GetPortfolio(ctx context.Context) (*Portfolio, error) {
+	return ra.client.GetPortfolio(ctx, ra.id)
+}
+
+//Портфель по счёту песочницы.
+func (sa *SandboxAccount) GetPortfolio(ctx context.Context) (*Portfolio, error) {
+	return sa.client.GetSandboxPortfolio(ctx, sa.id)
 }
@@ -0,0 +1,63 @@
+package tinkoff
+
+// Переподключение потоков.
+// Пауза между попытками растёт вдвое, но не больше maxReconnectDelay, и к ней добавляется случайная
+// составляющая, чтобы после сбоя сети все потоки не переподключались одновременно
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+func NewBackoff() *Backoff {
+	return &Backoff{Min: minReconnectDelay, Max: maxReconnectDelay}
+}
+
+// пауза перед очередной попыткой: случайное значение от половины до полной задержки
+func (b *Backoff) Next() time.Duration {
+	delay := b.Min << b.attempt
+	if delay > b.Max || delay <= 0 {
+		delay = b.Max
+	} else {
+		b.attempt++
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// повторяет connect с паузами, пока он не выполнится без ошибки, или пока не будет отменён ctx
+func reconnectLoop(ctx context.Context, name string, connect func() error) error {
+	backoff := NewBackoff()
+	for {
+		delay := backoff.Next()
+		l.Info("переподключение", zap.String("stream", name), zap.Duration("delay", delay))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err := connect()
+		if err == nil {
+			reconnectsMetric.WithLabelValues(name).Inc()
+			return nil
+		}
+		l.Error("не удалось переподключиться", zap.String("stream", name), zap.Error(err))
+	}
+}
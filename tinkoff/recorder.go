@@ -0,0 +1,252 @@
+package tinkoff
+
+// Запись потока рыночных данных (свечи, стаканы, обезличенные сделки, последние цены) на диск.
+// Данные пишутся в csv файлы dataDir/record/YYYY-MM-DD/FIGI_type.csv, файлы меняются в полночь
+// по часовому поясу alex.Location(). Запись дописывает файлы, поэтому её можно перезапускать.
+// Переподключение к потоку выполняет MarketDataStream, подписки при этом восстанавливаются
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+const (
+	RecordType_CANDLES    = "candles"
+	RecordType_ORDERBOOK  = "orderbook"
+	RecordType_TRADES     = "trades"
+	RecordType_LAST_PRICE = "lastprice"
+)
+
+var recordHeaders = map[string]string{
+	RecordType_CANDLES:    "Received,Time,Interval,Open,High,Low,Close,Volume,LastTrade",
+	RecordType_ORDERBOOK:  "Received,Time,Depth,IsConsistent,Bids,Asks,LimitUp,LimitDown",
+	RecordType_TRADES:     "Received,Time,Direction,Price,Quantity",
+	RecordType_LAST_PRICE: "Received,Time,Price",
+}
+
+// какие данные инструмента записывать
+type RecordOptions struct {
+	Candles        bool
+	CandlesPeriod  time.Duration
+	OrderBook      bool
+	OrderBookDepth int32
+	Trades         bool
+	LastPrice      bool
+}
+
+type recordFile struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+type Recorder struct {
+	client        *Client
+	dir           string
+	figis         map[string]bool
+	subscriptions []SubscriptionKey
+	raw           MarketDataChan // берётся до первой подписки, чтобы не потерять первые сообщения
+	day           string
+	files         map[string]*recordFile // figi_type -> файл текущего дня
+}
+
+func NewRecorder(client *Client, dataDir string) *Recorder {
+	return &Recorder{
+		client: client,
+		dir:    path.Join(dataDir, "record"),
+		figis:  make(map[string]bool),
+		files:  make(map[string]*recordFile),
+	}
+}
+
+// подписаться на данные инструмента
+func (r *Recorder) Add(figi string, opts RecordOptions) error {
+	if r.client.Instruments.Get(figi) == nil {
+		return fmt.Errorf("инструмент %s не найден", figi)
+	}
+	r.figis[figi] = true
+	var keys []SubscriptionKey
+	if opts.Candles {
+		// свечи из потока передаются в объект свечей, поэтому он должен существовать
+		r.client.Instruments.Get(figi).GetCandles(opts.CandlesPeriod)
+		keys = append(keys, CandlesSubscription(figi, alex.Duration2SubscriptionInterval(opts.CandlesPeriod)))
+	}
+	if opts.OrderBook {
+		keys = append(keys, OrderBookSubscription(figi, opts.OrderBookDepth))
+	}
+	if opts.Trades {
+		keys = append(keys, TradesSubscription(figi))
+	}
+	if opts.LastPrice {
+		keys = append(keys, LastPriceSubscription(figi))
+	}
+	stream := r.client.GetMarketDataStream()
+	if r.raw == nil {
+		r.raw = stream.SubscribeRaw()
+	}
+	for _, key := range keys {
+		if err := stream.Subscriptions.Subscribe(key); err != nil {
+			return err
+		}
+		r.subscriptions = append(r.subscriptions, key)
+	}
+	return nil
+}
+
+// записывать данные, пока не будет отменён ctx
+func (r *Recorder) Run(ctx context.Context) error {
+	stream := r.client.GetMarketDataStream()
+	if r.raw == nil {
+		r.raw = stream.SubscribeRaw()
+	}
+	ch := r.raw
+	defer func() {
+		stream.UnsubscribeRaw(ch) //nolint:golint,errcheck
+		r.raw = nil
+	}()
+	defer r.Close()
+	defer func() {
+		for _, key := range r.subscriptions {
+			stream.Subscriptions.Unsubscribe(key) //nolint:golint,errcheck
+		}
+		r.subscriptions = nil
+	}()
+
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-flush.C:
+			if err := r.flush(); err != nil {
+				return err
+			}
+		case marketdata := <-ch:
+			if err := r.write(time.Now(), marketdata); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (r *Recorder) write(received time.Time, marketdata *proto.MarketDataResponse) error {
+	if c := marketdata.GetCandle(); c != nil && r.figis[c.Figi] {
+		return r.writeLine(received, c.Figi, RecordType_CANDLES,
+			formatTimestamp(c.Time.AsTime()),
+			alex.SubscriptionInterval2Duration(c.Interval).String(),
+			alex.NewDecimal(c.Open).String(),
+			alex.NewDecimal(c.High).String(),
+			alex.NewDecimal(c.Low).String(),
+			alex.NewDecimal(c.Close).String(),
+			fmt.Sprint(c.Volume),
+			formatTimestamp(c.LastTradeTs.AsTime()),
+		)
+	}
+	if ob := marketdata.GetOrderbook(); ob != nil && r.figis[ob.Figi] {
+		return r.writeLine(received, ob.Figi, RecordType_ORDERBOOK,
+			formatTimestamp(ob.Time.AsTime()),
+			fmt.Sprint(ob.Depth),
+			fmt.Sprint(ob.IsConsistent),
+			formatOrders(ob.Bids),
+			formatOrders(ob.Asks),
+			alex.NewDecimal(ob.LimitUp).String(),
+			alex.NewDecimal(ob.LimitDown).String(),
+		)
+	}
+	if t := marketdata.GetTrade(); t != nil && r.figis[t.Figi] {
+		return r.writeLine(received, t.Figi, RecordType_TRADES,
+			formatTimestamp(t.Time.AsTime()),
+			strings.Replace(t.Direction.String(), "TRADE_DIRECTION_", "", 1),
+			alex.NewDecimal(t.Price).String(),
+			fmt.Sprint(t.Quantity),
+		)
+	}
+	if lp := marketdata.GetLastPrice(); lp != nil && r.figis[lp.Figi] {
+		return r.writeLine(received, lp.Figi, RecordType_LAST_PRICE,
+			formatTimestamp(lp.Time.AsTime()),
+			alex.NewDecimal(lp.Price).String(),
+		)
+	}
+	return nil
+}
+
+func (r *Recorder) writeLine(received time.Time, figi string, recordType string, fields ...string) error {
+	day := received.In(alex.Location()).Format("2006-01-02")
+	if day != r.day {
+		r.Close()
+		r.day = day
+	}
+	f, err := r.getFile(figi, recordType)
+	if err != nil {
+		return err
+	}
+	_, err = f.writer.WriteString(formatTimestamp(received) + "," + strings.Join(fields, ",") + "\n")
+	return err
+}
+
+func (r *Recorder) getFile(figi string, recordType string) (*recordFile, error) {
+	key := figi + "_" + recordType
+	if f, ok := r.files[key]; ok {
+		return f, nil
+	}
+	dir := path.Join(r.dir, r.day)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil && !os.IsExist(err) {
+		l.Error("не смог создать каталог", zap.String("path", dir), zap.Error(err))
+		return nil, err
+	}
+	fileName := path.Join(dir, key+".csv")
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		l.Error("не открыть файл", zap.String("fileName", fileName), zap.Error(err))
+		return nil, err
+	}
+	f := &recordFile{file: file, writer: bufio.NewWriter(file)}
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		f.writer.WriteString(recordHeaders[recordType] + "\n") //nolint:golint,errcheck
+	}
+	r.files[key] = f
+	return f, nil
+}
+
+func (r *Recorder) flush() error {
+	for _, f := range r.files {
+		if err := f.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// сбрасывает данные на диск и закрывает файлы
+func (r *Recorder) Close() {
+	for key, f := range r.files {
+		if err := f.writer.Flush(); err != nil {
+			l.Error("не смог записать в файл", zap.String("fileName", f.file.Name()), zap.Error(err))
+		}
+		f.file.Close()
+		delete(r.files, key)
+	}
+}
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// заявки стакана в виде цена:количество через пробел
+func formatOrders(orders []*proto.Order) string {
+	result := make([]string, len(orders))
+	for i, o := range orders {
+		result[i] = alex.NewDecimal(o.Price).String() + ":" + fmt.Sprint(o.Quantity)
+	}
+	return strings.Join(result, " ")
+}
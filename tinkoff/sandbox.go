@@ -13,7 +13,7 @@ func (c *Client) OpenSandboxAccount(ctx context.Context) (string, error) {
 	sandboxAccount, err := c.GetSandboxServiceClient().OpenSandboxAccount(ctx,
 		&proto.OpenSandboxAccountRequest{})
 	if err != nil {
-		l.DPanic("OpenSandboxAccount", zap.Error(err))
+		l.Error("OpenSandboxAccount", zap.Error(err))
 		return "", err
 	}
 
@@ -24,7 +24,7 @@ func (c *Client) CloseSandboxAccount(ctx context.Context, accountId string) erro
 	_, err := c.GetSandboxServiceClient().CloseSandboxAccount(ctx,
 		&proto.CloseSandboxAccountRequest{AccountId: accountId})
 	if err != nil {
-		l.DPanic("CloseSandboxAccount", zap.Error(err))
+		l.Error("CloseSandboxAccount", zap.Error(err))
 		return err
 	}
 	return nil
@@ -40,7 +40,7 @@ func (c *Client) SandboxPayIn(ctx context.Context, accountId string, rub big.Dec
 			}),
 		})
 	if err != nil {
-		l.DPanic("SandboxPayIn", zap.Error(err))
+		l.Error("SandboxPayIn", zap.Error(err))
 		return nil, err
 	}
 	return alex.NewMoney(res.Balance), nil
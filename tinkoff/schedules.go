@@ -0,0 +1,77 @@
+package tinkoff
+
+// Расписания торгов площадок. Загружаются через TradingSchedules при первом обращении и кешируются в Schedule
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+type Schedules struct {
+	client    *Client
+	locker    sync.Mutex
+	exchanges map[string]*alex.Schedule
+}
+
+func NewSchedules(client *Client) *Schedules {
+	return &Schedules{
+		client:    client,
+		exchanges: make(map[string]*alex.Schedule),
+	}
+}
+
+// расписание площадки. Площадки Тинькофф работают по московскому времени
+func (s *Schedules) Get(exchange string) *alex.Schedule {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	schedule, ok := s.exchanges[exchange]
+	if !ok {
+		schedule = alex.NewSchedule(alex.MoscowLocation(), func(ctx context.Context, from time.Time, to time.Time) ([]*alex.TradingDay, error) {
+			return s.load(ctx, exchange, from, to)
+		})
+		s.exchanges[exchange] = schedule
+	}
+	return schedule
+}
+
+func (s *Schedules) load(ctx context.Context, exchange string, from time.Time, to time.Time) ([]*alex.TradingDay, error) {
+	l.Debug("запрашиваю расписание торгов", zap.String("exchange", exchange), zap.Time("from", from), zap.Time("to", to))
+	resp, err := s.client.instrumentsServiceClient.TradingSchedules(ctx, &proto.TradingSchedulesRequest{
+		Exchange: exchange,
+		From:     timestamppb.New(from),
+		To:       timestamppb.New(to),
+	})
+	if err != nil {
+		l.Error("TradingSchedules", zap.String("exchange", exchange), zap.Error(err))
+		return nil, err
+	}
+	var result []*alex.TradingDay
+	for _, schedule := range resp.Exchanges {
+		for _, d := range schedule.Days {
+			date := d.Date.AsTime()
+			day := &alex.TradingDay{
+				// дата приходит полночью UTC, а день определяется в часовом поясе площадки
+				Date:         time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, alex.MoscowLocation()),
+				IsTradingDay: d.IsTradingDay,
+				Start:        asTime(d.StartTime),
+				End:          asTime(d.EndTime),
+			}
+			if d.EveningEndTime != nil && d.EveningEndTime.AsTime().After(day.End) {
+				day.End = d.EveningEndTime.AsTime()
+			}
+			result = append(result, day)
+		}
+	}
+	return result, nil
+}
+
+func (i *Instrument) GetSchedule() *alex.Schedule {
+	return i.client.Schedules.Get(i.GetExchange())
+}
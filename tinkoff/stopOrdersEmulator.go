@@ -0,0 +1,169 @@
+package tinkoff
+
+// Эмуляция стоп-заявок для счетов песочницы: сервер песочницы стоп-заявки не поддерживает.
+// Стоп-заявки хранятся в памяти программы, по инструментам с активными стоп-заявками выполняется подписка на
+// последние цены, и при срабатывании стоп-заявки на счёт выставляется биржевая заявка.
+// После перезапуска программы эмулированные стоп-заявки теряются
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sdcoffey/big"
+	"go.uber.org/zap"
+
+	"github.com/go-trading/alex"
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+type stopOrdersEmulator struct {
+	client   *Client
+	account  alex.AccountEngine
+	locker   sync.Mutex
+	orders   map[string]*alex.StopOrder    // идентификатор стоп-заявки -> стоп-заявка
+	watchers map[string]alex.LastPriceChan // figi -> подписка на последние цены
+}
+
+func newStopOrdersEmulator(client *Client, account alex.AccountEngine) *stopOrdersEmulator {
+	return &stopOrdersEmulator{
+		client:   client,
+		account:  account,
+		orders:   make(map[string]*alex.StopOrder),
+		watchers: make(map[string]alex.LastPriceChan),
+	}
+}
+
+func (e *stopOrdersEmulator) post(instrument alex.Instrument, quantity int64, price big.Decimal, stopPrice big.Decimal, direction proto.StopOrderDirection, stopOrderType proto.StopOrderType, expireDate time.Time) (string, error) {
+	if stopPrice.NaN() {
+		return "", errors.New("не указана стоп-цена")
+	}
+	if stopOrderType == proto.StopOrderType_STOP_ORDER_TYPE_STOP_LIMIT && price.NaN() {
+		return "", errors.New("для stop-limit заявки нужно указать цену")
+	}
+	stopOrder := &alex.StopOrder{
+		StopOrderId:    uuid.NewString(),
+		Figi:           instrument.GetFigi(),
+		LotsRequested:  quantity,
+		Direction:      direction,
+		Currency:       instrument.GetCurrency(),
+		OrderType:      stopOrderType,
+		CreateDate:     time.Now().UTC(),
+		ExpirationTime: expireDate,
+		Price:          price,
+		StopPrice:      stopPrice,
+	}
+
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	if _, ok := e.watchers[stopOrder.Figi]; !ok {
+		ch, err := instrument.SubscribeLastPrice()
+		if err != nil {
+			l.Error("не смог подписаться на последние цены для стоп-заявки", zap.String("figi", stopOrder.Figi), zap.Error(err))
+			return "", err
+		}
+		e.watchers[stopOrder.Figi] = ch
+		go e.watch(instrument, ch)
+	}
+	e.orders[stopOrder.StopOrderId] = stopOrder
+	l.Info("эмулирую стоп-заявку", zap.Any("stopOrder", stopOrder))
+	return stopOrder.StopOrderId, nil
+}
+
+func (e *stopOrdersEmulator) list() []*alex.StopOrder {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	result := make([]*alex.StopOrder, 0, len(e.orders))
+	for _, o := range e.orders {
+		result = append(result, o)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreateDate.Before(result[j].CreateDate) })
+	return result
+}
+
+func (e *stopOrdersEmulator) cancel(stopOrderId string) (time.Time, error) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	stopOrder, ok := e.orders[stopOrderId]
+	if !ok {
+		return time.Time{}, fmt.Errorf("стоп-заявка %s: %w", stopOrderId, alex.ErrNotFound)
+	}
+	delete(e.orders, stopOrderId)
+	e.unwatchIfIdle(stopOrder.Figi)
+	return time.Now().UTC(), nil
+}
+
+// отписывается от последних цен инструмента, если по нему не осталось стоп-заявок. Вызывается под блокировкой
+func (e *stopOrdersEmulator) unwatchIfIdle(figi string) {
+	for _, o := range e.orders {
+		if o.Figi == figi {
+			return
+		}
+	}
+	ch, ok := e.watchers[figi]
+	if !ok {
+		return
+	}
+	delete(e.watchers, figi)
+	if err := e.client.GetInstrument(figi).UnsubscribeLastPrice(ch); err != nil {
+		l.Error("UnsubscribeLastPrice", zap.String("figi", figi), zap.Error(err))
+	}
+}
+
+// проверяет стоп-заявки инструмента при каждой новой цене. Завершается, когда отписываемся от цен
+func (e *stopOrdersEmulator) watch(instrument alex.Instrument, ch alex.LastPriceChan) {
+	for lastPrice := range ch {
+		for _, stopOrder := range e.triggered(instrument.GetFigi(), lastPrice) {
+			e.activate(instrument, stopOrder, lastPrice.Price)
+		}
+	}
+}
+
+// убирает из списка и возвращает сработавшие стоп-заявки. Просроченные стоп-заявки снимаются
+func (e *stopOrdersEmulator) triggered(figi string, lastPrice *alex.LastPrice) (result []*alex.StopOrder) {
+	e.locker.Lock()
+	defer e.locker.Unlock()
+	now := time.Now()
+	for id, o := range e.orders {
+		if o.Figi != figi {
+			continue
+		}
+		if o.IsExpired(now) {
+			l.Info("истёк срок стоп-заявки", zap.String("stopOrderId", id))
+			delete(e.orders, id)
+			continue
+		}
+		if o.IsTriggered(lastPrice.Price) {
+			o.ActivationDate = now.UTC()
+			delete(e.orders, id)
+			result = append(result, o)
+		}
+	}
+	e.unwatchIfIdle(figi)
+	return result
+}
+
+// выставляет биржевую заявку по сработавшей стоп-заявке
+func (e *stopOrdersEmulator) activate(instrument alex.Instrument, stopOrder *alex.StopOrder, lastPrice big.Decimal) {
+	price := lastPrice
+	if stopOrder.GetOrderType() == proto.OrderType_ORDER_TYPE_LIMIT {
+		price = stopOrder.Price
+	}
+	l.Info("сработала стоп-заявка",
+		zap.String("stopOrderId", stopOrder.StopOrderId),
+		zap.String("figi", stopOrder.Figi),
+		zap.String("lastPrice", lastPrice.String()),
+	)
+	ctx := e.client.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, err := e.account.PostOrder(ctx, instrument, stopOrder.LotsRequested, price, stopOrder.GetOrderDirection(), stopOrder.GetOrderType(), uuid.NewString())
+	if err != nil {
+		l.Error("не смог выставить заявку по сработавшей стоп-заявке", zap.String("stopOrderId", stopOrder.StopOrderId), zap.Error(err))
+	}
+}
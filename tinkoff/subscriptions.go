@@ -0,0 +1,388 @@
+package tinkoff
+
+// Учёт подписок потока рыночных данных.
+// Каждая подписка (figi, тип данных, интервал свечей или глубина стакана) считается по количеству ссылок:
+// на сервере подписка оформляется при первом Subscribe, и отменяется после последнего Unsubscribe.
+// На статус торгов (info) подписка оформляется автоматически, пока есть хоть одна подписка на свечи инструмента.
+// Ответы сервера на подписки разбираются в onResponse, ошибочные подписки запоминаются со статусом
+// и не учитываются в лимите подписок, т.к. сервер их не оформил
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+)
+
+// тип данных подписки
+type SubscriptionType int32
+
+const (
+	SubscriptionType_CANDLES    SubscriptionType = iota // свечи
+	SubscriptionType_ORDERBOOK  SubscriptionType = iota // стакан
+	SubscriptionType_TRADES     SubscriptionType = iota // обезличенные сделки
+	SubscriptionType_LAST_PRICE SubscriptionType = iota // последние цены
+	SubscriptionType_INFO       SubscriptionType = iota // статус торгов
+)
+
+var SubscriptionType2string = map[SubscriptionType]string{
+	SubscriptionType_CANDLES:    "candles",
+	SubscriptionType_ORDERBOOK:  "orderbook",
+	SubscriptionType_TRADES:     "trades",
+	SubscriptionType_LAST_PRICE: "lastprice",
+	SubscriptionType_INFO:       "info",
+}
+
+func (t SubscriptionType) String() string {
+	return SubscriptionType2string[t]
+}
+
+var ErrSubscriptionLimit = errors.New("превышен лимит подписок в потоке рыночных данных")
+
+// подписка. Interval заполняется только для свечей, Depth - только для стакана
+type SubscriptionKey struct {
+	Type     SubscriptionType
+	Figi     string
+	Interval proto.SubscriptionInterval
+	Depth    int32
+}
+
+func CandlesSubscription(figi string, interval proto.SubscriptionInterval) SubscriptionKey {
+	return SubscriptionKey{Type: SubscriptionType_CANDLES, Figi: figi, Interval: interval}
+}
+func OrderBookSubscription(figi string, depth int32) SubscriptionKey {
+	return SubscriptionKey{Type: SubscriptionType_ORDERBOOK, Figi: figi, Depth: depth}
+}
+func TradesSubscription(figi string) SubscriptionKey {
+	return SubscriptionKey{Type: SubscriptionType_TRADES, Figi: figi}
+}
+func LastPriceSubscription(figi string) SubscriptionKey {
+	return SubscriptionKey{Type: SubscriptionType_LAST_PRICE, Figi: figi}
+}
+func InfoSubscription(figi string) SubscriptionKey {
+	return SubscriptionKey{Type: SubscriptionType_INFO, Figi: figi}
+}
+
+func (k SubscriptionKey) String() string {
+	switch k.Type {
+	case SubscriptionType_CANDLES:
+		return fmt.Sprintf("%s %s %s", k.Type, k.Figi, k.Interval)
+	case SubscriptionType_ORDERBOOK:
+		return fmt.Sprintf("%s %s %d", k.Type, k.Figi, k.Depth)
+	default:
+		return fmt.Sprintf("%s %s", k.Type, k.Figi)
+	}
+}
+
+// подписка без ссылок (refs == 0) оформлена на сервере, но уже никому не нужна: отписаться от неё не удалось
+type subscription struct {
+	refs   int
+	status proto.SubscriptionStatus
+}
+
+// сервер отклонил подписку
+func (item *subscription) rejected() bool {
+	return item.status != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_UNSPECIFIED &&
+		item.status != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS
+}
+
+type Subscriptions struct {
+	locker sync.Mutex
+	stream *MarketDataStream
+	items  map[SubscriptionKey]*subscription
+}
+
+func NewSubscriptions(stream *MarketDataStream) *Subscriptions {
+	return &Subscriptions{
+		stream: stream,
+		items:  make(map[SubscriptionKey]*subscription),
+	}
+}
+
+// добавить ссылку на подписку, и при необходимости подписаться на сервере
+func (s *Subscriptions) Subscribe(key SubscriptionKey) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	keys := []SubscriptionKey{key}
+	if key.Type == SubscriptionType_CANDLES {
+		keys = append(keys, InfoSubscription(key.Figi))
+	}
+	var newKeys []SubscriptionKey
+	added := 0 // подписки без ссылок уже учтены в count
+	for _, k := range keys {
+		if item, ok := s.items[k]; !ok || item.refs == 0 {
+			newKeys = append(newKeys, k)
+			if !ok {
+				added++
+			}
+		}
+	}
+	// лимит берётся из тарифа при каждой подписке, т.к. тариф загружается при Open
+	limit := s.stream.client.limit.SubscriptionLimit()
+	if added > 0 && s.count()+added > limit {
+		l.Error("превышен лимит подписок", zap.Stringer("subscription", key), zap.Int("limit", limit))
+		return ErrSubscriptionLimit
+	}
+	for _, k := range keys {
+		item, ok := s.items[k]
+		if !ok {
+			item = &subscription{}
+			s.items[k] = item
+		}
+		item.refs++
+	}
+	if len(newKeys) == 0 {
+		return nil
+	}
+	sent, err := s.send(proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, newKeys)
+	if err != nil {
+		// подписка не оформлена, ссылки возвращаю обратно. Часть запросов могла уйти на сервер,
+		// от таких подписок отписываюсь, а если не получилось, то они остаются без ссылок до переподключения
+		for _, k := range keys {
+			if !sent[k] {
+				s.release(k)
+			} else {
+				s.items[k].refs--
+			}
+		}
+		var orphans []SubscriptionKey
+		for k := range sent {
+			if s.items[k].refs == 0 {
+				orphans = append(orphans, k)
+			}
+		}
+		if len(orphans) > 0 {
+			unsubscribed, _ := s.send(proto.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, orphans)
+			for k := range unsubscribed {
+				delete(s.items, k)
+			}
+		}
+	}
+	return err
+}
+
+// убрать ссылку на подписку, и отписаться на сервере, если ссылок не осталось
+func (s *Subscriptions) Unsubscribe(key SubscriptionKey) error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	keys := []SubscriptionKey{key}
+	if key.Type == SubscriptionType_CANDLES {
+		keys = append(keys, InfoSubscription(key.Figi))
+	}
+	// сначала проверяю все ключи, чтобы при ошибке не освободить часть ссылок
+	for _, k := range keys {
+		if item, ok := s.items[k]; !ok || item.refs == 0 {
+			l.DPanic("отписываюсь не подписавшись", zap.Stringer("subscription", k))
+			return errors.New("NO SUBSCRIPTION")
+		}
+	}
+	var releasedKeys []SubscriptionKey
+	for _, k := range keys {
+		// отклонённой подписки на сервере нет, отписываться от неё не нужно
+		if rejected := s.items[k].rejected(); s.release(k) && !rejected {
+			releasedKeys = append(releasedKeys, k)
+		}
+	}
+	if len(releasedKeys) == 0 {
+		return nil
+	}
+	_, err := s.send(proto.SubscriptionAction_SUBSCRIPTION_ACTION_UNSUBSCRIBE, releasedKeys)
+	return err
+}
+
+// уменьшает количество ссылок. Возвращает true, если ссылок не осталось
+func (s *Subscriptions) release(key SubscriptionKey) bool {
+	item := s.items[key]
+	item.refs--
+	if item.refs > 0 {
+		return false
+	}
+	delete(s.items, key)
+	return true
+}
+
+// количество активных подписок на сервере. Отклонённые сервером подписки не учитываются
+func (s *Subscriptions) count() int {
+	count := 0
+	for _, item := range s.items {
+		if !item.rejected() {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Subscriptions) Count() int {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.count()
+}
+
+// есть ли подписка на сервере
+func (s *Subscriptions) Has(key SubscriptionKey) bool {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	_, ok := s.items[key]
+	return ok
+}
+
+// статус подписки по последнему ответу сервера. UNSPECIFIED - ответа ещё не было, или подписки нет
+func (s *Subscriptions) Status(key SubscriptionKey) proto.SubscriptionStatus {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	if item, ok := s.items[key]; ok {
+		return item.status
+	}
+	return proto.SubscriptionStatus_SUBSCRIPTION_STATUS_UNSPECIFIED
+}
+
+// повторно отправить все подписки, например после переподключения
+func (s *Subscriptions) Resubscribe() error {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+
+	keys := make([]SubscriptionKey, 0, len(s.items))
+	for k, item := range s.items {
+		if item.refs == 0 {
+			// в новом потоке ненужной подписки уже нет
+			delete(s.items, k)
+			continue
+		}
+		item.status = proto.SubscriptionStatus_SUBSCRIPTION_STATUS_UNSPECIFIED
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.send(proto.SubscriptionAction_SUBSCRIPTION_ACTION_SUBSCRIBE, keys)
+	return err
+}
+
+// отправляет подписки, сгруппировав их по типам данных. Возвращает подписки, запросы по которым отправлены,
+// т.к. при ошибке часть запросов может уже уйти на сервер
+func (s *Subscriptions) send(action proto.SubscriptionAction, keys []SubscriptionKey) (map[SubscriptionKey]bool, error) {
+	byType := make(map[SubscriptionType][]SubscriptionKey)
+	for _, k := range keys {
+		byType[k.Type] = append(byType[k.Type], k)
+	}
+
+	sent := make(map[SubscriptionKey]bool)
+	for _, t := range []SubscriptionType{
+		SubscriptionType_CANDLES,
+		SubscriptionType_INFO,
+		SubscriptionType_ORDERBOOK,
+		SubscriptionType_TRADES,
+		SubscriptionType_LAST_PRICE,
+	} {
+		if len(byType[t]) == 0 {
+			continue
+		}
+		if err := s.stream.send(subscriptionRequest(action, t, byType[t])); err != nil {
+			return sent, err
+		}
+		for _, k := range byType[t] {
+			sent[k] = true
+		}
+	}
+	return sent, nil
+}
+
+// запрос подписки на данные одного типа
+func subscriptionRequest(action proto.SubscriptionAction, t SubscriptionType, keys []SubscriptionKey) *proto.MarketDataRequest {
+	switch t {
+	case SubscriptionType_CANDLES:
+		instruments := make([]*proto.CandleInstrument, 0, len(keys))
+		for _, k := range keys {
+			instruments = append(instruments, &proto.CandleInstrument{Figi: k.Figi, Interval: k.Interval})
+		}
+		return &proto.MarketDataRequest{Payload: &proto.MarketDataRequest_SubscribeCandlesRequest{
+			SubscribeCandlesRequest: &proto.SubscribeCandlesRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	case SubscriptionType_ORDERBOOK:
+		instruments := make([]*proto.OrderBookInstrument, 0, len(keys))
+		for _, k := range keys {
+			instruments = append(instruments, &proto.OrderBookInstrument{Figi: k.Figi, Depth: k.Depth})
+		}
+		return &proto.MarketDataRequest{Payload: &proto.MarketDataRequest_SubscribeOrderBookRequest{
+			SubscribeOrderBookRequest: &proto.SubscribeOrderBookRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	case SubscriptionType_TRADES:
+		instruments := make([]*proto.TradeInstrument, 0, len(keys))
+		for _, k := range keys {
+			instruments = append(instruments, &proto.TradeInstrument{Figi: k.Figi})
+		}
+		return &proto.MarketDataRequest{Payload: &proto.MarketDataRequest_SubscribeTradesRequest{
+			SubscribeTradesRequest: &proto.SubscribeTradesRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	case SubscriptionType_LAST_PRICE:
+		instruments := make([]*proto.LastPriceInstrument, 0, len(keys))
+		for _, k := range keys {
+			instruments = append(instruments, &proto.LastPriceInstrument{Figi: k.Figi})
+		}
+		return &proto.MarketDataRequest{Payload: &proto.MarketDataRequest_SubscribeLastPriceRequest{
+			SubscribeLastPriceRequest: &proto.SubscribeLastPriceRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	default:
+		instruments := make([]*proto.InfoInstrument, 0, len(keys))
+		for _, k := range keys {
+			instruments = append(instruments, &proto.InfoInstrument{Figi: k.Figi})
+		}
+		return &proto.MarketDataRequest{Payload: &proto.MarketDataRequest_SubscribeInfoRequest{
+			SubscribeInfoRequest: &proto.SubscribeInfoRequest{SubscriptionAction: action, Instruments: instruments},
+		}}
+	}
+}
+
+// обработка ответа сервера на подписку. Возвращает true, если сообщение было ответом на подписку
+func (s *Subscriptions) onResponse(marketdata *proto.MarketDataResponse) bool {
+	var statuses map[SubscriptionKey]proto.SubscriptionStatus
+	switch {
+	case marketdata.GetSubscribeCandlesResponse() != nil:
+		statuses = make(map[SubscriptionKey]proto.SubscriptionStatus)
+		for _, sub := range marketdata.GetSubscribeCandlesResponse().CandlesSubscriptions {
+			statuses[CandlesSubscription(sub.Figi, sub.Interval)] = sub.SubscriptionStatus
+		}
+	case marketdata.GetSubscribeOrderBookResponse() != nil:
+		statuses = make(map[SubscriptionKey]proto.SubscriptionStatus)
+		for _, sub := range marketdata.GetSubscribeOrderBookResponse().OrderBookSubscriptions {
+			statuses[OrderBookSubscription(sub.Figi, sub.Depth)] = sub.SubscriptionStatus
+		}
+	case marketdata.GetSubscribeTradesResponse() != nil:
+		statuses = make(map[SubscriptionKey]proto.SubscriptionStatus)
+		for _, sub := range marketdata.GetSubscribeTradesResponse().TradeSubscriptions {
+			statuses[TradesSubscription(sub.Figi)] = sub.SubscriptionStatus
+		}
+	case marketdata.GetSubscribeLastPriceResponse() != nil:
+		statuses = make(map[SubscriptionKey]proto.SubscriptionStatus)
+		for _, sub := range marketdata.GetSubscribeLastPriceResponse().LastPriceSubscriptions {
+			statuses[LastPriceSubscription(sub.Figi)] = sub.SubscriptionStatus
+		}
+	case marketdata.GetSubscribeInfoResponse() != nil:
+		statuses = make(map[SubscriptionKey]proto.SubscriptionStatus)
+		for _, sub := range marketdata.GetSubscribeInfoResponse().InfoSubscriptions {
+			statuses[InfoSubscription(sub.Figi)] = sub.SubscriptionStatus
+		}
+	default:
+		return false
+	}
+
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	for key, status := range statuses {
+		item, ok := s.items[key]
+		if !ok {
+			// ответ на отписку
+			continue
+		}
+		item.status = status
+		if status != proto.SubscriptionStatus_SUBSCRIPTION_STATUS_SUCCESS {
+			l.Error("сервер отклонил подписку", zap.Stringer("subscription", key), zap.Stringer("status", status))
+		}
+	}
+	return true
+}
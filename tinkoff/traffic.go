@@ -0,0 +1,456 @@
+package tinkoff
+
+// Запись и воспроизведение обмена с api. TrafficRecorder пишет все запросы, ответы и сообщения потоков сессии
+// клиента в файл, по одному json объекту в строке. TrafficReplayer отдаёт записанные ответы вместо сервера, так
+// сессию бота можно повторить без сети в точности как она прошла. Перехватчики стоят ближе всего к сети,
+// поэтому в файл попадают ошибки grpc как есть, а ограничения запросов и ошибки api работают и при воспроизведении
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	TrafficKind_UNARY = "unary" // запрос и ответ унарного метода
+	TrafficKind_OPEN  = "open"  // открытие потока
+	TrafficKind_SEND  = "send"  // сообщение клиента в поток
+	TrafficKind_RECV  = "recv"  // сообщение сервера из потока
+	TrafficKind_CLOSE = "close" // завершение потока, code 0 - штатное (io.EOF)
+)
+
+// одна строка файла записи
+type TrafficEvent struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Method  string          `json:"method"`
+	Stream  int64           `json:"stream,omitempty"` // номер потока в сессии
+	Request json.RawMessage `json:"request,omitempty"`
+	Reply   json.RawMessage `json:"reply,omitempty"`
+	Message json.RawMessage `json:"message,omitempty"` // сообщение потока
+	Code    codes.Code      `json:"code,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Header  metadata.MD     `json:"header,omitempty"`
+	Trailer metadata.MD     `json:"trailer,omitempty"`
+}
+
+func (e *TrafficEvent) setError(err error, trailer metadata.MD) {
+	if err == nil || err == io.EOF {
+		return
+	}
+	s := status.Convert(err)
+	e.Code = s.Code()
+	e.Error = s.Message()
+	e.Trailer = trailer
+}
+
+func (e *TrafficEvent) err() error {
+	if e.Code == codes.OK {
+		return nil
+	}
+	return status.Error(e.Code, e.Error)
+}
+
+func marshalMessage(m interface{}) json.RawMessage {
+	msg, ok := m.(protobuf.Message)
+	if !ok {
+		return nil
+	}
+	data, err := protojson.Marshal(msg)
+	if err != nil {
+		l.Error("не смог записать сообщение", zap.Error(err))
+		return nil
+	}
+	return data
+}
+
+func unmarshalMessage(data json.RawMessage, m interface{}) error {
+	msg, ok := m.(protobuf.Message)
+	if !ok {
+		return fmt.Errorf("сообщение %T не protobuf", m)
+	}
+	if len(data) == 0 {
+		protobuf.Reset(msg)
+		return nil
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(data, msg)
+}
+
+// запись обмена с api в файл
+type TrafficRecorder struct {
+	locker     sync.Mutex
+	file       *os.File
+	writer     *bufio.Writer
+	encoder    *json.Encoder
+	lastStream int64
+}
+
+// создаёт файл записи. Если файл есть, он перезаписывается
+func NewTrafficRecorder(fileName string) (*TrafficRecorder, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	r := &TrafficRecorder{file: f, writer: bufio.NewWriter(f)}
+	r.encoder = json.NewEncoder(r.writer)
+	return r, nil
+}
+
+// опции соединения с перехватчиками записи
+func (r *TrafficRecorder) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(r.unary),
+		grpc.WithChainStreamInterceptor(r.stream),
+	}
+}
+
+func (r *TrafficRecorder) write(e *TrafficEvent) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.file == nil {
+		return
+	}
+	// строка сбрасывается на диск сразу, чтобы запись пережила падение бота
+	if err := r.encoder.Encode(e); err != nil {
+		l.Error("не смог записать обмен с api", zap.Error(err))
+		return
+	}
+	if err := r.writer.Flush(); err != nil {
+		l.Error("не смог записать обмен с api", zap.Error(err))
+	}
+}
+
+func (r *TrafficRecorder) Close() error {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.writer.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
+
+func (r *TrafficRecorder) unary(ctx context.Context,
+	method string,
+	req interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	var header, trailer metadata.MD
+	e := &TrafficEvent{Time: time.Now(), Kind: TrafficKind_UNARY, Method: method, Request: marshalMessage(req)}
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Header(&header), grpc.Trailer(&trailer))...)
+	e.Header = header
+	if err != nil {
+		e.setError(err, trailer)
+	} else {
+		e.Reply = marshalMessage(reply)
+	}
+	r.write(e)
+	return err
+}
+
+func (r *TrafficRecorder) stream(ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	e := &TrafficEvent{Time: time.Now(), Kind: TrafficKind_OPEN, Method: method, Stream: atomic.AddInt64(&r.lastStream, 1)}
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	e.setError(err, nil)
+	r.write(e)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingStream{ClientStream: stream, r: r, method: method, id: e.Stream}, nil
+}
+
+type recordingStream struct {
+	grpc.ClientStream
+	r      *TrafficRecorder
+	method string
+	id     int64
+	closed int32
+}
+
+func (s *recordingStream) SendMsg(m interface{}) error {
+	// пишется до отправки: ответ сервера может прийти в RecvMsg раньше, чем SendMsg вернёт управление,
+	// и тогда при воспроизведении ответ ждал бы отправки, записанной после него
+	s.r.write(&TrafficEvent{Time: time.Now(), Kind: TrafficKind_SEND, Method: s.method, Stream: s.id, Message: marshalMessage(m)})
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *recordingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.r.write(&TrafficEvent{Time: time.Now(), Kind: TrafficKind_RECV, Method: s.method, Stream: s.id, Message: marshalMessage(m)})
+		return nil
+	}
+	if atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		e := &TrafficEvent{Time: time.Now(), Kind: TrafficKind_CLOSE, Method: s.method, Stream: s.id}
+		e.setError(err, s.Trailer())
+		s.r.write(e)
+	}
+	return err
+}
+
+// записанный поток
+type trafficStream struct {
+	open   *TrafficEvent
+	events []*TrafficEvent // send, recv и close в порядке записи
+}
+
+// воспроизведение записанного обмена с api вместо сервера
+type TrafficReplayer struct {
+	locker   sync.Mutex
+	unaries  map[string][]*TrafficEvent  // ещё не отданные ответы по методам
+	streams  map[string][]*trafficStream // ещё не открытые потоки по методам
+	realtime bool
+}
+
+// читает файл записи. realtime - отдавать сообщения потоков с теми же паузами, что при записи,
+// иначе сразу, как только клиент их ждёт
+func NewTrafficReplayer(fileName string, realtime bool) (*TrafficReplayer, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &TrafficReplayer{
+		unaries:  make(map[string][]*TrafficEvent),
+		streams:  make(map[string][]*trafficStream),
+		realtime: realtime,
+	}
+	byId := make(map[int64]*trafficStream)
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for line := 1; ; line++ {
+		e := &TrafficEvent{}
+		if err := decoder.Decode(e); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("ошибка чтения записи %s, строка %d: %w", fileName, line, err)
+		}
+		switch e.Kind {
+		case TrafficKind_UNARY:
+			p.unaries[e.Method] = append(p.unaries[e.Method], e)
+		case TrafficKind_OPEN:
+			s := &trafficStream{open: e}
+			byId[e.Stream] = s
+			p.streams[e.Method] = append(p.streams[e.Method], s)
+		case TrafficKind_SEND, TrafficKind_RECV, TrafficKind_CLOSE:
+			s, ok := byId[e.Stream]
+			if !ok {
+				return nil, fmt.Errorf("запись %s, строка %d: поток %d не открыт", fileName, line, e.Stream)
+			}
+			s.events = append(s.events, e)
+		default:
+			return nil, fmt.Errorf("запись %s, строка %d: неизвестный тип %q", fileName, line, e.Kind)
+		}
+	}
+	return p, nil
+}
+
+// опции соединения, при которых запросы до сервера не доходят, а ответы берутся из записи
+func (p *TrafficReplayer) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errors.New("воспроизведение записи, сеть не используется")
+		}),
+		grpc.WithChainUnaryInterceptor(p.unary),
+		grpc.WithChainStreamInterceptor(p.stream),
+	}
+}
+
+// ответ на запрос: первый неотданный с таким же запросом, иначе первый неотданный по этому методу.
+// Так порядок ответов не зависит от того, в каком порядке горутины бота сделали одинаковые запросы
+func (p *TrafficReplayer) takeUnary(method string, req interface{}) *TrafficEvent {
+	p.locker.Lock()
+	defer p.locker.Unlock()
+	events := p.unaries[method]
+	if len(events) == 0 {
+		return nil
+	}
+	found := 0
+	if msg, ok := req.(protobuf.Message); ok {
+		for i, e := range events {
+			recorded := msg.ProtoReflect().New().Interface()
+			if unmarshalMessage(e.Request, recorded) == nil && protobuf.Equal(msg, recorded) {
+				found = i
+				break
+			}
+		}
+	}
+	e := events[found]
+	p.unaries[method] = append(events[:found:found], events[found+1:]...)
+	return e
+}
+
+func (p *TrafficReplayer) unary(ctx context.Context,
+	method string,
+	req interface{},
+	reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	e := p.takeUnary(method, req)
+	if e == nil {
+		return status.Errorf(codes.Unavailable, "в записи больше нет ответов %s", method)
+	}
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = e.Header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = e.Trailer
+		}
+	}
+	if err := e.err(); err != nil {
+		return err
+	}
+	return unmarshalMessage(e.Reply, reply)
+}
+
+func (p *TrafficReplayer) stream(ctx context.Context,
+	desc *grpc.StreamDesc,
+	cc *grpc.ClientConn,
+	method string,
+	streamer grpc.Streamer,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	p.locker.Lock()
+	var s *trafficStream
+	if streams := p.streams[method]; len(streams) > 0 {
+		s, p.streams[method] = streams[0], streams[1:]
+	}
+	p.locker.Unlock()
+
+	if s == nil {
+		// поток без сообщений, пока клиент его не закроет, иначе клиент бесконечно переподключается
+		l.Warn("в записи больше нет потоков", zap.String("method", method))
+		s = &trafficStream{open: &TrafficEvent{Method: method}}
+	}
+	if err := s.open.err(); err != nil {
+		return nil, err
+	}
+	return &replayStream{ctx: ctx, p: p, record: s, started: time.Now(), sent: make(chan struct{}, 1)}, nil
+}
+
+type replayStream struct {
+	ctx     context.Context
+	p       *TrafficReplayer
+	record  *trafficStream
+	started time.Time
+	sent    chan struct{} // сигнал об отправке сообщения клиентом
+	locker  sync.Mutex
+	sends   int // отправлено клиентом и ещё не сопоставлено с записью
+	next    int // следующее событие записи
+	trailer metadata.MD
+}
+
+func (s *replayStream) Header() (metadata.MD, error) { return s.record.open.Header, nil }
+func (s *replayStream) Trailer() metadata.MD {
+	s.locker.Lock()
+	defer s.locker.Unlock()
+	return s.trailer
+}
+func (s *replayStream) CloseSend() error         { return nil }
+func (s *replayStream) Context() context.Context { return s.ctx }
+
+func (s *replayStream) SendMsg(m interface{}) error {
+	s.locker.Lock()
+	s.sends++
+	s.locker.Unlock()
+	select {
+	case s.sent <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// отдаёт следующее сообщение сервера. Сообщение, записанное после отправки клиента, отдаётся только
+// после такой же отправки при воспроизведении, например ответ на подписку
+func (s *replayStream) RecvMsg(m interface{}) error {
+	for {
+		s.locker.Lock()
+		if s.next >= len(s.record.events) {
+			s.locker.Unlock()
+			return s.wait()
+		}
+		e := s.record.events[s.next]
+		if e.Kind == TrafficKind_SEND {
+			if s.sends == 0 {
+				s.locker.Unlock()
+				select {
+				case <-s.sent:
+					continue
+				case <-s.ctx.Done():
+					return status.FromContextError(s.ctx.Err()).Err()
+				}
+			}
+			s.sends--
+			s.next++
+			s.locker.Unlock()
+			continue
+		}
+		s.next++
+		s.locker.Unlock()
+
+		if s.p.realtime && s.record.open.Time.Before(e.Time) {
+			delay := time.Until(s.started.Add(e.Time.Sub(s.record.open.Time)))
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-s.ctx.Done():
+					return status.FromContextError(s.ctx.Err()).Err()
+				}
+			}
+		}
+
+		if e.Kind == TrafficKind_RECV {
+			return unmarshalMessage(e.Message, m)
+		}
+		// close
+		if e.Code == codes.Canceled {
+			// поток закрыл сам клиент, ждём того же при воспроизведении
+			return s.wait()
+		}
+		s.locker.Lock()
+		s.trailer = e.Trailer
+		s.locker.Unlock()
+		if err := e.err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+}
+
+// запись потока закончилась, ждём закрытия потока клиентом
+func (s *replayStream) wait() error {
+	<-s.ctx.Done()
+	return status.FromContextError(s.ctx.Err()).Err()
+}
@@ -0,0 +1,30 @@
+package alex
+
+import (
+	"time"
+
+	proto "github.com/go-trading/alex/tinkoff/proto/1.0.7"
+	"github.com/sdcoffey/big"
+)
+
+//Информация о сделке на бирже (обезличенная сделка).
+type Trade struct {
+	Figi      string               //Figi-идентификатор инструмента.
+	Direction proto.TradeDirection //Направление сделки.
+	Price     big.Decimal          //Цена за 1 инструмент. Для получения стоимости лота требуется умножить на лотность инструмента.
+	Quantity  int64                //Количество лотов.
+	Time      time.Time            //Время сделки в часовом поясе UTC по времени биржи.
+}
+
+func NewTrade(t *proto.Trade) *Trade {
+	return &Trade{
+		Figi:      t.Figi,
+		Direction: t.Direction,
+		Price:     NewDecimal(t.Price),
+		Quantity:  t.Quantity,
+		Time:      t.Time.AsTime(),
+	}
+}
+
+// канал получения обезличенных сделок
+type TradeChan chan *Trade